@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// The delays between retries, see backoff.
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// A backoff spaces out retries, e.g. of reconnecting to a log source:
+// the first retry is immediate, then the delay doubles from minBackoff
+// up to maxBackoff. The zero value is ready to use.
+type backoff struct {
+	delay time.Duration
+}
+
+// next returns the delay before the next retry.
+func (b *backoff) next() time.Duration {
+	d := b.delay
+	switch {
+	case b.delay == 0:
+		b.delay = minBackoff
+	case b.delay < maxBackoff:
+		b.delay *= 2
+		if b.delay > maxBackoff {
+			b.delay = maxBackoff
+		}
+	}
+
+	return d
+}
+
+// Wait waits until the next retry, or until `ctx` is done.
+func (b *backoff) Wait(ctx context.Context) error {
+	d := b.next()
+	if d == 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileFlag is the name of the flag of the config file, which
+// cannot be set in it.
+const configFileFlag = "config.file"
+
+// A config holds the settings of the --config.file.
+type config struct {
+	// Instances replace --postfix.instance and --postfix.showq-path.
+	Instances []instanceConfig `yaml:"instances"`
+	// Flags are used for the flags not given on the command line, by
+	// name (without the leading dashes).
+	Flags map[string]flagValues `yaml:"flags"`
+
+	Buckets       bucketsConfig `yaml:"buckets"`
+	CustomMetrics []*customRule `yaml:"custom_metrics"`
+
+	given map[string]bool // flags given on the command line, by name
+}
+
+// instanceArgs returns the instance names and the values of
+// --postfix.showq-path of the instances in the config.
+func (cfg *config) instanceArgs() (names, showqPaths []string) {
+	for _, inst := range cfg.Instances {
+		names = append(names, inst.Name)
+		if inst.ShowqPath != "" {
+			showqPaths = append(showqPaths, inst.Name+"="+inst.ShowqPath)
+		}
+	}
+
+	return names, showqPaths
+}
+
+// instanceLabels returns the static labels of the instances in the
+// config, by instance.
+func (cfg *config) instanceLabels() map[string]map[string]string {
+	labels := make(map[string]map[string]string)
+	for _, inst := range cfg.Instances {
+		if len(inst.Labels) > 0 {
+			labels[inst.Name] = inst.Labels
+		}
+	}
+
+	return labels
+}
+
+// An instanceConfig holds the settings of a Postfix instance.
+type instanceConfig struct {
+	Name      string            `yaml:"name"`
+	ShowqPath string            `yaml:"showq_path"`
+	Labels    map[string]string `yaml:"labels"` // added to all metrics of the instance
+}
+
+// A bucketsConfig holds the histogram buckets, the defaults are used if
+// empty.
+type bucketsConfig struct {
+	Delay []float64 `yaml:"delay"` // of the delays in seconds
+}
+
+// flagValues are the values of a flag in the config, which is either a
+// single value or a list for repeatable flags.
+type flagValues []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (v *flagValues) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode((*[]string)(v))
+	}
+
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	*v = flagValues{s}
+
+	return nil
+}
+
+// loadConfig reads the YAML config file at `path`.
+func loadConfig(path string) (*config, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// validate checks the settings which are not passed on as flags.
+func (cfg *config) validate() error {
+	for i, inst := range cfg.Instances {
+		if inst.Name == "" {
+			return fmt.Errorf("instance %d has no name", i+1)
+		}
+		for name := range inst.Labels {
+			if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) || name == "name" {
+				return fmt.Errorf("instance %s: invalid label name %q", inst.Name, name)
+			}
+		}
+	}
+	if len(cfg.Instances) > 0 {
+		for _, name := range []string{"postfix.instance", "postfix.showq-path"} {
+			if _, ok := cfg.Flags[name]; ok {
+				return fmt.Errorf("flag %q conflicts with instances", name)
+			}
+		}
+	}
+	for i, b := range cfg.Buckets.Delay {
+		if i > 0 && b <= cfg.Buckets.Delay[i-1] {
+			return errors.New("delay buckets are not in increasing order")
+		}
+	}
+
+	return nil
+}
+
+// configArgs returns the command line `args`, preceded by the flags set
+// in the --config.file given in them, if any, and the config. Flags
+// given on the command line override those in the config.
+func configArgs(app *kingpin.Application, args []string) ([]string, *config, error) {
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		// Reported when parsing the flags.
+		return args, nil, nil //nolint:nilerr
+	}
+
+	given := make(map[string]bool)
+	path := ""
+	for _, elem := range ctx.Elements {
+		flag, ok := elem.Clause.(*kingpin.FlagClause)
+		if !ok {
+			continue
+		}
+		name := flag.Model().Name
+		given[name] = true
+		if name == configFileFlag && elem.Value != nil {
+			path = *elem.Value
+		}
+	}
+	if path == "" {
+		return args, nil, nil
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg.given = given
+
+	var cfgArgs []string
+	instances, showqPaths := cfg.instanceArgs()
+	if !given["postfix.instance"] {
+		for _, name := range instances {
+			cfgArgs = append(cfgArgs, "--postfix.instance="+name)
+		}
+	}
+	if !given["postfix.showq-path"] {
+		for _, path := range showqPaths {
+			cfgArgs = append(cfgArgs, "--postfix.showq-path="+path)
+		}
+	}
+
+	names := make([]string, 0, len(cfg.Flags))
+	for name := range cfg.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		flag := app.GetFlag(name)
+		if flag == nil || name == configFileFlag {
+			return nil, nil, fmt.Errorf("%s: unknown flag %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		flagArgs, err := flagArgs(flag.Model(), cfg.Flags[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		cfgArgs = append(cfgArgs, flagArgs...)
+	}
+
+	return append(cfgArgs, args...), cfg, nil
+}
+
+// flagArgs returns the command line arguments setting a flag to
+// `values`.
+func flagArgs(flag *kingpin.FlagModel, values flagValues) ([]string, error) {
+	if c, ok := flag.Value.(interface{ IsCumulative() bool }); len(values) != 1 && (!ok || !c.IsCumulative()) {
+		return nil, fmt.Errorf("flag %q cannot be repeated", flag.Name)
+	}
+
+	args := make([]string, len(values))
+	for i, v := range values {
+		if b, ok := flag.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			switch v {
+			case "true":
+				args[i] = "--" + flag.Name
+			case "false":
+				args[i] = "--no-" + flag.Name
+			default:
+				return nil, fmt.Errorf("flag %q expects true or false, got %q", flag.Name, v)
+			}
+
+			continue
+		}
+		args[i] = "--" + flag.Name + "=" + v
+	}
+
+	return args, nil
+}
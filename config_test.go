@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestConfigArgs(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+instances:
+  - name: postfix
+  - name: postfix-out
+    showq_path: /var/spool/postfix-out/public/showq
+flags:
+  showq.timeout: 5s
+  showq.domain: [example.com, example.net]
+  web.probe: true
+  smtp.relay-label: false
+buckets:
+  delay: [0.1, 1, 10]
+`), 0o600))
+
+	app := kingpin.New("test", "")
+	app.Flag(configFileFlag, "").String()
+	instances := app.Flag("postfix.instance", "").Default("postfix").Strings()
+	showqPaths := app.Flag("postfix.showq-path", "").Strings()
+	timeout := app.Flag("showq.timeout", "").Default("10s").Duration()
+	domains := app.Flag("showq.domain", "").Strings()
+	probe := app.Flag("web.probe", "").Bool()
+	relayLabel := app.Flag("smtp.relay-label", "").Default("true").Bool()
+
+	args, cfg, err := configArgs(app, []string{"--config.file=" + path, "--showq.timeout=1s"})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 1, 10}, cfg.Buckets.Delay)
+	_, err = app.Parse(args)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"postfix", "postfix-out"}, *instances)
+	assert.Equal(t, []string{"postfix-out=/var/spool/postfix-out/public/showq"}, *showqPaths)
+	assert.Equal(t, "1s", timeout.String(), "Flags on the command line should override the config.")
+	assert.Equal(t, []string{"example.com", "example.net"}, *domains)
+	assert.True(t, *probe)
+	assert.False(t, *relayLabel)
+}
+
+func TestConfigArgs_Errors(t *testing.T) {
+	t.Parallel()
+
+	for name, content := range map[string]string{
+		"unknown flag":    "flags: {no.such-flag: 1}",
+		"repeated scalar": "flags: {showq.timeout: [1s, 2s]}",
+		"invalid bool":    "flags: {web.probe: maybe}",
+		"config file":     "flags: {config.file: other.yml}",
+		"instance name":   "instances: [{showq_path: /tmp/showq}]",
+		"conflict":        "instances: [{name: postfix}]\nflags: {postfix.instance: postfix}",
+		"buckets":         "buckets: {delay: [1, 0.1]}",
+		"label name":      "instances: [{name: postfix, labels: {name: other}}]",
+		"unknown field":   "instance: [{name: postfix}]",
+	} {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		app := kingpin.New("test", "")
+		app.Flag(configFileFlag, "").String()
+		app.Flag("postfix.instance", "").Strings()
+		app.Flag("showq.timeout", "").Duration()
+		app.Flag("web.probe", "").Bool()
+
+		_, _, err := configArgs(app, []string{"--config.file=" + path})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestConfigArgs_NoConfig(t *testing.T) {
+	t.Parallel()
+
+	app := kingpin.New("test", "")
+	app.Flag(configFileFlag, "").String()
+
+	args, cfg, err := configArgs(app, []string{})
+	require.NoError(t, err)
+	assert.Empty(t, args)
+	assert.Nil(t, cfg)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// A customRule maps log lines matching a regular expression to a
+// user-defined counter or histogram, like mtail does. The named groups
+// of the expression become labels, except for the `value` group.
+type customRule struct {
+	Name    string    `yaml:"name"`
+	Type    string    `yaml:"type"` // "counter" (default) or "histogram"
+	Help    string    `yaml:"help"`
+	Match   string    `yaml:"match"`
+	Value   string    `yaml:"value"` // named group to add or observe
+	Buckets []float64 `yaml:"buckets"`
+
+	re         *regexp.Regexp
+	labels     []int // indexes of the label groups
+	valueIndex int   // of the value group, or -1
+	counter    *prometheus.CounterVec
+	histogram  *prometheus.HistogramVec
+}
+
+// compile validates the rule and creates its metric.
+func (r *customRule) compile() error {
+	if !model.IsValidMetricName(model.LabelValue(r.Name)) {
+		return fmt.Errorf("invalid metric name %q", r.Name)
+	}
+	re, err := regexp.Compile(r.Match)
+	if err != nil {
+		return fmt.Errorf("metric %s: %w", r.Name, err)
+	}
+	r.re, r.valueIndex = re, -1
+
+	labels := []string{"name"}
+	for i, group := range re.SubexpNames() {
+		switch {
+		case group == "":
+			continue
+		case group == r.Value:
+			r.valueIndex = i
+
+			continue
+		case group == "name" || !model.LabelName(group).IsValid():
+			return fmt.Errorf("metric %s: invalid label name %q", r.Name, group)
+		}
+		r.labels = append(r.labels, i)
+		labels = append(labels, group)
+	}
+	if r.Value != "" && r.valueIndex < 0 {
+		return fmt.Errorf("metric %s: value group %q not found", r.Name, r.Value)
+	}
+
+	help := r.Help
+	if help == "" {
+		help = "Custom metric defined in the config file."
+	}
+	switch r.Type {
+	case "", "counter":
+		r.counter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: r.Name, Help: help}, labels)
+	case "histogram":
+		if r.valueIndex < 0 {
+			return fmt.Errorf("metric %s: histograms require a value group", r.Name)
+		}
+		buckets := r.Buckets
+		if buckets == nil {
+			buckets = prometheus.DefBuckets
+		}
+		r.histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: r.Name, Help: help, Buckets: buckets}, labels)
+	default:
+		return fmt.Errorf("metric %s: unsupported type %q", r.Name, r.Type)
+	}
+
+	return nil
+}
+
+// collect updates the metric if `line` matches. Counters are
+// incremented by the value group, or by one without one.
+func (r *customRule) collect(instance, line string) bool {
+	m := r.re.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+
+	labels := []string{instance}
+	for _, i := range r.labels {
+		labels = append(labels, m[i])
+	}
+	value := 1.0
+	if r.valueIndex >= 0 {
+		v, err := strconv.ParseFloat(m[r.valueIndex], 64)
+		if err != nil {
+			return false
+		}
+		value = v
+	}
+
+	if r.histogram != nil {
+		r.histogram.WithLabelValues(labels...).Observe(value)
+	} else if value >= 0 {
+		r.counter.WithLabelValues(labels...).Add(value)
+	}
+
+	return true
+}
+
+// customMetrics evaluates user-defined rules on log lines which the
+// built-in parser does not support.
+type customMetrics struct {
+	rules []*customRule
+}
+
+func newCustomMetrics(rules []*customRule) (*customMetrics, error) {
+	names := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+		if names[r.Name] {
+			return nil, errors.New("duplicate custom metric " + r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	return &customMetrics{rules: rules}, nil
+}
+
+// keep takes over the metrics of the rules of `old` which are unchanged
+// in `c`, so their values are kept when the config is reloaded.
+func (c *customMetrics) keep(old *customMetrics) {
+	if old == nil {
+		return
+	}
+
+	rules := make(map[string]*customRule, len(old.rules))
+	for _, r := range old.rules {
+		rules[r.Name] = r
+	}
+	for _, r := range c.rules {
+		o, ok := rules[r.Name]
+		if !ok || o.Type != r.Type || o.Help != r.Help || o.Match != r.Match || o.Value != r.Value || !reflect.DeepEqual(o.Buckets, r.Buckets) {
+			continue
+		}
+		r.counter, r.histogram = o.counter, o.histogram
+	}
+}
+
+// CollectFromLogLine updates the metrics of all rules matching `line`,
+// and returns whether there was any.
+func (c *customMetrics) CollectFromLogLine(instance, line string) bool {
+	matched := false
+	for _, r := range c.rules {
+		if r.collect(instance, line) {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// Describe implements prometheus.Collector.
+func (c *customMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, r := range c.rules {
+		if r.histogram != nil {
+			r.histogram.Describe(ch)
+		} else {
+			r.counter.Describe(ch)
+		}
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *customMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, r := range c.rules {
+		if r.histogram != nil {
+			r.histogram.Collect(ch)
+		} else {
+			r.counter.Collect(ch)
+		}
+	}
+}
+
+// describe returns the descriptors of the metrics of `c`.
+func describe(c prometheus.Collector) []*prometheus.Desc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	var descs []*prometheus.Desc
+	for d := range ch {
+		descs = append(descs, d)
+	}
+
+	return descs
+}
+
+// A descCollector describes fixed metrics, but collects none. It is
+// registered to check other collectors for collisions with them.
+type descCollector []*prometheus.Desc
+
+func (c descCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c {
+		ch <- d
+	}
+}
+
+func (descCollector) Collect(chan<- prometheus.Metric) {}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+custom_metrics:
+  - name: policyd_spf_results_total
+    help: Total number of SPF checks by result.
+    match: 'policyd-spf\[\d+\]: (?P<result>Pass|Fail|None|Softfail);'
+  - name: policyd_rate_limit_duration_seconds
+    type: histogram
+    match: 'policyd-rate\[\d+\]: checked in (?P<seconds>[\d.]+)s'
+    value: seconds
+    buckets: [0.01, 0.1, 1]
+`
+
+func TestCustomMetrics(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0o600))
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+	cm, err := newCustomMetrics(cfg.CustomMetrics)
+	require.NoError(t, err)
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetCustomMetrics(cm)
+
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail policyd-spf[1234]: Pass; identity=mailfrom; client-ip=192.0.2.1")
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail policyd-spf[1234]: Fail; identity=mailfrom; client-ip=192.0.2.2")
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail policyd-rate[99]: checked in 0.05s")
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail policyd-other[99]: something")
+
+	expected := `
+# HELP policyd_spf_results_total Total number of SPF checks by result.
+# TYPE policyd_spf_results_total counter
+policyd_spf_results_total{name="postfix",result="Fail"} 1
+policyd_spf_results_total{name="postfix",result="Pass"} 1
+# HELP policyd_rate_limit_duration_seconds Custom metric defined in the config file.
+# TYPE policyd_rate_limit_duration_seconds histogram
+policyd_rate_limit_duration_seconds_bucket{name="postfix",le="0.01"} 0
+policyd_rate_limit_duration_seconds_bucket{name="postfix",le="0.1"} 1
+policyd_rate_limit_duration_seconds_bucket{name="postfix",le="1"} 1
+policyd_rate_limit_duration_seconds_bucket{name="postfix",le="+Inf"} 1
+policyd_rate_limit_duration_seconds_sum{name="postfix"} 0.05
+policyd_rate_limit_duration_seconds_count{name="postfix"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(cm, strings.NewReader(expected)))
+	assert.Equal(t, 1, testutil.CollectAndCount(ex.unsupportedLogEntries), "Only unmatched lines should be unsupported.")
+}
+
+func TestCustomMetrics_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, r := range []*customRule{
+		{Name: "invalid-name", Match: "x"},
+		{Name: "bad_regexp", Match: "("},
+		{Name: "reserved_label", Match: "(?P<name>x)"},
+		{Name: "missing_value", Match: "x", Value: "v"},
+		{Name: "histogram_without_value", Type: "histogram", Match: "x"},
+		{Name: "unknown_type", Type: "gauge", Match: "x"},
+	} {
+		_, err := newCustomMetrics([]*customRule{r})
+		assert.Error(t, err, r.Name)
+	}
+
+	_, err := newCustomMetrics([]*customRule{{Name: "dup", Match: "a"}, {Name: "dup", Match: "b"}})
+	assert.Error(t, err)
+}
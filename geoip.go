@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// A geoIPDB looks up the country of IP addresses in a MaxMind DB file,
+// e.g. GeoLite2-Country or GeoIP2-City.
+type geoIPDB struct {
+	r *maxminddb.Reader
+}
+
+// geoIPRecord holds the fields of a MaxMind DB record needed for the
+// country lookup.
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// openGeoIPDB opens the MaxMind DB file at `path`.
+func openGeoIPDB(path string) (*geoIPDB, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB %s: %w", path, err)
+	}
+
+	return &geoIPDB{r: r}, nil
+}
+
+// Close closes the underlying file.
+func (db *geoIPDB) Close() error {
+	return db.r.Close()
+}
+
+// Country returns the ISO 3166 country code of `ip`, or the empty string
+// if unknown. The registered country is used if the record has no
+// country.
+func (db *geoIPDB) Country(ip net.IP) string {
+	var record geoIPRecord
+	if err := db.r.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+
+	return record.RegisteredCountry.ISOCode
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/geoip.mmdb was written with github.com/maxmind/mmdbwriter and
+// maps documentation networks to countries.
+
+func TestGeoIPDB_Country(t *testing.T) {
+	t.Parallel()
+
+	db, err := openGeoIPDB("testdata/geoip.mmdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	for ip, expected := range map[string]string{
+		"192.0.2.7":      "DE",
+		"198.51.100.1":   "NL",
+		"198.51.100.200": "",
+		"203.0.113.9":    "AT", // registered country only
+		"2001:db8::1":    "FR",
+		"2001:db9::1":    "",
+	} {
+		assert.Equal(t, expected, db.Country(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestPostfixExporter_GeoIP(t *testing.T) {
+	t.Parallel()
+
+	db, err := openGeoIPDB("testdata/geoip.mmdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetGeoIP(db)
+
+	ex.CollectFromLogLine("postfix", "Oct  3 10:10:00 mail postfix/smtpd[9912]: connect from mail.example.com[192.0.2.1]")
+	ex.CollectFromLogLine("postfix", "Oct  3 10:10:00 mail postfix/smtpd[9912]: NOQUEUE: reject: RCPT from unknown[198.51.100.200]: 554 5.7.1 <b@example.org>: Relay access denied; from=<a@example.com> to=<b@example.org> proto=ESMTP helo=<example.com>")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpdConnects.WithLabelValues("postfix", "smtpd", "DE")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpdRejects.WithLabelValues("postfix", "smtpd", "rcpt", "554", "relay_denied", "")))
+}
+
+func TestGeoIPDB_Invalid(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o600))
+	_, err := openGeoIPDB(path)
+	assert.Error(t, err)
+
+	buf, err := os.ReadFile("testdata/geoip.mmdb")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, buf[len(buf)-40:], 0o600))
+	_, err = openGeoIPDB(path)
+	assert.Error(t, err, "Truncated databases should be rejected.")
+}
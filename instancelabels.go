@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// An instanceLabelsGatherer adds static labels configured per instance
+// (e.g. role="outbound") to the metrics of another Gatherer which are
+// labeled by that instance, to ease aggregating them across servers.
+// Labels a metric already has are not replaced.
+type instanceLabelsGatherer struct {
+	g prometheus.Gatherer
+
+	mu     sync.Mutex
+	labels map[string]map[string]string // by instance
+}
+
+func newInstanceLabelsGatherer(g prometheus.Gatherer, labels map[string]map[string]string) *instanceLabelsGatherer {
+	return &instanceLabelsGatherer{g: g, labels: labels}
+}
+
+// SetLabels replaces the labels, e.g. when the config is reloaded.
+func (l *instanceLabelsGatherer) SetLabels(labels map[string]map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.labels = labels
+}
+
+// Gather implements prometheus.Gatherer.
+func (l *instanceLabelsGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := l.g.Gather()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.labels) == 0 {
+		return mfs, err
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			l.addLabels(m)
+		}
+	}
+
+	return mfs, err
+}
+
+// addLabels adds the labels of the instance in the name label of `m`.
+func (l *instanceLabelsGatherer) addLabels(m *dto.Metric) {
+	var labels map[string]string
+	for _, lp := range m.Label {
+		if lp.GetName() == "name" {
+			labels = l.labels[lp.GetValue()]
+
+			break
+		}
+	}
+	if len(labels) == 0 {
+		return
+	}
+
+	have := make(map[string]bool, len(m.Label))
+	for _, lp := range m.Label {
+		have[lp.GetName()] = true
+	}
+	for name, value := range labels {
+		if !have[name] {
+			m.Label = append(m.Label, &dto.LabelPair{Name: stringPtr(name), Value: stringPtr(value)})
+		}
+	}
+	sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceLabelsGatherer(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	removed := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "postfix_qmgr_messages_removed_total", Help: "Total number of messages removed from mail queues."}, []string{"name"})
+	removed.WithLabelValues("postfix").Add(1)
+	removed.WithLabelValues("postfix-out").Add(2)
+	actions := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "postfix_rspamd_actions_total", Help: "Total number of rspamd actions."}, []string{"action"})
+	actions.WithLabelValues("reject").Inc()
+	roles := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "postfix_test_total", Help: "Test."}, []string{"name", "role"})
+	roles.WithLabelValues("postfix-out", "relay").Inc()
+	reg.MustRegister(removed, actions, roles)
+
+	g := newInstanceLabelsGatherer(reg, map[string]map[string]string{
+		"postfix-out": {"role": "outbound", "dc": "fra1"},
+	})
+	expected := `
+# HELP postfix_qmgr_messages_removed_total Total number of messages removed from mail queues.
+# TYPE postfix_qmgr_messages_removed_total counter
+postfix_qmgr_messages_removed_total{name="postfix"} 1
+postfix_qmgr_messages_removed_total{dc="fra1",name="postfix-out",role="outbound"} 2
+# HELP postfix_rspamd_actions_total Total number of rspamd actions.
+# TYPE postfix_rspamd_actions_total counter
+postfix_rspamd_actions_total{action="reject"} 1
+# HELP postfix_test_total Test.
+# TYPE postfix_test_total counter
+postfix_test_total{dc="fra1",name="postfix-out",role="relay"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(g, strings.NewReader(expected)))
+
+	g.SetLabels(nil)
+	expected = `
+# HELP postfix_qmgr_messages_removed_total Total number of messages removed from mail queues.
+# TYPE postfix_qmgr_messages_removed_total counter
+postfix_qmgr_messages_removed_total{name="postfix"} 1
+postfix_qmgr_messages_removed_total{name="postfix-out"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(g, strings.NewReader(expected), "postfix_qmgr_messages_removed_total"))
+}
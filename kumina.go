@@ -0,0 +1,195 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// kuminaLabels lists the labels of the metrics of kumina/postfix_exporter,
+// by metric name. This exporter exports them with additional labels
+// (e.g. the instance name), which are summed up.
+var kuminaLabels = map[string][]string{
+	"postfix_cleanup_messages_processed_total":                 nil,
+	"postfix_cleanup_messages_rejected_total":                  nil,
+	"postfix_cleanup_messages_not_accepted_total":              nil,
+	"postfix_lmtp_delivery_delay_seconds":                      {"stage"},
+	"postfix_pipe_delivery_delay_seconds":                      {"relay", "stage"},
+	"postfix_qmgr_messages_inserted_receipients":               nil,
+	"postfix_qmgr_messages_inserted_size_bytes":                nil,
+	"postfix_qmgr_messages_removed_total":                      nil,
+	"postfix_showq_message_age_seconds":                        {"queue"},
+	"postfix_showq_message_size_bytes":                         {"queue"},
+	"postfix_smtp_connection_timed_out_total":                  nil,
+	"postfix_smtp_delivery_delay_seconds":                      {"stage"},
+	"postfix_smtp_tls_connections_total":                       {"trust", "protocol", "cipher", "secret_bits", "algorithm_bits"},
+	"postfix_smtpd_connections_lost_total":                     {"after_stage"},
+	"postfix_smtpd_connects_total":                             nil,
+	"postfix_smtpd_disconnects_total":                          nil,
+	"postfix_smtpd_forward_confirmed_reverse_dns_errors_total": nil,
+	"postfix_smtpd_messages_rejected_total":                    {"code"},
+	"postfix_smtpd_sasl_authentication_failures_total":         nil,
+	"postfix_smtpd_tls_connections_total":                      {"trust", "protocol", "cipher", "secret_bits", "algorithm_bits"},
+	"postfix_unsupported_log_entries_total":                    {"service"},
+}
+
+const (
+	kuminaProcessedName       = "postfix_smtpd_messages_processed_total"
+	kuminaSASLConnectionsName = "postfix_smtpd_sasl_connections_total"
+)
+
+// A kuminaGatherer exports the metrics of another Gatherer under the
+// names and labels of kumina/postfix_exporter, for dashboards and alerts
+// written for it. Other metrics are left unchanged.
+type kuminaGatherer struct {
+	g         prometheus.Gatherer
+	showqPath func(instance string) string // for the path label of postfix_up
+}
+
+func newKuminaGatherer(g prometheus.Gatherer, showqPath func(instance string) string) *kuminaGatherer {
+	return &kuminaGatherer{g: g, showqPath: showqPath}
+}
+
+// Gather implements prometheus.Gatherer.
+func (k *kuminaGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := k.g.Gather()
+
+	var sasl *dto.MetricFamily
+	for _, mf := range mfs {
+		if mf.GetName() == kuminaSASLConnectionsName {
+			sasl = mf
+		}
+	}
+	for _, mf := range mfs {
+		switch name := mf.GetName(); name {
+		case "postfix_up":
+			k.relabelUp(mf)
+		case kuminaProcessedName:
+			kuminaProcessed(mf, sasl)
+		default:
+			if labels, ok := kuminaLabels[name]; ok {
+				sumByLabels(mf, labels)
+			}
+		}
+	}
+
+	return mfs, err
+}
+
+// relabelUp replaces the name label of postfix_up by the path of the
+// showq socket.
+func (k *kuminaGatherer) relabelUp(mf *dto.MetricFamily) {
+	for _, m := range mf.Metric {
+		for _, l := range m.Label {
+			if l.GetName() == "name" {
+				l.Name = stringPtr("path")
+				l.Value = stringPtr(k.showqPath(l.GetValue()))
+			}
+		}
+	}
+	sort.Slice(mf.Metric, func(i, j int) bool {
+		return mf.Metric[i].Label[0].GetValue() < mf.Metric[j].Label[0].GetValue()
+	})
+}
+
+// kuminaProcessed replaces the processed messages by their number per
+// SASL method (empty for unauthenticated clients), from the SASL
+// connections.
+func kuminaProcessed(processed, sasl *dto.MetricFamily) {
+	sumByLabels(processed, nil)
+	total := 0.0
+	if len(processed.Metric) > 0 {
+		total = processed.Metric[0].GetCounter().GetValue()
+	}
+
+	var methods []*dto.Metric
+	if sasl != nil {
+		methods = sumMetrics(sasl.Metric, []string{"sasl_method"}, dto.MetricType_COUNTER)
+	}
+	for _, m := range methods {
+		total -= m.GetCounter().GetValue()
+	}
+	unauthenticated := &dto.Metric{
+		Label:   []*dto.LabelPair{{Name: stringPtr("sasl_method"), Value: stringPtr("")}},
+		Counter: &dto.Counter{Value: float64Ptr(total)},
+	}
+	processed.Metric = append([]*dto.Metric{unauthenticated}, methods...)
+}
+
+// sumByLabels sums up the metrics of a family with the same values of
+// `labels`, dropping all other labels.
+func sumByLabels(mf *dto.MetricFamily, labels []string) {
+	mf.Metric = sumMetrics(mf.Metric, labels, mf.GetType())
+}
+
+// sumMetrics sums up the metrics with the same values of `labels`,
+// ordered by them. The metrics are not modified. Histograms are summed
+// up bucket by bucket, and must have the same buckets.
+func sumMetrics(metrics []*dto.Metric, labels []string, typ dto.MetricType) []*dto.Metric {
+	sums := make(map[string]*dto.Metric)
+	var keys []string
+	for _, m := range metrics {
+		values := make(map[string]string, len(m.Label))
+		for _, l := range m.Label {
+			values[l.GetName()] = l.GetValue()
+		}
+		pairs := make([]*dto.LabelPair, len(labels))
+		key := make([]string, len(labels))
+		for i, name := range labels {
+			pairs[i] = &dto.LabelPair{Name: stringPtr(name), Value: stringPtr(values[name])}
+			key[i] = values[name]
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+
+		k := strings.Join(key, "\xff")
+		sum, ok := sums[k]
+		if !ok {
+			sums[k] = &dto.Metric{Label: pairs, Counter: m.Counter, Gauge: m.Gauge, Untyped: m.Untyped, Histogram: m.Histogram}
+			keys = append(keys, k)
+
+			continue
+		}
+		addMetric(sum, m, typ)
+	}
+
+	sort.Strings(keys)
+	result := make([]*dto.Metric, len(keys))
+	for i, k := range keys {
+		result[i] = sums[k]
+	}
+
+	return result
+}
+
+// addMetric adds the value of `m` to `sum`.
+func addMetric(sum, m *dto.Metric, typ dto.MetricType) {
+	switch typ { //nolint:exhaustive // summaries are not exported
+	case dto.MetricType_COUNTER:
+		sum.Counter = &dto.Counter{Value: float64Ptr(sum.GetCounter().GetValue() + m.GetCounter().GetValue())}
+	case dto.MetricType_GAUGE:
+		sum.Gauge = &dto.Gauge{Value: float64Ptr(sum.GetGauge().GetValue() + m.GetGauge().GetValue())}
+	case dto.MetricType_UNTYPED:
+		sum.Untyped = &dto.Untyped{Value: float64Ptr(sum.GetUntyped().GetValue() + m.GetUntyped().GetValue())}
+	case dto.MetricType_HISTOGRAM:
+		h, o := sum.GetHistogram(), m.GetHistogram()
+		buckets := make([]*dto.Bucket, len(h.Bucket))
+		for i, b := range h.Bucket {
+			count := b.GetCumulativeCount()
+			if i < len(o.Bucket) {
+				count += o.Bucket[i].GetCumulativeCount()
+			}
+			buckets[i] = &dto.Bucket{UpperBound: b.UpperBound, CumulativeCount: uint64Ptr(count)}
+		}
+		sum.Histogram = &dto.Histogram{
+			SampleCount: uint64Ptr(h.GetSampleCount() + o.GetSampleCount()),
+			SampleSum:   float64Ptr(h.GetSampleSum() + o.GetSampleSum()),
+			Bucket:      buckets,
+		}
+	}
+}
+
+func stringPtr(s string) *string    { return &s }
+func float64Ptr(f float64) *float64 { return &f }
+func uint64Ptr(u uint64) *uint64    { return &u }
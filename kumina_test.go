@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKuminaGatherer(t *testing.T) {
+	t.Parallel()
+
+	e, err := NewPostfixExporter([]string{"postfix", "postfix-out"}, nil, false)
+	require.NoError(t, err)
+	e.SetShowqPaths(map[string]string{"postfix-out": "/var/spool/postfix-out/public/showq"})
+
+	reg := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "postfix_up", Help: "Whether scraping Postfix's metrics was successful."}, []string{"name"})
+	up.WithLabelValues("postfix").Set(1)
+	up.WithLabelValues("postfix-out").Set(0)
+	delays := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "postfix_smtp_delivery_delay_seconds", Help: "SMTP message processing time in seconds.", Buckets: []float64{1, 10}}, []string{"name", "stage"})
+	delays.WithLabelValues("postfix", "queue_manager").Observe(0.5)
+	delays.WithLabelValues("postfix-out", "queue_manager").Observe(5)
+	processed := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "postfix_smtpd_messages_processed_total", Help: "Total number of messages processed."}, []string{"name", "service"})
+	processed.WithLabelValues("postfix", "smtpd").Add(5)
+	processed.WithLabelValues("postfix", "submission/smtpd").Add(3)
+	sasl := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "postfix_smtpd_sasl_connections_total", Help: "Total number of messages processed from SASL authenticated clients."}, []string{"name", "service", "sasl_method"})
+	sasl.WithLabelValues("postfix", "submission/smtpd", "PLAIN").Add(2)
+	sasl.WithLabelValues("postfix", "submission/smtpd", "LOGIN").Add(1)
+	warnings := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "postfix_warnings_total", Help: "Total number of warnings."}, []string{"name"})
+	warnings.WithLabelValues("postfix").Inc()
+	reg.MustRegister(up, delays, processed, sasl, warnings)
+
+	expected := `
+# HELP postfix_smtp_delivery_delay_seconds SMTP message processing time in seconds.
+# TYPE postfix_smtp_delivery_delay_seconds histogram
+postfix_smtp_delivery_delay_seconds_bucket{stage="queue_manager",le="1"} 1
+postfix_smtp_delivery_delay_seconds_bucket{stage="queue_manager",le="10"} 2
+postfix_smtp_delivery_delay_seconds_bucket{stage="queue_manager",le="+Inf"} 2
+postfix_smtp_delivery_delay_seconds_sum{stage="queue_manager"} 5.5
+postfix_smtp_delivery_delay_seconds_count{stage="queue_manager"} 2
+# HELP postfix_smtpd_messages_processed_total Total number of messages processed.
+# TYPE postfix_smtpd_messages_processed_total counter
+postfix_smtpd_messages_processed_total{sasl_method=""} 5
+postfix_smtpd_messages_processed_total{sasl_method="LOGIN"} 1
+postfix_smtpd_messages_processed_total{sasl_method="PLAIN"} 2
+# HELP postfix_up Whether scraping Postfix's metrics was successful.
+# TYPE postfix_up gauge
+postfix_up{path="/var/spool/postfix-out/public/showq"} 0
+postfix_up{path="/var/spool/postfix/public/showq"} 1
+# HELP postfix_warnings_total Total number of warnings.
+# TYPE postfix_warnings_total counter
+postfix_warnings_total{name="postfix"} 1
+`
+	err = testutil.GatherAndCompare(newKuminaGatherer(reg, e.showqPath), strings.NewReader(expected),
+		"postfix_smtp_delivery_delay_seconds", "postfix_smtpd_messages_processed_total", "postfix_up", "postfix_warnings_total")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// defaultMaxDomains is the default number of distinct domains used as
+// label values, per metric.
+const defaultMaxDomains = 100
+
+// defaultMaxSASLUsers is the default number of distinct SASL user names
+// used as label values.
+const defaultMaxSASLUsers = 100
+
+// otherLabelValue replaces label values beyond the limit of a
+// labelLimiter.
+const otherLabelValue = "other"
+
+// A labelLimiter caps the number of distinct values of a label, to keep
+// the cardinality of metrics labeled e.g. by domain bounded. Once the
+// limit is reached, new values are replaced by "other".
+type labelLimiter struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]struct{}
+}
+
+func newLabelLimiter(max int) *labelLimiter {
+	return &labelLimiter{
+		max:  max,
+		seen: make(map[string]struct{}),
+	}
+}
+
+// Value returns `v` if it has been seen before or the limit is not yet
+// reached, and "other" otherwise. The empty value is always returned
+// unchanged.
+func (l *labelLimiter) Value(v string) string {
+	if v == "" {
+		return v
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		return otherLabelValue
+	}
+	l.seen[v] = struct{}{}
+
+	return v
+}
+
+// SetMax changes the limit. Values already seen are retained.
+func (l *labelLimiter) SetMax(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.max = max
+}
+
+// matchDomain returns the entry of `domains` which `domain` is equal to
+// or a subdomain of. Returns the empty string if there is none.
+func matchDomain(domain string, domains []string) string {
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return d
+		}
+	}
+
+	return ""
+}
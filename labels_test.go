@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelLimiter(t *testing.T) {
+	t.Parallel()
+
+	l := newLabelLimiter(2)
+	assert.Equal(t, "a.example", l.Value("a.example"))
+	assert.Equal(t, "b.example", l.Value("b.example"))
+	assert.Equal(t, "other", l.Value("c.example"), "Values beyond the limit should be replaced.")
+	assert.Equal(t, "a.example", l.Value("a.example"), "Known values should be retained.")
+	assert.Equal(t, "", l.Value(""))
+}
+
+func TestMatchDomain(t *testing.T) {
+	t.Parallel()
+
+	domains := []string{"gmail.com", "outlook.com"}
+	assert.Equal(t, "gmail.com", matchDomain("gmail.com", domains))
+	assert.Equal(t, "outlook.com", matchDomain("eur.outlook.com", domains), "Subdomains should match.")
+	assert.Equal(t, "", matchDomain("notgmail.com", domains))
+}
@@ -0,0 +1,153 @@
+// Package lineparser defines the interface of parsers for log lines,
+// e.g. of milters, and a registry of them.
+//
+// Each log line is passed to a pipeline of the parsers registered for
+// its subprocess: the exporter's own parser, which is registered like
+// the others, followed by the rest. All of them see every line,
+// including lines supported by an earlier parser. A subprocess's
+// parsers, including the exporter's own, can be replaced with Override.
+//
+// Like custom log sources, parsers are compiled into the exporter by
+// registering them from an `init` function, and importing the package
+// containing them into the exporter's main package. Their metrics are
+// exported along with the exporter's.
+package lineparser
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A Line is a log line passed to a LineParser.
+type Line struct {
+	// Instance is the name of the Postfix instance, e.g. "postfix".
+	Instance string
+
+	// Subprocess is the Postfix daemon (e.g. "smtpd") of Postfix log
+	// lines, or the syslog program name (e.g. "opendmarc") of other
+	// log lines.
+	Subprocess string
+
+	// Service is the master.cf service name of Postfix log lines, e.g.
+	// "submission".
+	Service string
+
+	// QueueID is the queue ID the message starts with, if any.
+	QueueID string
+
+	// Timestamp is the time of the log line. It is zero if unknown.
+	Timestamp time.Time
+
+	// Message is the log line without the syslog header.
+	Message string
+
+	// Raw is the full log line.
+	Raw string
+}
+
+// A LineParser parses log lines and exports metrics about them.
+type LineParser interface {
+	prometheus.Collector
+
+	// ParseLine parses a log line of a subprocess the parser is
+	// registered for, and reports whether it was supported. Lines not
+	// supported by any parser of the pipeline are counted as
+	// unsupported. It is called concurrently for multiple Postfix
+	// instances.
+	ParseLine(Line) bool
+}
+
+var (
+	mu        sync.Mutex
+	parsers   = make(map[string][]LineParser)
+	overrides = make(map[string][]LineParser)
+)
+
+// Register can be called from module `init` functions to register a
+// parser for the log lines of `subprocess`. It is passed all of them,
+// after the exporter's own parser. A parser may be registered for
+// multiple subprocesses; it must be comparable, e.g. a pointer.
+func Register(subprocess string, p LineParser) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	parsers[subprocess] = append(parsers[subprocess], p)
+}
+
+// Override is like Register, but the parsers registered for
+// `subprocess` with Register, before or after, are no longer used. This
+// replaces the exporter's own parser of the subprocess, whose lines are
+// then only passed to the parsers registered with Override.
+func Override(subprocess string, p LineParser) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	overrides[subprocess] = append(overrides[subprocess], p)
+}
+
+// ForSubprocess returns the parsers used for `subprocess`, in
+// registration order.
+func ForSubprocess(subprocess string) []LineParser {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]LineParser(nil), forSubprocess(subprocess)...)
+}
+
+// forSubprocess returns the parsers used for `subprocess`. The caller
+// must hold mu.
+func forSubprocess(subprocess string) []LineParser {
+	if ps, ok := overrides[subprocess]; ok {
+		return ps
+	}
+
+	return parsers[subprocess]
+}
+
+// Subprocesses returns the subprocesses parsers are used for, sorted.
+func Subprocesses() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return subprocesses()
+}
+
+// subprocesses returns the sorted subprocesses parsers are used for.
+// The caller must hold mu.
+func subprocesses() []string {
+	all := make([]string, 0, len(parsers)+len(overrides))
+	for s := range parsers {
+		all = append(all, s)
+	}
+	for s := range overrides {
+		if _, ok := parsers[s]; !ok {
+			all = append(all, s)
+		}
+	}
+	sort.Strings(all)
+
+	return all
+}
+
+// All returns all used parsers, each once, ordered by the subprocess
+// they were first registered for.
+func All() []LineParser {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var all []LineParser
+	seen := make(map[LineParser]bool)
+	for _, s := range subprocesses() {
+		for _, p := range forSubprocess(s) {
+			if !seen[p] {
+				seen[p] = true
+				all = append(all, p)
+			}
+		}
+	}
+
+	return all
+}
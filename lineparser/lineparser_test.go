@@ -0,0 +1,45 @@
+package lineparser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+type testParser struct {
+	prometheus.Counter
+}
+
+func (p testParser) ParseLine(l Line) bool {
+	if !strings.HasPrefix(l.Message, "test ") {
+		return false
+	}
+	p.Inc()
+
+	return true
+}
+
+func TestRegister(t *testing.T) {
+	p := testParser{prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Test."})}
+	Register("test-milter", p)
+	Register("test-milter2", p)
+
+	assert.Equal(t, []LineParser{p}, ForSubprocess("test-milter"))
+	assert.Empty(t, ForSubprocess("smtpd"))
+	assert.Equal(t, []LineParser{p}, All(), "Parsers registered twice should be returned once.")
+	assert.True(t, p.ParseLine(Line{Subprocess: "test-milter", Message: "test line"}))
+}
+
+func TestOverride(t *testing.T) {
+	p := testParser{prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Test."})}
+	override := testParser{prometheus.NewCounter(prometheus.CounterOpts{Name: "test_override_total", Help: "Test."})}
+	Register("test-override", p)
+	Override("test-override", override)
+	Register("test-override", p)
+
+	assert.Equal(t, []LineParser{override}, ForSubprocess("test-override"))
+	assert.Contains(t, Subprocesses(), "test-override")
+	assert.Contains(t, All(), LineParser(override))
+}
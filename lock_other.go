@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+// fileLocked is not supported on this platform. It always returns
+// errFileLocksUnsupported.
+func fileLocked(path string) (bool, error) {
+	return false, errFileLocksUnsupported
+}
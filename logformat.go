@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/digineo/postfix_exporter/logsource"
+)
+
+// Supported values for --log.format.
+const (
+	logFormatPlain = "plain"
+	logFormatJSON  = "json"
+)
+
+// Field names to look for in JSON log lines, in order of precedence.
+// This covers rsyslog's and Vector's JSON templates, journald exports
+// and Docker's json-file logging driver.
+var (
+	jsonMessageFields = []string{"message", "msg", "MESSAGE", "log"}
+	jsonHostFields    = []string{"hostname", "host", "_HOSTNAME"}
+	jsonProgramFields = []string{"programname", "appname", "app_name", "program", "SYSLOG_IDENTIFIER"}
+	jsonPIDFields     = []string{"procid", "pid", "_PID"}
+)
+
+// A jsonLogSource decodes lines which are JSON objects into plain
+// syslog lines.
+type jsonLogSource struct {
+	logsource.LogSourceCloser
+}
+
+// newFormatLogSource wraps the log source, depending on the log format.
+func newFormatLogSource(src logsource.LogSourceCloser, format string) (logsource.LogSourceCloser, error) {
+	switch format {
+	case logFormatPlain:
+		return src, nil
+	case logFormatJSON:
+		return &jsonLogSource{src}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+func (s *jsonLogSource) Read(ctx context.Context) (string, error) {
+	line, err := s.LogSourceCloser.Read(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return decodeJSONLogLine(line), nil
+}
+
+// decodeJSONLogLine extracts the message from a JSON log line. If the
+// program name is given separately, a syslog-like line is assembled
+// from it, as the message then lacks the syslog prefix. Lines which
+// can't be decoded are returned as they are.
+func decodeJSONLogLine(line string) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return line
+	}
+
+	msg := jsonField(fields, jsonMessageFields)
+	msg = strings.TrimSpace(msg)
+
+	program := jsonField(fields, jsonProgramFields)
+	if program == "" {
+		return msg
+	}
+
+	host := jsonField(fields, jsonHostFields)
+	if host == "" {
+		host = "-"
+	}
+	pid := jsonField(fields, jsonPIDFields)
+	if pid == "" {
+		pid = "0"
+	}
+
+	return fmt.Sprintf("%s %s[%s]: %s", host, program, pid, msg)
+}
+
+// jsonField returns the value of the first present field in `names`.
+func jsonField(fields map[string]interface{}, names []string) string {
+	for _, name := range names {
+		switch v := fields[name].(type) {
+		case string:
+			return v
+		case float64:
+			return fmt.Sprintf("%.0f", v)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJSONLogLine(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		line, expected string
+	}{
+		"rsyslog": {
+			line:     `{"timestamp":"2021-09-24T12:00:00+02:00","hostname":"ahost","programname":"postfix/smtpd","procid":"123","message":" connect from unknown[1.2.3.4]"}`,
+			expected: "ahost postfix/smtpd[123]: connect from unknown[1.2.3.4]",
+		},
+		"vector": {
+			line:     `{"host":"ahost","appname":"postfix/qmgr","procid":123,"message":"AAB4D259B1: removed"}`,
+			expected: "ahost postfix/qmgr[123]: AAB4D259B1: removed",
+		},
+		"docker": {
+			line:     `{"log":"Feb 11 16:49:24 letterman postfix/qmgr[8204]: AAB4D259B1: removed\n","stream":"stdout","time":"2021-09-24T10:00:00.000000000Z"}`,
+			expected: "Feb 11 16:49:24 letterman postfix/qmgr[8204]: AAB4D259B1: removed",
+		},
+		"no json": {
+			line:     "Feb 11 16:49:24 letterman postfix/qmgr[8204]: AAB4D259B1: removed",
+			expected: "Feb 11 16:49:24 letterman postfix/qmgr[8204]: AAB4D259B1: removed",
+		},
+	} {
+		assert.Equal(t, tc.expected, decodeJSONLogLine(tc.line), name)
+	}
+
+	result := parseLogLine("postfix", decodeJSONLogLine(`{"host":"ahost","appname":"postfix/qmgr","procid":123,"message":"AAB4D259B1: removed"}`))
+	assert.True(t, result.qmgr.removed, "Decoded lines should be parsable.")
+}
@@ -2,27 +2,362 @@ package main
 
 import (
 	"log"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Patterns for parsing log messages.
 var (
-	logLine                             = regexp.MustCompile(` ?(postfix(?:-\w+)?)(?:/(\w+))?\[\d+\]: (.*)`)
+	logLine                             = regexp.MustCompile(` ?(postfix(?:-\w+)?)(?:/([\w/-]+))?(?:\[\d+\])?: (.*)`)
+	rfc5424Line                         = regexp.MustCompile(`^<(\d{1,3})>(\d{1,2}) (\S+) (\S+) (\S+) (\S+) (\S+) (-|(?:\[(?:[^\]\\]|\\.)*\])+)(?: (?:\x{FEFF})?(.*))?$`)
+	postfixAppName                      = regexp.MustCompile(`^(postfix(?:-\w+)?)(?:/([\w/-]+))?$`)
+	syslogLine                          = regexp.MustCompile(`^(?:<\d{1,3}>)?(?:(?:[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d(?:\.\d+)?|\d{4}-\d\d-\d\dT\S+) \S+ )?([\w.-]+)(?:\[\d+\])?: (.*)$`)
+	rfc3339Prefix                       = regexp.MustCompile(`^(?:<\d{1,3}>)?(\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(?:\.\d+)?(?:Z|[+-]\d\d:\d\d)) `)
+	amavisVerdictLine                   = regexp.MustCompile(`^\([\w-]+\) (Passed|Blocked) ([A-Z]+(?:-[A-Z]+)*)`)
+	amavisElapsedLine                   = regexp.MustCompile(`, (\d+) ms$`)
+	queueIDLine                         = regexp.MustCompile(`^([0-9A-F]{6,}|[0-9B-DF-HJ-NP-TV-Zb-df-hj-np-tv-z]{10,}): `)
+	smtpdSSLAcceptErrorLine             = regexp.MustCompile(`^SSL_accept error from [^\s\[]*\[[^\]]+\](?::\d+)?: (.*)$`)
+	smtpSSLConnectErrorLine             = regexp.MustCompile(`^(?:\w+: )?SSL_connect error to ([^\s\[]*)\[[^\]]+\](?::\d+)?: (.*)$`)
+	tlsCertificateLoadLine              = regexp.MustCompile(`^warning: cannot (?:load|get) .*(?:certificate|private key|key data)`)
+	warningLine                         = regexp.MustCompile(`^(?:\w+: )?warning: (.*)`)
+	fatalLine                           = regexp.MustCompile(`^(?:\w+: )?(fatal|panic): `)
+	bounceNotificationLine              = regexp.MustCompile(`^\w+: (sender|postmaster) (non-delivery|delay|delivery status) notification: \w+$`)
+	dovecotLMTPLine                     = regexp.MustCompile(`^lmtp\([^)]*\)(?:<[^>]*>)*: (?:msgid=.*?: )?(saved mail to|save failed to) `)
+	dovecotLoginFailureLine             = regexp.MustCompile(`^([\w-]+-login): (?:Disconnected|Aborted login) \(auth failed, (\d+) attempts?`)
+	dovecotAuthFailureLine              = regexp.MustCompile(`^auth(?:-worker\(\d+\))?: (?:Info: )?\w+(?:-\w+)?\([^)]*\): (?:Password mismatch|unknown user|pam_authenticate\(\) failed)`)
 	lmtpPipeSMTPLine                    = regexp.MustCompile(`, relay=(\S+), .*, delays=([0-9\.]+)/([0-9\.]+)/([0-9\.]+)/([0-9\.]+), `)
+	opendkimSignedLine                  = regexp.MustCompile(`^\w+: DKIM-Signature (?:field|header) added \(s=\S+, d=([^)\s]+)\)`)
+	opendkimKeyRetrievalLine            = regexp.MustCompile(`^\w+: key retrieval failed \(s=\S+, d=([^)\s]+)\)`)
+	opendkimVerifiedLine                = regexp.MustCompile(`^\w+: DKIM verification successful`)
+	opendkimBadSignatureLine            = regexp.MustCompile(`^\w+: (?:s=\S+ d=(\S+) SSL error|bad signature data)`)
+	opendkimResultLine                  = regexp.MustCompile(`(?:^|[\s;])dkim=(\w+)`)
+	opendkimDomainField                 = regexp.MustCompile(`(?:^|[\s;(])(?:header\.)?d=([\w.-]+)`)
+	postgreyActionLine                  = regexp.MustCompile(`^action=(greylist|pass), `)
+	rspamdResultLine                    = regexp.MustCompile(`\(\S+: [FTS] \(([a-z ]+)\): \[(-?[\d.]+)/-?[\d.]+\]`)
+	postscreenConnectLine               = regexp.MustCompile(`^CONNECT from \[([^\]]+)\]:\d+ to \[`)
+	postscreenVerdictLine               = regexp.MustCompile(`^(PASS NEW|PASS OLD|PREGREET|HANGUP|DNSBL rank|(?:WHITE|ALLOW)LIST VETO|NOQUEUE: reject:) `)
+	qmgrExpiredLine                     = regexp.MustCompile(`: from=<[^>]*>, status=(expired|force-expired), returned to sender`)
+	qmgrSenderLine                      = regexp.MustCompile(`: from=<[^>]*@([^>@]+)>, `)
 	qmgrInsertLine                      = regexp.MustCompile(`:.*, size=(\d+), nrcpt=(\d+) `)
+	scacheLookupLine                    = regexp.MustCompile(`^statistics: (domain|address) lookup hits=(\d+) miss=(\d+) success=(\d+)%`)
+	scacheMaxLine                       = regexp.MustCompile(`^statistics: max simultaneous domains=(\d+) addresses=(\d+) connection=(\d+)`)
+	deliveryDSNLine                     = regexp.MustCompile(`, dsn=(\d\.\d{1,3}\.\d{1,3}), `)
+	remoteStatusLine                    = regexp.MustCompile(` said: \d{3}[ -]([245])\.(\d{1,3})\.(\d{1,3})\b`)
+	queuedAsLine                        = regexp.MustCompile(`\bqueued as ([0-9A-Za-z]+)\)?$`)
+	forwardedAsLine                     = regexp.MustCompile(`\(forwarded as ([0-9A-Za-z]+)\)$`)
+	bouncedLine                         = regexp.MustCompile(`, status=bounced \((.*)\)$`)
+	deliveryStatusLine                  = regexp.MustCompile(`: to=<[^>]*>, .*\bstatus=(\w+)`)
 	smtpStatusLine                      = regexp.MustCompile(`, status=(\w+)`)
-	smtpTLSLine                         = regexp.MustCompile(`^(\S+) TLS connection established to \S+: (\S+) with cipher (\S+) \((\d+)/(\d+) bits\)`)
-	smtpConnectionTimedOut              = regexp.MustCompile(`^connect\s+to\s+(.*)\[(.*)\]:(\d+):\s+(Connection timed out)$`)
+	smtpDeferredLine                    = regexp.MustCompile(`, status=deferred \((?:delivery temporarily suspended: )?(.*)\)$`)
+	deliveryRecipientLine               = regexp.MustCompile(`: to=<[^>]*@([^>@]+)>, `)
+	smtpTotalDelayLine                  = regexp.MustCompile(`, delay=([0-9\.]+), `)
+	smtpTLSLine                         = regexp.MustCompile(`^(\S+) TLS connection established to (\S+): (\S+) with cipher (\S+) \((\d+)/(\d+) bits\)`)
+	smtpConnectErrorLine                = regexp.MustCompile(`^connect\s+to\s+(.*)\[(.*)\]:(\d+):\s+(Connection timed out|Connection refused|Network is unreachable|No route to host)$`)
 	smtpdFCrDNSErrorsLine               = regexp.MustCompile(`^warning: hostname \S+ does not resolve to address `)
+	smtpdSASLUsernameField              = regexp.MustCompile(`, sasl_username=([^,\s]+)`)
 	smtpdProcessesSASLLine              = regexp.MustCompile(`: client=.*, sasl_method=([^,\s]+)?`)
-	smtpdRejectsLine                    = regexp.MustCompile(`^NOQUEUE: reject: RCPT from \S+: ([0-9]+) `)
+	smtpdClientLine                     = regexp.MustCompile(`(?:^connect from |^\w+: reject: [\w-]+ from |: client=)([^\s\[]*)\[([^\]]+)\]`)
+	rejectRestrictionLine               = regexp.MustCompile(`(?i)\b(client host|client|helo command|sender address|recipient address|data command|end-of-data|etrn command)(?: \[[^\]]*\])? (?:rejected|blocked using ([\w.-]+))`)
+	smtpdRateLimitLine                  = regexp.MustCompile(`^warning: (Connection rate|Connection concurrency|Message delivery request rate|Recipient address rate|New TLS session rate|AUTH command rate) limit exceeded: `)
+	smtpdRejectsLine                    = regexp.MustCompile(`^\w+: (reject|reject_warning): (CONNECT|HELO|EHLO|MAIL|RCPT|DATA|BDAT|END-OF-MESSAGE|VRFY|ETRN) from \S+: ([0-9]+) `)
+	smtpdProxyRejectLine                = regexp.MustCompile(`^(?:NOQUEUE|\w+): proxy-reject: [\w-]+: ([0-9]{3})[ -]`)
+	accessActionLine                    = regexp.MustCompile(`^\w+: (hold|discard|filter|redirect): `)
+	masterProcessLimitLine              = regexp.MustCompile(`^warning: service "([^"]+)" \([^)]*\) has reached its process limit `)
+	masterProcessExitLine               = regexp.MustCompile(`^warning: process \S*?([\w-]+) pid \d+ (exit status|killed by signal) (\d+)$`)
+	masterLifecycleLine                 = regexp.MustCompile(`^(daemon started|reload|terminating on signal \d+)(?: -- version ([^\s,]+))?`)
+	cleanupMessageIDLine                = regexp.MustCompile(`: message-id=<([^>]*)>?`)
+	milterErrorLine                     = regexp.MustCompile(`^(?:\w+: )?warning: (?:milter|connect to Milter service) (\S+?):? (.*)$`)
+	smtpdGreylistedLine                 = regexp.MustCompile(`: Recipient address rejected: Greylisted`)
+	smtpDNSErrorLine                    = regexp.MustCompile(`Name service error for name=\S+ type=\w+: ([^)]*)\)$`)
+	smtpTLSDestinationLine              = regexp.MustCompile(` TLS connection established to ([^\s\[]+)\[`)
+	smtpCertVerificationFailedLine      = regexp.MustCompile(`^(?:server )?certificate verification failed for ([^\s\[]+)\[`)
+	smtpLostConnectionLine              = regexp.MustCompile(`^(?:\w+: )?lost connection with \S+ while (.+?)(?: -- .*)?$`)
+	smtpdTimeoutLine                    = regexp.MustCompile(`^timeout after ([\w-]+)(?: \(\d+ bytes\))? from `)
 	smtpdLostConnectionLine             = regexp.MustCompile(`^lost connection after (\w+) from `)
 	smtpdSASLAuthenticationFailuresLine = regexp.MustCompile(`^warning: \S+: SASL \S+ authentication failed: `)
 	smtpdTLSLine                        = regexp.MustCompile(`^(\S+) TLS connection established from \S+: (\S+) with cipher (\S+) \((\d+)/(\d+) bits\)`)
 )
 
+// postscreenVerdicts maps the postscreen log prefixes to verdicts.
+var postscreenVerdicts = map[string]string{
+	"PASS NEW":         "pass_new",
+	"PASS OLD":         "pass_old",
+	"PREGREET":         "pregreet",
+	"HANGUP":           "hangup",
+	"DNSBL rank":       "dnsbl",
+	"NOQUEUE: reject:": "reject",
+	"WHITELIST VETO":   "whitelist_veto",
+	"ALLOWLIST VETO":   "whitelist_veto", // Postfix >= 3.6
+}
+
+// connectErrors maps the errors of outgoing connections to the values
+// of the error label.
+var connectErrors = map[string]string{
+	"Connection timed out":   "timed_out",
+	"Connection refused":     "refused",
+	"Network is unreachable": "network_unreachable",
+	"No route to host":       "no_route_to_host",
+}
+
+// rejectStages maps the SMTP commands of smtpd reject lines to the
+// values of the stage label.
+var rejectStages = map[string]string{
+	"CONNECT":        "connect",
+	"HELO":           "helo",
+	"EHLO":           "helo",
+	"MAIL":           "mail",
+	"RCPT":           "rcpt",
+	"DATA":           "data",
+	"BDAT":           "data",
+	"END-OF-MESSAGE": "end_of_message",
+	"VRFY":           "vrfy",
+	"ETRN":           "etrn",
+}
+
+// rejectReasons classifies reject messages, the first matching pattern
+// wins. Unmatched messages are classified as "other".
+var rejectReasons = []struct {
+	reason  string
+	pattern *regexp.Regexp
+}{
+	{"relay_denied", regexp.MustCompile(`Relay access denied`)},
+	{"unknown_user", regexp.MustCompile(`(?i)user unknown|unknown user|undeliverable address`)},
+	{"helo", regexp.MustCompile(`Helo command rejected`)},
+	{"spf", regexp.MustCompile(`\bSPF\b`)},
+	{"rbl", regexp.MustCompile(`blocked using |(?i)\b(?:rbl|dnsbl)\b`)},
+	{"rate_limit", regexp.MustCompile(`(?i)rate limit|too many`)},
+}
+
+// classifyRejectReason maps the text of a reject to a reason category.
+func classifyRejectReason(s string) string {
+	for _, r := range rejectReasons {
+		if r.pattern.MatchString(s) {
+			return r.reason
+		}
+	}
+
+	return "other"
+}
+
+// parseRejectRestriction returns the restriction that fired for a
+// reject message (e.g. "sender_address", or "relay" for relay access
+// denials), and the DNS blocklist it was blocked by, if any.
+func parseRejectRestriction(s string) (restriction, list string) {
+	if strings.Contains(s, "Relay access denied") {
+		return "relay", ""
+	}
+
+	m := rejectRestrictionLine.FindStringSubmatch(s)
+	if m == nil {
+		return "other", ""
+	}
+	restriction = strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(m[1]))
+	if restriction == "client" {
+		restriction = "client_host"
+	}
+
+	return restriction, strings.ToLower(m[2])
+}
+
+// deferredReasons classifies the reasons of deferred deliveries, the
+// first matching pattern wins. Unmatched reasons are classified as
+// "other".
+var deferredReasons = []struct {
+	reason  string
+	pattern *regexp.Regexp
+}{
+	{"connection_timed_out", regexp.MustCompile(`^connect to .*: Connection timed out$|^conversation with .* timed out `)},
+	{"connection_refused", regexp.MustCompile(`^connect to .*: Connection refused$`)},
+	{"lost_connection", regexp.MustCompile(`^lost connection with `)},
+	{"tls_failure", regexp.MustCompile(`^Cannot start TLS|^TLS is required|^Server certificate not (?:trusted|verified)|TLS handshake|^TLSA lookup error`)},
+	{"dns", regexp.MustCompile(`^Host or domain name not found|^Name service error|^unable to look up host `)},
+	{"remote_4xx_policy", regexp.MustCompile(`^host \S+ said: 4\d\d[ -]4\.7\.\d`)},
+	{"remote_4xx", regexp.MustCompile(`^host \S+ said: 4\d\d[ -]`)},
+}
+
+// classifyDeferredReason maps the reason of a deferred delivery to a
+// category.
+func classifyDeferredReason(s string) string {
+	for _, r := range deferredReasons {
+		if r.pattern.MatchString(s) {
+			return r.reason
+		}
+	}
+
+	return "other"
+}
+
+// bounceCategories classifies hard bounces by their DSN and reason,
+// e.g. "5.1.1 host mx.example.com said: 550 5.1.1 User unknown", the
+// first matching pattern wins. Unmatched bounces are classified as
+// "other".
+var bounceCategories = []struct {
+	category string
+	pattern  *regexp.Regexp
+}{
+	{"message_too_large", regexp.MustCompile(`(?i)^5\.3\.4 |^5\.2\.3 |message (?:size|file too big|too (?:large|big))|exceeds? (?:the )?(?:size|maximum)`)},
+	{"mailbox_full", regexp.MustCompile(`(?i)^5\.2\.2 |mailbox (?:is )?full|over ?quota|quota exceeded|insufficient (?:system )?storage`)},
+	{"user_unknown", regexp.MustCompile(`(?i)^5\.1\.[1-3] |user unknown|unknown user|no such (?:user|recipient|mailbox)|mailbox (?:unavailable|not found)|(?:address|recipient|user) (?:does not exist|not found)|recipient address rejected`)},
+	{"policy", regexp.MustCompile(`(?i)^5\.7\.\d+ |spam|blocked|block ?list|black ?list|\bpolicy\b|\brbl\b|spamhaus|reputation|\bdmarc\b|\bspf\b`)},
+}
+
+// classifyBounce maps the DSN and reason of a bounced delivery to a
+// category.
+func classifyBounce(dsn, reason string) string {
+	s := dsn + " " + reason
+	for _, c := range bounceCategories {
+		if c.pattern.MatchString(s) {
+			return c.category
+		}
+	}
+
+	return "other"
+}
+
+// mimeEvents classifies the error lines of smtpd, cleanup, smtp and
+// lmtp about SMTPUTF8 and 8-bit MIME content, the first matching
+// pattern wins. Postfix logs these only when something went wrong, e.g.
+// the smtp status=bounced (SMTPUTF8 is required, but was not offered by
+// host mx.example.com[192.0.2.1]) line; SMTPUTF8 sessions and
+// successful 8-bit to 7-bit conversions are not logged.
+var mimeEvents = []struct {
+	event   string
+	pattern *regexp.Regexp
+}{
+	{"smtputf8_required", regexp.MustCompile(`SMTPUTF8 is required|\b5\.6\.7 `)},
+	{"8bit_conversion_failed", regexp.MustCompile(`(?i)\b5\.6\.3 |8BITMIME|8-bit (?:content|data)|conversion (?:of 8-bit|to 7-bit)`)},
+}
+
+// classifyMIMEEvent returns the SMTPUTF8 or 8-bit MIME event of a line,
+// or an empty string.
+func classifyMIMEEvent(s string) string {
+	for _, e := range mimeEvents {
+		if e.pattern.MatchString(s) {
+			return e.event
+		}
+	}
+
+	return ""
+}
+
+// milterErrors classifies the failures of milters, e.g. "can't read
+// SMFIC_EOH reply packet header: Connection reset by peer", the first
+// matching pattern wins. Unmatched failures are classified as "other".
+var milterErrors = []struct {
+	category string
+	pattern  *regexp.Regexp
+}{
+	{"connection_refused", regexp.MustCompile(`(?i)connection refused|no such file or directory`)},
+	{"timeout", regexp.MustCompile(`(?i)time(?:d )?out`)},
+	{"connection_lost", regexp.MustCompile(`(?i)can't read|can't send|read error|connection reset|broken pipe|unexpected EOF`)},
+	{"protocol", regexp.MustCompile(`(?i)unexpected|unknown|unreasonable|bad|invalid|protocol`)},
+}
+
+// classifyMilterError maps the failure of a milter to a category.
+func classifyMilterError(s string) string {
+	for _, c := range milterErrors {
+		if c.pattern.MatchString(s) {
+			return c.category
+		}
+	}
+
+	return "other"
+}
+
+// tlsHandshakeErrors classifies the reasons of failed TLS handshakes,
+// the first matching pattern wins. Unmatched reasons are classified as
+// "other".
+var tlsHandshakeErrors = []struct {
+	category string
+	pattern  *regexp.Regexp
+}{
+	{"lost_connection", regexp.MustCompile(`(?i)^-?[01]$|lost connection|connection reset|broken pipe|unexpected eof`)},
+	{"timeout", regexp.MustCompile(`(?i)timed out|timeout`)},
+	{"certificate", regexp.MustCompile(`(?i)certificate|unknown ca|verify failed`)},
+	{"protocol", regexp.MustCompile(`(?i)unsupported protocol|wrong version number|no shared cipher|no protocols available|version too low|no suitable|handshake failure|inappropriate fallback|http request`)},
+}
+
+// classifyTLSHandshakeError maps the reason of a failed TLS handshake
+// to a category.
+func classifyTLSHandshakeError(s string) string {
+	for _, c := range tlsHandshakeErrors {
+		if c.pattern.MatchString(s) {
+			return c.category
+		}
+	}
+
+	return "other"
+}
+
+// warningCategories classifies warning lines, the first matching
+// pattern wins. Unmatched warnings are classified as "other".
+var warningCategories = []struct {
+	category string
+	pattern  *regexp.Regexp
+}{
+	{"tls", regexp.MustCompile(`(?i)\btls\b|\bssl\b|certificate|starttls`)},
+	{"auth", regexp.MustCompile(`(?i)\bsasl\b|authentication|password`)},
+	{"dns", regexp.MustCompile(`(?i)does not resolve|name service error|address not listed for hostname|hostname \S+ verification failed|host not found|\bdns`)},
+	{"resource", regexp.MustCompile(`(?i)process limit|no space left|insufficient|out of memory|too many open files|file system|disk`)},
+	{"config", regexp.MustCompile(`(?i)main\.cf|master\.cf|parameter|database \S+ is older than|unsupported dictionary type|lookup table|configuration`)},
+}
+
+// classifyWarning maps the message of a warning line to a category.
+func classifyWarning(s string) string {
+	for _, c := range warningCategories {
+		if c.pattern.MatchString(s) {
+			return c.category
+		}
+	}
+
+	return "other"
+}
+
+// dnsErrors maps the resolver errors logged by the SMTP client to error
+// types. Postfix logs resolver timeouts like SERVFAIL responses, as
+// "try again".
+var dnsErrors = map[string]string{
+	"Host not found":                                  "nxdomain",
+	"Host not found, try again":                       "servfail",
+	"Host found but no data record of requested type": "nodata",
+}
+
+// tlsPolicyFailures classifies deferred deliveries, for which the TLS
+// security level could not be satisfied, by the required level. The
+// reasons don't tell the levels "verify", "secure" and "dane-only" (with
+// usable TLSA records) apart, they are all reported as "verify".
+var tlsPolicyFailures = []struct {
+	policy  string
+	pattern *regexp.Regexp
+}{
+	{"dane", regexp.MustCompile(`^TLSA lookup error for |DANE`)},
+	{"verify", regexp.MustCompile(`^Server certificate not (?:verified|trusted)`)},
+	{"encrypt", regexp.MustCompile(`^TLS is required, but `)},
+}
+
+// tlsVerificationResults maps the trust level of TLS connections to the
+// result of the certificate verification.
+var tlsVerificationResults = map[string]string{
+	"Verified":  "verified",
+	"Trusted":   "verified",
+	"Untrusted": "untrusted",
+	"Anonymous": "untrusted",
+}
+
+// rateLimits maps the anvil limits logged by smtpd to label values.
+var rateLimits = map[string]string{
+	"Connection rate":               "connection_rate",
+	"Connection concurrency":        "connection_concurrency",
+	"Message delivery request rate": "message_rate",
+	"Recipient address rate":        "recipient_rate",
+	"New TLS session rate":          "tls_session_rate",
+	"AUTH command rate":             "auth_rate",
+}
+
 type delay struct {
 	beforeQueueManager, queueManager, connSetup, transmission float64
 }
@@ -30,153 +365,859 @@ type delay struct {
 // loglineResult holds the various fields extracted from a log line.
 type loglineResult struct {
 	process, subprocess string
+	service             string    // master.cf service name, e.g. "submission"
+	timestamp           time.Time // zero, if unknown
+	queueID             string
+	dsn                 string   // of delivery agents (lmtp, local, pipe, smtp, virtual)
+	status              string   // of the recipient of delivery agent lines, e.g. "sent"
+	remoteStatus        []string // class, subject and detail of the enhanced status code in the remote server's reply
+	bounceCategory      string   // of bounced recipients
+	mimeEvent           string   // SMTPUTF8 or 8-bit MIME event, see mimeEvents
+	queuedAs            string   // queue ID of the next hop of sent recipients, if it replied with one
+	forwardedAs         string   // queue ID of the message local(8) forwarded a sent recipient's copy as
+	milter, milterError string   // of milter warnings of smtpd and cleanup
+	accessAction        string   // non-reject action of access maps and header/body checks, e.g. "hold"
+	recipientDomain     string   // of delivery agent lines
+	warning             string   // category of warning lines
+	fatal, panic        bool
 	ignore              bool
 	unsupported         bool
+	program             string // syslog program name of lines not logged by Postfix
+	message             string // without the syslog header
+
+	amavis struct {
+		action, category string
+		elapsed          float64 // seconds, negative if unknown
+	}
+
+	bounce struct {
+		// notification is "non-delivery", "delay" or "delivery status",
+		// recipient is "sender" or "postmaster".
+		notification, recipient string
+	}
 
 	cleanup struct {
 		process, reject bool
+		messageID       string // of processed messages, without angle brackets
+	}
+
+	dovecot struct {
+		lmtpResult   string // "saved" or "failed"
+		authService  string
+		authFailures float64
 	}
 
 	lmtp struct {
 		delays *delay
 	}
 
+	master struct {
+		processLimit     string // master.cf service which reached its process limit
+		exitProgram      string // of processes which terminated abnormally
+		exitKind, status string // "exit" or "signal", and the exit status or signal number
+		lifecycle        string // "start", "reload" or "stop"
+		version          string // of start and reload lines
+	}
+
+	opendkim struct {
+		// result is "signed", "pass", "fail", "key_retrieval_failed"
+		// or any other dkim= result.
+		result, domain string
+	}
+
 	pipe struct {
 		relay  string
 		delays *delay
 	}
 
+	postgrey struct {
+		result string // "delay" or "pass"
+	}
+
+	postscreen struct {
+		connect  bool
+		clientIP string
+		verdict  string
+	}
+
+	rspamd struct {
+		action string
+		score  float64
+	}
+
 	qmgr struct {
-		size, nrcpt float64
-		removed     bool
+		inserted     bool
+		size, nrcpt  float64
+		senderDomain string // empty for the null sender
+		removed      bool
+		expired      string // "expired" or "force-expired"
+	}
+
+	scache struct {
+		lookup             string // "domain" or "address"
+		hits, misses       float64
+		hitRatio           float64
+		maxSimultaneous    bool
+		domains, addresses float64
+		connections        float64
 	}
 
 	smtp struct {
-		delays  *delay
-		status  string
-		tls     []string
-		timeout bool
+		delays         *delay
+		delay          float64 // total
+		relay          string  // host name only
+		ipFamily       string  // of the relay address, "ipv4" or "ipv6"
+		port           string  // of the relay
+		status         string
+		deferredReason string
+		tls            []string
+		timeout        bool
+		connectError   string // see connectErrors
+		lostConnection string // stage
+		dnsError       string
+		tlsPolicy      string // unsatisfied TLS security level of deferred deliveries
+
+		tlsDestination    string
+		tlsVerification   string // "verified", "untrusted" or "failed"
+		tlsHandshakeError string // category
 	}
 
 	smtpd struct {
 		connect, disconnect, dnsError, process bool
 		lostConnection                         string
+		timeout                                string
 		saslMethod                             string
+		saslUsername                           string
+		clientHost, clientIP                   string // of connect, reject and client= lines
+		tlsHandshakeError                      string // category
+		proxied                                bool   // client= overridden by XCLIENT
 		saslAuthFailed                         bool
-		reject                                 string
+		reject, rejectReason                   string
+		rejectStage                            string // SMTP command, see rejectStages
+		rejectWarning                          bool   // of warn_if_reject, the client was not actually rejected
+		proxyReject                            string // SMTP code of smtpd_proxy_filter rejects
+		rejectRestriction, rejectList          string
+		rateLimit                              string
+		greylisted                             bool
 		tls                                    []string
 	}
+
+	tlsproxy struct {
+		connect bool
+		tls     []string
+	}
+}
+
+// syslogMessage holds the fields of an RFC 5424 syslog message.
+type syslogMessage struct {
+	priority, version int
+	timestamp         string
+	hostname          string
+	appName, procID   string
+	msgID             string
+	structuredData    string
+	message           string
+}
+
+// parseRFC5424 parses a syslog message as defined in RFC 5424, section 6.
+// Nil values ("-") are returned as empty strings.
+func parseRFC5424(line string) (m syslogMessage, ok bool) {
+	matches := rfc5424Line.FindStringSubmatch(line)
+	if matches == nil {
+		return m, false
+	}
+
+	nilValue := func(s string) string {
+		if s == "-" {
+			return ""
+		}
+
+		return s
+	}
+
+	m.priority, _ = strconv.Atoi(matches[1])
+	m.version, _ = strconv.Atoi(matches[2])
+	m.timestamp = nilValue(matches[3])
+	m.hostname = nilValue(matches[4])
+	m.appName = nilValue(matches[5])
+	m.procID = nilValue(matches[6])
+	m.msgID = nilValue(matches[7])
+	m.structuredData = nilValue(matches[8])
+	m.message = matches[9]
+
+	return m, true
 }
 
-func parseLogLine(instance, line string) (p loglineResult) { //nolint:gocognit
-	// Strip off timestamp, hostname, etc.
+// logHeader holds the relevant syslog header fields of a log line.
+type logHeader struct {
+	timestamp           time.Time // zero, if unknown
+	process, subprocess string
+	service             string
+	message             string
+}
+
+// splitLogLine strips off the syslog header (timestamp, hostname, etc.)
+// and returns the Postfix process and subprocess name, and the message.
+// Both RFC 5424 and the traditional BSD format (RFC 3164) are supported,
+// as well as lines without timestamp and hostname, as logged to stdout
+// by Postfix in containers, and lines without PID, e.g. of postlog.
+// The timestamp is only retained if it is in RFC 3339 format, as
+// emitted e.g. by rsyslog's RSYSLOG_FileFormat template.
+func splitLogLine(line string) (h logHeader, ok bool) {
+	if m, ok := parseRFC5424(line); ok {
+		matches := postfixAppName.FindStringSubmatch(m.appName)
+		if matches == nil {
+			return h, false
+		}
+		h.timestamp, _ = time.Parse(time.RFC3339Nano, m.timestamp)
+		h.process, h.message = matches[1], m.message
+		h.service, h.subprocess = splitService(matches[2])
+
+		return h, true
+	}
+
 	matches := logLine.FindStringSubmatch(line)
 	if matches == nil {
-		// Unknown log entry format.
-		p.unsupported = true
+		return h, false
+	}
+	if ts := rfc3339Prefix.FindStringSubmatch(line); ts != nil {
+		h.timestamp, _ = time.Parse(time.RFC3339Nano, ts[1])
+	}
+	h.process, h.message = matches[1], matches[3]
+	h.service, h.subprocess = splitService(matches[2])
+
+	return h, true
+}
+
+// customLogLines caches the patterns of splitCustomLogLine, by syslog
+// name.
+var customLogLines sync.Map
+
+// splitCustomLogLine is like splitLogLine, for instances whose
+// syslog_name does not start with "postfix".
+func splitCustomLogLine(syslogName, line string) (h logHeader, ok bool) {
+	if m, ok := parseRFC5424(line); ok {
+		if m.appName != syslogName && !strings.HasPrefix(m.appName, syslogName+"/") {
+			return h, false
+		}
+		h.timestamp, _ = time.Parse(time.RFC3339Nano, m.timestamp)
+		h.process, h.message = syslogName, m.message
+		h.service, h.subprocess = splitService(strings.TrimPrefix(m.appName[len(syslogName):], "/"))
+
+		return h, true
+	}
+
+	re, ok := customLogLines.Load(syslogName)
+	if !ok {
+		re, _ = customLogLines.LoadOrStore(syslogName, regexp.MustCompile(`(?:^| )(`+regexp.QuoteMeta(syslogName)+`)(?:/([\w/-]+))?(?:\[\d+\])?: (.*)`))
+	}
+	matches := re.(*regexp.Regexp).FindStringSubmatch(line)
+	if matches == nil {
+		return h, false
+	}
+	if ts := rfc3339Prefix.FindStringSubmatch(line); ts != nil {
+		h.timestamp, _ = time.Parse(time.RFC3339Nano, ts[1])
+	}
+	h.process, h.message = matches[1], matches[3]
+	h.service, h.subprocess = splitService(matches[2])
+
+	return h, true
+}
+
+// splitService splits the part of the syslog name after the process
+// into the master.cf service name and the Postfix daemon. Services
+// configured with `-o syslog_name=postfix/submission` log as e.g.
+// "postfix/submission/smtpd". Without a service name, the daemon name
+// is returned as both.
+func splitService(s string) (service, subprocess string) {
+	if i := strings.LastIndexByte(s, '/'); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+
+	return s, s
+}
+
+// splitSyslogLine is like splitLogLine, but accepts lines of all
+// programs. The program name is returned as process.
+func splitSyslogLine(line string) (h logHeader, ok bool) {
+	if m, ok := parseRFC5424(line); ok {
+		h.process, h.message = m.appName, m.message
+
+		return h, h.process != ""
+	}
+
+	matches := syslogLine.FindStringSubmatch(line)
+	if matches == nil {
+		return h, false
+	}
+	h.process, h.message = matches[1], matches[2]
+
+	return h, true
+}
+
+// parseLogLine parses a log line of the Postfix instance with the
+// syslog name `instance`, or of another program.
+func parseLogLine(instance, line string) loglineResult {
+	p := parseLogHeader(instance, line)
+	switch {
+	case p.unsupported:
+	case p.program != "":
+		parseOtherMessage(&p)
+	default:
+		parsePostfixMessage(&p)
+	}
+
+	return p
+}
+
+// parseLogHeader parses the syslog header of a log line. The program
+// is set for lines not logged by Postfix. Lines in an unknown format
+// and of other Postfix instances are unsupported.
+func parseLogHeader(instance, line string) (p loglineResult) {
+	h, ok := splitLogLine(line)
+	if !ok && !postfixAppName.MatchString(instance) {
+		h, ok = splitCustomLogLine(instance, line)
+	}
+	if !ok {
+		if h, ok = splitSyslogLine(line); !ok {
+			// Unknown log entry format.
+			p.unsupported = true
+
+			return
+		}
+		p.program, p.message = h.process, h.message
 
 		return
 	}
 
-	process := matches[1]
-	p.subprocess = matches[2]
-	remainder := matches[3]
+	p.subprocess = h.subprocess
+	p.service = h.service
 
 	// unexpected log producer (maybe different postfix instance)
-	if process != instance {
-		p.ignore = strings.HasPrefix(process, "postfix")
+	if h.process != instance {
+		p.ignore = strings.HasPrefix(h.process, "postfix")
 		p.unsupported = true
 
 		return
 	}
+	p.timestamp, p.message = h.timestamp, h.message
+	if m := queueIDLine.FindStringSubmatch(h.message); m != nil {
+		p.queueID = m[1]
+	}
+
+	return
+}
+
+// parsePostfixMessage parses the message of a Postfix log line, whose
+// header was parsed by parseLogHeader.
+func parsePostfixMessage(p *loglineResult) { //nolint:gocognit
+	remainder := p.message
+	if m := warningLine.FindStringSubmatch(remainder); m != nil {
+		p.warning = classifyWarning(m[1])
+	} else if m := fatalLine.FindStringSubmatch(remainder); m != nil {
+		p.fatal, p.panic = m[1] == "fatal", m[1] == "panic"
+	}
 
 	// Group patterns to check by Postfix service.
+	if parse, ok := postfixParsers[p.subprocess]; ok {
+		parse(p, remainder)
+	} else {
+		p.unsupported = true
+	}
+	if p.status != "" {
+		if m := deliveryRecipientLine.FindStringSubmatch(remainder); m != nil {
+			p.recipientDomain = strings.ToLower(m[1])
+		}
+	}
+	if p.status == "sent" {
+		if m := queuedAsLine.FindStringSubmatch(remainder); m != nil {
+			p.queuedAs = m[1]
+		}
+		if m := forwardedAsLine.FindStringSubmatch(remainder); m != nil {
+			p.forwardedAs = m[1]
+		}
+	}
+	if p.status == "bounced" {
+		if m := bouncedLine.FindStringSubmatch(remainder); m != nil {
+			p.bounceCategory = classifyBounce(p.dsn, m[1])
+		}
+	}
+	if p.subprocess == "smtpd" || p.subprocess == "cleanup" {
+		if m := milterErrorLine.FindStringSubmatch(remainder); m != nil {
+			p.milter, p.milterError = m[1], classifyMilterError(m[2])
+		}
+	}
 	switch p.subprocess {
-	case "cleanup":
-		if strings.Contains(remainder, ": message-id=<") {
-			p.cleanup.process = true
-		} else if strings.Contains(remainder, ": reject: ") {
-			p.cleanup.reject = true
-		} else {
-			p.unsupported = true
+	case "smtpd", "cleanup", "smtp", "lmtp":
+		if p.mimeEvent = classifyMIMEEvent(remainder); p.mimeEvent != "" {
+			p.unsupported = false
+		}
+	}
+	if p.warning != "" || p.fatal || p.panic {
+		// warnings and errors are counted, even if not parsed any further
+		p.unsupported = false
+	}
+}
+
+// postfixDaemons are the Postfix daemons and commands the exporter's
+// own parser is registered for. Besides the lines of postfixParsers,
+// it supports the warnings and errors of all of them.
+var postfixDaemons = []string{
+	"anvil", "bounce", "cleanup", "defer", "discard", "dnsblog", "error",
+	"flush", "lmtp", "local", "master", "nqmgr", "oqmgr", "pickup", "pipe",
+	"postalias", "postcat", "postconf", "postdrop", "postfix",
+	"postfix-script", "postkick", "postlock", "postlog", "postlogd",
+	"postmap", "postmulti", "postqueue", "postscreen", "postsuper",
+	"proxymap", "qmgr", "qmqpd", "retry", "scache", "sendmail", "showq",
+	"smtp", "smtpd", "spawn", "tlsmgr", "tlsproxy", "trace",
+	"trivial-rewrite", "verify", "virtual",
+}
+
+// postfixParsers parse the lines of Postfix daemons, by subprocess.
+// They set p.unsupported for lines they don't support.
+var postfixParsers = map[string]func(p *loglineResult, remainder string){
+	"bounce":     parseBounceLine,
+	"cleanup":    parseCleanupLine,
+	"lmtp":       parseLMTPLine,
+	"local":      parseLocalLine,
+	"master":     parseMasterLine,
+	"virtual":    parseLocalLine,
+	"pipe":       parsePipeLine,
+	"postscreen": parsePostscreenLine,
+	"qmgr":       parseQmgrLine,
+	"scache":     parseScacheLine,
+	"smtp":       parseSMTPLine,
+	"smtpd":      parseSMTPDLine,
+	"tlsproxy":   parseTLSProxyLine,
+}
+
+// parseBounceLine parses the lines of bounce.
+func parseBounceLine(p *loglineResult, remainder string) {
+	if bounceMatches := bounceNotificationLine.FindStringSubmatch(remainder); bounceMatches != nil {
+		p.bounce.recipient = bounceMatches[1]
+		p.bounce.notification = bounceMatches[2]
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parseCleanupLine parses the lines of cleanup.
+func parseCleanupLine(p *loglineResult, remainder string) {
+	if m := cleanupMessageIDLine.FindStringSubmatch(remainder); m != nil {
+		p.cleanup.process = true
+		p.cleanup.messageID = m[1]
+	} else if strings.Contains(remainder, ": reject: ") {
+		p.cleanup.reject = true
+	} else if m := accessActionLine.FindStringSubmatch(remainder); m != nil {
+		p.accessAction = m[1]
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parseLMTPLine parses the lines of lmtp.
+func parseLMTPLine(p *loglineResult, remainder string) {
+	if lmtpMatches := lmtpPipeSMTPLine.FindStringSubmatch(remainder); lmtpMatches != nil {
+		p.lmtp.delays = &delay{
+			beforeQueueManager: convertValue("lmtp pdelay", lmtpMatches[2]),
+			queueManager:       convertValue("lmtp adelay", lmtpMatches[3]),
+			connSetup:          convertValue("lmtp sdelay", lmtpMatches[4]),
+			transmission:       convertValue("lmtp xdelay", lmtpMatches[5]),
+		}
+		p.dsn, p.status = parseDSN(remainder), parseStatus(remainder)
+		p.remoteStatus = parseRemoteStatus(remainder)
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parseMasterLine parses the lines of master.
+func parseMasterLine(p *loglineResult, remainder string) {
+	if m := masterProcessLimitLine.FindStringSubmatch(remainder); m != nil {
+		p.master.processLimit = m[1]
+	} else if m := masterLifecycleLine.FindStringSubmatch(remainder); m != nil {
+		switch m[1] {
+		case "daemon started":
+			p.master.lifecycle = "start"
+		case "reload":
+			p.master.lifecycle = "reload"
+		default:
+			p.master.lifecycle = "stop"
+		}
+		p.master.version = m[2]
+	} else if m := masterProcessExitLine.FindStringSubmatch(remainder); m != nil {
+		p.master.exitProgram, p.master.status = m[1], m[3]
+		p.master.exitKind = "exit"
+		if m[2] == "killed by signal" {
+			p.master.exitKind = "signal"
 		}
-	case "lmtp":
-		if lmtpMatches := lmtpPipeSMTPLine.FindStringSubmatch(remainder); lmtpMatches != nil {
-			p.lmtp.delays = &delay{
-				beforeQueueManager: convertValue("lmtp pdelay", lmtpMatches[2]),
-				queueManager:       convertValue("lmtp adelay", lmtpMatches[3]),
-				connSetup:          convertValue("lmtp sdelay", lmtpMatches[4]),
-				transmission:       convertValue("lmtp xdelay", lmtpMatches[5]),
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parseLocalLine parses the lines of local and virtual.
+func parseLocalLine(p *loglineResult, remainder string) {
+	if p.dsn = parseDSN(remainder); p.dsn == "" {
+		p.unsupported = true
+	}
+	p.status = parseStatus(remainder)
+}
+
+// parsePipeLine parses the lines of pipe.
+func parsePipeLine(p *loglineResult, remainder string) {
+	if pipeMatches := lmtpPipeSMTPLine.FindStringSubmatch(remainder); pipeMatches != nil {
+		p.pipe.relay = pipeMatches[1]
+		p.pipe.delays = &delay{
+			beforeQueueManager: convertValue("pipe pdelay", pipeMatches[2]),
+			queueManager:       convertValue("pipe adelay", pipeMatches[3]),
+			connSetup:          convertValue("pipe sdelay", pipeMatches[4]),
+			transmission:       convertValue("pipe xdelay", pipeMatches[5]),
+		}
+		p.dsn, p.status = parseDSN(remainder), parseStatus(remainder)
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parsePostscreenLine parses the lines of postscreen.
+func parsePostscreenLine(p *loglineResult, remainder string) {
+	if m := postscreenConnectLine.FindStringSubmatch(remainder); m != nil {
+		p.postscreen.connect = true
+		p.postscreen.clientIP = m[1]
+	} else if postscreenMatches := postscreenVerdictLine.FindStringSubmatch(remainder); postscreenMatches != nil {
+		p.postscreen.verdict = postscreenVerdicts[postscreenMatches[1]]
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parseQmgrLine parses the lines of qmgr.
+func parseQmgrLine(p *loglineResult, remainder string) {
+	if qmgrInsertMatches := qmgrInsertLine.FindStringSubmatch(remainder); qmgrInsertMatches != nil {
+		p.qmgr.inserted = true
+		p.qmgr.size = convertValue("qmgr size", qmgrInsertMatches[1])
+		p.qmgr.nrcpt = convertValue("qmgr nrcpt", qmgrInsertMatches[2])
+		if m := qmgrSenderLine.FindStringSubmatch(remainder); m != nil {
+			p.qmgr.senderDomain = strings.ToLower(m[1])
+		}
+	} else if strings.HasSuffix(remainder, ": removed") {
+		p.qmgr.removed = true
+	} else if m := qmgrExpiredLine.FindStringSubmatch(remainder); m != nil {
+		p.qmgr.expired = m[1]
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parseScacheLine parses the lines of scache.
+func parseScacheLine(p *loglineResult, remainder string) {
+	if m := scacheLookupLine.FindStringSubmatch(remainder); m != nil {
+		p.scache.lookup = m[1]
+		p.scache.hits = convertValue("scache hits", m[2])
+		p.scache.misses = convertValue("scache miss", m[3])
+		p.scache.hitRatio = convertValue("scache success", m[4]) / 100
+	} else if m := scacheMaxLine.FindStringSubmatch(remainder); m != nil {
+		p.scache.maxSimultaneous = true
+		p.scache.domains = convertValue("scache domains", m[1])
+		p.scache.addresses = convertValue("scache addresses", m[2])
+		p.scache.connections = convertValue("scache connection", m[3])
+	} else if !strings.HasPrefix(remainder, "statistics: start interval ") {
+		p.unsupported = true
+	}
+}
+
+// parseSMTPLine parses the lines of smtp.
+func parseSMTPLine(p *loglineResult, remainder string) {
+	if smtpMatches := lmtpPipeSMTPLine.FindStringSubmatch(remainder); smtpMatches != nil {
+		p.smtp.delays = &delay{
+			beforeQueueManager: convertValue("smtp pdelay", smtpMatches[2]),
+			queueManager:       convertValue("smtp adelay", smtpMatches[3]),
+			connSetup:          convertValue("smtp sdelay", smtpMatches[4]),
+			transmission:       convertValue("smtp xdelay", smtpMatches[5]),
+		}
+		p.dsn, p.status = parseDSN(remainder), parseStatus(remainder)
+		p.remoteStatus = parseRemoteStatus(remainder)
+		p.smtp.relay = relayHost(smtpMatches[1])
+		p.smtp.ipFamily = relayIPFamily(smtpMatches[1])
+		p.smtp.port = relayPort(smtpMatches[1])
+		if m := smtpTotalDelayLine.FindStringSubmatch(remainder); m != nil {
+			p.smtp.delay = convertValue("smtp delay", m[1])
+		}
+		if statusMatches := smtpStatusLine.FindStringSubmatch(remainder); statusMatches != nil {
+			p.smtp.status = statusMatches[1]
+		}
+		if m := smtpDeferredLine.FindStringSubmatch(remainder); m != nil {
+			p.smtp.deferredReason = classifyDeferredReason(m[1])
+			for _, f := range tlsPolicyFailures {
+				if f.pattern.MatchString(m[1]) {
+					p.smtp.tlsPolicy = f.policy
+
+					break
+				}
+			}
+		}
+		if m := smtpDNSErrorLine.FindStringSubmatch(remainder); m != nil {
+			p.smtp.dnsError = dnsErrors[m[1]]
+			if p.smtp.dnsError == "" {
+				p.smtp.dnsError = "other"
+			}
+		}
+	} else if smtpTLSMatches := smtpTLSLine.FindStringSubmatch(remainder); smtpTLSMatches != nil {
+		p.smtp.tls = append([]string{smtpTLSMatches[1]}, smtpTLSMatches[3:]...)
+		p.smtp.port = relayPort(smtpTLSMatches[2])
+		p.smtp.tlsVerification = tlsVerificationResults[smtpTLSMatches[1]]
+		if m := smtpTLSDestinationLine.FindStringSubmatch(remainder); m != nil {
+			p.smtp.tlsDestination = m[1]
+		}
+	} else if m := smtpCertVerificationFailedLine.FindStringSubmatch(remainder); m != nil {
+		p.smtp.tlsDestination = m[1]
+		p.smtp.tlsVerification = "failed"
+	} else if m := smtpConnectErrorLine.FindStringSubmatch(remainder); m != nil {
+		p.smtp.connectError = connectErrors[m[4]]
+		p.smtp.ipFamily = ipFamily(m[2])
+		p.smtp.timeout = m[4] == "Connection timed out"
+	} else if m := smtpLostConnectionLine.FindStringSubmatch(remainder); m != nil {
+		p.smtp.lostConnection = m[1]
+	} else if m := smtpSSLConnectErrorLine.FindStringSubmatch(remainder); m != nil {
+		p.smtp.relay = m[1]
+		p.smtp.tlsHandshakeError = classifyTLSHandshakeError(m[2])
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parseSMTPDLine parses the lines of smtpd.
+func parseSMTPDLine(p *loglineResult, remainder string) {
+	if m := smtpdClientLine.FindStringSubmatch(remainder); m != nil {
+		p.smtpd.clientHost, p.smtpd.clientIP = m[1], m[2]
+	}
+	if strings.HasPrefix(remainder, "connect from ") {
+		p.smtpd.connect = true
+	} else if strings.HasPrefix(remainder, "disconnect from ") {
+		p.smtpd.disconnect = true
+	} else if smtpdFCrDNSErrorsLine.MatchString(remainder) {
+		p.smtpd.dnsError = true
+	} else if m := smtpdTimeoutLine.FindStringSubmatch(remainder); m != nil {
+		p.smtpd.timeout = m[1]
+	} else if smtpdLostConnectionMatches := smtpdLostConnectionLine.FindStringSubmatch(remainder); smtpdLostConnectionMatches != nil {
+		p.smtpd.lostConnection = smtpdLostConnectionMatches[1]
+	} else if strings.Contains(remainder, ": client=") {
+		p.smtpd.process = true
+		p.smtpd.proxied = strings.Contains(remainder, ", orig_client=")
+		if m := smtpdProcessesSASLLine.FindStringSubmatch(remainder); m != nil {
+			p.smtpd.saslMethod = m[1]
+		}
+		if m := smtpdSASLUsernameField.FindStringSubmatch(remainder); m != nil {
+			p.smtpd.saslUsername = m[1]
+		}
+	} else if smtpdRejectsMatches := smtpdRejectsLine.FindStringSubmatch(remainder); smtpdRejectsMatches != nil {
+		p.smtpd.rejectWarning = smtpdRejectsMatches[1] == "reject_warning"
+		p.smtpd.rejectStage = rejectStages[smtpdRejectsMatches[2]]
+		p.smtpd.reject = smtpdRejectsMatches[3]
+		p.smtpd.rejectReason = classifyRejectReason(remainder)
+		p.smtpd.rejectRestriction, p.smtpd.rejectList = parseRejectRestriction(remainder)
+		p.smtpd.greylisted = smtpdGreylistedLine.MatchString(remainder)
+	} else if m := accessActionLine.FindStringSubmatch(remainder); m != nil {
+		p.accessAction = m[1]
+	} else if m := smtpdProxyRejectLine.FindStringSubmatch(remainder); m != nil {
+		p.smtpd.proxyReject = m[1]
+	} else if m := smtpdRateLimitLine.FindStringSubmatch(remainder); m != nil {
+		p.smtpd.rateLimit = rateLimits[m[1]]
+	} else if smtpdSASLAuthenticationFailuresLine.MatchString(remainder) {
+		p.smtpd.saslAuthFailed = true
+		if m := smtpdSASLUsernameField.FindStringSubmatch(remainder); m != nil {
+			p.smtpd.saslUsername = m[1]
+		}
+	} else if smtpdTLSMatches := smtpdTLSLine.FindStringSubmatch(remainder); smtpdTLSMatches != nil {
+		p.smtpd.tls = smtpdTLSMatches[1:]
+	} else if m := smtpdSSLAcceptErrorLine.FindStringSubmatch(remainder); m != nil {
+		p.smtpd.tlsHandshakeError = classifyTLSHandshakeError(m[1])
+	} else if tlsCertificateLoadLine.MatchString(remainder) {
+		p.smtpd.tlsHandshakeError = "certificate_load"
+	} else {
+		p.unsupported = true
+	}
+}
+
+// parseTLSProxyLine parses the lines of tlsproxy.
+func parseTLSProxyLine(p *loglineResult, remainder string) {
+	if strings.HasPrefix(remainder, "CONNECT from ") {
+		p.tlsproxy.connect = true
+	} else if m := smtpdTLSLine.FindStringSubmatch(remainder); m != nil {
+		p.tlsproxy.tls = m[1:]
+	} else {
+		p.unsupported = true
+	}
+}
+
+// otherPrograms maps the syslog program names supported by
+// parseOtherMessage to the subprocess label of their metrics.
+var otherPrograms = map[string]string{
+	"amavis":      "amavis",
+	"amavisd":     "amavis",
+	"amavisd-new": "amavis",
+	"dovecot":     "dovecot",
+	"opendkim":    "opendkim",
+	"postgrey":    "postgrey",
+	"rspamd":      "rspamd",
+}
+
+// parseOtherMessage parses the message of a line of a program which
+// commonly logs into the same file as Postfix, e.g. policy servers and
+// content filters. The subprocess is set as in otherPrograms. Lines of
+// unknown programs are unsupported (with an empty subprocess).
+func parseOtherMessage(p *loglineResult) { //nolint:gocognit
+	remainder := p.message
+	p.subprocess = otherPrograms[p.program]
+
+	switch p.subprocess {
+	case "amavis":
+		if m := amavisVerdictLine.FindStringSubmatch(remainder); m != nil {
+			p.amavis.action = strings.ToLower(m[1])
+			p.amavis.category = strings.ToLower(m[2])
+			p.amavis.elapsed = -1
+			if m := amavisElapsedLine.FindStringSubmatch(remainder); m != nil {
+				p.amavis.elapsed = convertValue("amavis elapsed time", m[1]) / 1000
 			}
 		} else {
 			p.unsupported = true
 		}
-	case "pipe":
-		if pipeMatches := lmtpPipeSMTPLine.FindStringSubmatch(remainder); pipeMatches != nil {
-			p.pipe.relay = pipeMatches[1]
-			p.pipe.delays = &delay{
-				beforeQueueManager: convertValue("pipe pdelay", pipeMatches[2]),
-				queueManager:       convertValue("pipe adelay", pipeMatches[3]),
-				connSetup:          convertValue("pipe sdelay", pipeMatches[4]),
-				transmission:       convertValue("pipe xdelay", pipeMatches[5]),
+	case "dovecot":
+		if m := dovecotLMTPLine.FindStringSubmatch(remainder); m != nil {
+			p.dovecot.lmtpResult = "saved"
+			if m[1] == "save failed to" {
+				p.dovecot.lmtpResult = "failed"
 			}
+		} else if m := dovecotLoginFailureLine.FindStringSubmatch(remainder); m != nil {
+			p.dovecot.authService = m[1]
+			p.dovecot.authFailures = convertValue("dovecot auth attempts", m[2])
+		} else if dovecotAuthFailureLine.MatchString(remainder) {
+			p.dovecot.authService = "auth"
+			p.dovecot.authFailures = 1
 		} else {
 			p.unsupported = true
 		}
-	case "qmgr":
-		if qmgrInsertMatches := qmgrInsertLine.FindStringSubmatch(remainder); qmgrInsertMatches != nil {
-			p.qmgr.size = convertValue("qmgr size", qmgrInsertMatches[1])
-			p.qmgr.nrcpt = convertValue("qmgr nrcpt", qmgrInsertMatches[2])
-		} else if strings.HasSuffix(remainder, ": removed") {
-			p.qmgr.removed = true
+	case "opendkim":
+		if m := opendkimSignedLine.FindStringSubmatch(remainder); m != nil {
+			p.opendkim.result, p.opendkim.domain = "signed", m[1]
+		} else if m := opendkimKeyRetrievalLine.FindStringSubmatch(remainder); m != nil {
+			p.opendkim.result, p.opendkim.domain = "key_retrieval_failed", m[1]
+		} else if opendkimVerifiedLine.MatchString(remainder) {
+			p.opendkim.result = "pass"
+		} else if m := opendkimBadSignatureLine.FindStringSubmatch(remainder); m != nil {
+			p.opendkim.result, p.opendkim.domain = "fail", m[1]
+		} else if m := opendkimResultLine.FindStringSubmatch(remainder); m != nil {
+			p.opendkim.result = strings.ToLower(m[1])
+			if d := opendkimDomainField.FindStringSubmatch(remainder); d != nil {
+				p.opendkim.domain = d[1]
+			}
 		} else {
 			p.unsupported = true
 		}
-	case "smtp":
-		if smtpMatches := lmtpPipeSMTPLine.FindStringSubmatch(remainder); smtpMatches != nil {
-			p.smtp.delays = &delay{
-				beforeQueueManager: convertValue("smtp pdelay", smtpMatches[2]),
-				queueManager:       convertValue("smtp adelay", smtpMatches[3]),
-				connSetup:          convertValue("smtp sdelay", smtpMatches[4]),
-				transmission:       convertValue("smtp xdelay", smtpMatches[5]),
-			}
-			if statusMatches := smtpStatusLine.FindStringSubmatch(remainder); statusMatches != nil {
-				p.smtp.status = statusMatches[1]
+		p.opendkim.domain = strings.ToLower(p.opendkim.domain)
+	case "postgrey":
+		if postgreyMatches := postgreyActionLine.FindStringSubmatch(remainder); postgreyMatches != nil {
+			p.postgrey.result = "pass"
+			if postgreyMatches[1] == "greylist" {
+				p.postgrey.result = "delay"
 			}
-		} else if smtpTLSMatches := smtpTLSLine.FindStringSubmatch(remainder); smtpTLSMatches != nil {
-			p.smtp.tls = smtpTLSMatches[1:]
-		} else if smtpMatches := smtpConnectionTimedOut.FindStringSubmatch(remainder); smtpMatches != nil {
-			p.smtp.timeout = true
 		} else {
 			p.unsupported = true
 		}
-	case "smtpd":
-		if strings.HasPrefix(remainder, "connect from ") {
-			p.smtpd.connect = true
-		} else if strings.HasPrefix(remainder, "disconnect from ") {
-			p.smtpd.disconnect = true
-		} else if smtpdFCrDNSErrorsLine.MatchString(remainder) {
-			p.smtpd.dnsError = true
-		} else if smtpdLostConnectionMatches := smtpdLostConnectionLine.FindStringSubmatch(remainder); smtpdLostConnectionMatches != nil {
-			p.smtpd.lostConnection = smtpdLostConnectionMatches[1]
-		} else if smtpdProcessesSASLMatches := smtpdProcessesSASLLine.FindStringSubmatch(remainder); smtpdProcessesSASLMatches != nil {
-			p.smtpd.saslMethod = smtpdProcessesSASLMatches[1]
-		} else if strings.Contains(remainder, ": client=") {
-			p.smtpd.process = true
-		} else if smtpdRejectsMatches := smtpdRejectsLine.FindStringSubmatch(remainder); smtpdRejectsMatches != nil {
-			p.smtpd.reject = smtpdRejectsMatches[1]
-		} else if smtpdSASLAuthenticationFailuresLine.MatchString(remainder) {
-			p.smtpd.saslAuthFailed = true
-		} else if smtpdTLSMatches := smtpdTLSLine.FindStringSubmatch(remainder); smtpdTLSMatches != nil {
-			p.smtpd.tls = smtpdTLSMatches[1:]
+	case "rspamd":
+		if m := rspamdResultLine.FindStringSubmatch(remainder); m != nil {
+			p.rspamd.action = strings.ReplaceAll(m[1], " ", "_")
+			p.rspamd.score = convertValue("rspamd score", m[2])
 		} else {
 			p.unsupported = true
 		}
 	default:
 		p.unsupported = true
 	}
+}
 
-	return p
+// parseDSN returns the delivery status code of a delivery agent log
+// line, or the empty string.
+func parseDSN(s string) string {
+	if m := deliveryDSNLine.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+
+	return ""
+}
+
+// parseStatus returns the status of the recipient of a delivery agent
+// log line, e.g. "sent" or "deferred", or the empty string. Delivery
+// agents log one line per recipient.
+func parseStatus(s string) string {
+	if m := deliveryStatusLine.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+
+	return ""
+}
+
+// parseRemoteStatus returns the class, subject and detail of the
+// enhanced status code (RFC 3463) in the reply of the remote server of
+// an lmtp or smtp log line, e.g. "said: 550 5.7.1 ...", or nil.
+func parseRemoteStatus(s string) []string {
+	if m := remoteStatusLine.FindStringSubmatch(s); m != nil {
+		return m[1:]
+	}
+
+	return nil
+}
+
+// relayHost strips the address and port off a relay, e.g.
+// "mx.example.com[192.0.2.1]:25" becomes "mx.example.com".
+func relayHost(relay string) string {
+	if i := strings.IndexByte(relay, '['); i >= 0 {
+		return relay[:i]
+	}
+
+	return relay
+}
+
+// relayIPFamily returns the address family of a relay, e.g.
+// "ipv6" for "mx.example.com[2001:db8::1]:25", or the empty string if it
+// has no address (e.g. "none").
+func relayIPFamily(relay string) string {
+	i, j := strings.IndexByte(relay, '['), strings.LastIndexByte(relay, ']')
+	if i < 0 || j < i {
+		return ""
+	}
+
+	return ipFamily(relay[i+1 : j])
+}
+
+// relayPort returns the port of a relay, e.g. "587" for
+// "mail.example.com[192.0.2.1]:587", or the empty string if it has none.
+func relayPort(relay string) string {
+	i := strings.LastIndex(relay, "]:")
+	if i < 0 {
+		return ""
+	}
+	port := relay[i+2:]
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return ""
+	}
+
+	return port
+}
+
+// ipFamily returns "ipv4" or "ipv6" for an IP address, or the empty
+// string if it is invalid.
+func ipFamily(addr string) string {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "ipv4"
+	default:
+		return "ipv6"
+	}
 }
 
 func convertValue(context, s string) float64 {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,16 +15,186 @@ func TestParseLogline_SimpleLine(t *testing.T) {
 	assert.True(t, result.qmgr.removed)
 }
 
+func TestParseLogline_Expired(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  3 08:00:00 mail postfix/qmgr[8204]: 7B3C1D2E3F: from=<a@example.com>, status=expired, returned to sender")
+	assert.Equal(t, "expired", result.qmgr.expired)
+
+	result = parseLogLine("postfix", "Oct  3 08:00:00 mail postfix/qmgr[8204]: 7B3C1D2E3F: from=<a@example.com>, status=force-expired, returned to sender")
+	assert.Equal(t, "force-expired", result.qmgr.expired)
+}
+
+func TestParseLogline_Warning(t *testing.T) {
+	t.Parallel()
+
+	for text, category := range map[string]string{
+		"hostname mail.example.com does not resolve to address 192.0.2.1: Name or service not known":                "dns",
+		"TLS library problem: error:14094418:SSL routines:ssl3_read_bytes:tlsv1 alert unknown ca":                   "tls",
+		"unknown[192.0.2.1]: SASL LOGIN authentication failed: UGFzc3dvcmQ6":                                        "auth",
+		"service \"smtp\" (25) has reached its process limit \"100\": new clients may experience noticeable delays": "resource",
+		"database /etc/postfix/transport.db is older than source file /etc/postfix/transport":                       "config",
+		"Illegal address syntax from unknown[192.0.2.1] in MAIL command: <a@b@c>":                                   "other",
+	} {
+		result := parseLogLine("postfix", "Oct  3 09:00:00 mail postfix/smtpd[9345]: warning: "+text)
+		assert.Equal(t, category, result.warning, text)
+		assert.False(t, result.unsupported, text)
+	}
+
+	result := parseLogLine("postfix", "Oct  3 09:00:00 mail postfix/cleanup[9345]: 7B3C1D2E3F: warning: header Subject: test from unknown[192.0.2.1]")
+	assert.Equal(t, "other", result.warning)
+}
+
+func TestParseLogline_Fatal(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  3 09:10:00 mail postfix/smtpd[9456]: fatal: open /etc/postfix/main.cf: No such file or directory")
+	assert.Equal(t, "smtpd", result.subprocess)
+	assert.True(t, result.fatal)
+	assert.False(t, result.panic)
+	assert.False(t, result.unsupported)
+
+	result = parseLogLine("postfix", "Oct  3 09:10:00 mail postfix/qmgr[9456]: panic: myfree: corrupt or unallocated memory block")
+	assert.False(t, result.fatal)
+	assert.True(t, result.panic)
+	assert.False(t, result.unsupported)
+}
+
+func TestParseLogline_SmtpdTimeout(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  3 09:20:00 mail postfix/smtpd[9567]: timeout after END-OF-MESSAGE from unknown[192.0.2.1]")
+	assert.Equal(t, "END-OF-MESSAGE", result.smtpd.timeout)
+
+	result = parseLogLine("postfix", "Oct  3 09:20:00 mail postfix/smtpd[9567]: timeout after DATA (1234 bytes) from unknown[192.0.2.1]")
+	assert.Equal(t, "DATA", result.smtpd.timeout)
+}
+
+func TestParseLogline_SmtpLostConnection(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  3 09:30:00 mail postfix/smtp[9678]: 8C4D2E3F4A: lost connection with mx.example.com[192.0.2.1] while sending RCPT TO")
+	assert.Equal(t, "sending RCPT TO", result.smtp.lostConnection)
+
+	result = parseLogLine("postfix", "Oct  3 09:30:00 mail postfix/smtp[9678]: 8C4D2E3F4A: lost connection with mx.example.com[192.0.2.1] while sending end of data -- message may be sent more than once")
+	assert.Equal(t, "sending end of data", result.smtp.lostConnection)
+}
+
+func TestParseLogline_SmtpDNSError(t *testing.T) {
+	t.Parallel()
+
+	for reason, expected := range map[string]string{
+		"Host not found":                                  "nxdomain",
+		"Host not found, try again":                       "servfail",
+		"Host found but no data record of requested type": "nodata",
+		"Malformed or unexpected name server reply":       "other",
+	} {
+		result := parseLogLine("postfix", "Oct  3 09:40:00 mail postfix/smtp[9789]: 9D5E3F4A5B: to=<b@example.invalid>, relay=none, delay=0.1, delays=0.1/0/0/0, dsn=5.4.4, status=bounced (Host or domain name not found. Name service error for name=example.invalid type=MX: "+reason+")")
+		assert.Equal(t, expected, result.smtp.dnsError, reason)
+	}
+
+	result := parseLogLine("postfix", "Oct  3 09:40:00 mail postfix/smtp[9789]: 9D5E3F4A5B: to=<b@example.com>, relay=mx.example.com[192.0.2.1]:25, delay=0.3, delays=0.1/0/0.1/0.1, dsn=2.0.0, status=sent (250 2.0.0 OK)")
+	assert.Empty(t, result.smtp.dnsError)
+}
+
+func TestParseLogline_SmtpTLSVerification(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  3 09:50:00 mail postfix/smtp[9890]: Verified TLS connection established to mx.example.com[192.0.2.1]:25: TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits)")
+	assert.Equal(t, "mx.example.com", result.smtp.tlsDestination)
+	assert.Equal(t, "verified", result.smtp.tlsVerification)
+
+	result = parseLogLine("postfix", "Oct  3 09:50:00 mail postfix/smtp[9890]: Untrusted TLS connection established to mx.example.org[192.0.2.2]:25: TLSv1.2 with cipher ECDHE-RSA-AES256-GCM-SHA384 (256/256 bits)")
+	assert.Equal(t, "mx.example.org", result.smtp.tlsDestination)
+	assert.Equal(t, "untrusted", result.smtp.tlsVerification)
+
+	result = parseLogLine("postfix", "Oct  3 09:50:00 mail postfix/smtp[9890]: server certificate verification failed for mx.example.net[192.0.2.3]:25: num=10:certificate has expired")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "mx.example.net", result.smtp.tlsDestination)
+	assert.Equal(t, "failed", result.smtp.tlsVerification)
+}
+
+func TestParseLogline_SmtpTLSPolicy(t *testing.T) {
+	t.Parallel()
+
+	for reason, expected := range map[string]string{
+		"TLS is required, but was not offered by host mx.example.com[192.0.2.1]": "encrypt",
+		"Server certificate not verified":                                        "verify",
+		"Server certificate not trusted":                                         "verify",
+		"TLSA lookup error for mx.example.com:25":                                "dane",
+		"connect to mx.example.com[192.0.2.1]:25: Connection refused":            "",
+	} {
+		result := parseLogLine("postfix", "Oct  3 10:00:00 mail postfix/smtp[9901]: AE6F4A5B6C: to=<b@example.com>, relay=mx.example.com[192.0.2.1]:25, delay=1.2, delays=0.1/0/1.1/0, dsn=4.7.5, status=deferred ("+reason+")")
+		assert.Equal(t, expected, result.smtp.tlsPolicy, reason)
+	}
+}
+
+func TestParseLogline_SmtpdClientIP(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  3 10:10:00 mail postfix/smtpd[9912]: connect from mail.example.com[192.0.2.1]")
+	assert.Equal(t, "192.0.2.1", result.smtpd.clientIP)
+
+	result = parseLogLine("postfix", "Oct  3 10:10:00 mail postfix/smtpd[9912]: NOQUEUE: reject: RCPT from unknown[2001:db8::1]: 554 5.7.1 <b@example.org>: Relay access denied; from=<a@example.com> to=<b@example.org> proto=ESMTP helo=<example.com>")
+	assert.Equal(t, "2001:db8::1", result.smtpd.clientIP)
+}
+
+func TestParseLogline_XClient(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  3 10:20:00 mail postfix/smtpd[9923]: BF7A5B6C7D: client=mail.example.com[203.0.113.5], orig_client=proxy.example.net[10.0.0.1]")
+	assert.True(t, result.smtpd.process)
+	assert.True(t, result.smtpd.proxied)
+	assert.Equal(t, "203.0.113.5", result.smtpd.clientIP, "The real client should be used.")
+
+	result = parseLogLine("postfix", "Oct  3 10:20:00 mail postfix/smtpd[9923]: BF7A5B6C7D: client=mail.example.com[203.0.113.5]")
+	assert.False(t, result.smtpd.proxied)
+	assert.Equal(t, "203.0.113.5", result.smtpd.clientIP)
+}
+
+func TestParseLogline_RejectRestriction(t *testing.T) {
+	t.Parallel()
+
+	for text, expected := range map[string][2]string{
+		"554 5.7.1 Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org":        {"client_host", "zen.spamhaus.org"},
+		"554 5.7.1 Service unavailable; Sender address [a@example.com] blocked using dbl.spamhaus.org": {"sender_address", "dbl.spamhaus.org"},
+		"554 5.7.1 <b@example.org>: Relay access denied":                                               {"relay", ""},
+		"450 4.1.8 <a@example.com>: Sender address rejected: Domain not found":                         {"sender_address", ""},
+		"550 5.1.1 <b@example.org>: Recipient address rejected: User unknown in local recipient table": {"recipient_address", ""},
+		"504 5.5.2 <localhost>: Helo command rejected: need fully-qualified hostname":                  {"helo_command", ""},
+		"450 4.7.25 Client host rejected: cannot find your hostname, [192.0.2.1]":                      {"client_host", ""},
+		"554 5.7.1 <b@example.org>: Spam is not welcome here":                                          {"other", ""},
+	} {
+		result := parseLogLine("postfix", "Oct  2 11:10:00 mail postfix/smtpd[7890]: NOQUEUE: reject: RCPT from unknown[192.0.2.1]: "+text+"; from=<a@example.com> to=<b@example.org> proto=ESMTP helo=<example.com>")
+		assert.Equal(t, expected[0], result.smtpd.rejectRestriction, text)
+		assert.Equal(t, expected[1], result.smtpd.rejectList, text)
+	}
+}
+
+func TestParseLogline_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	for text, expected := range map[string]string{
+		"Connection rate limit exceeded: 61 from unknown[192.0.2.1] for service smtp":         "connection_rate",
+		"Connection concurrency limit exceeded: 51 from unknown[192.0.2.1] for service smtp":  "connection_concurrency",
+		"Recipient address rate limit exceeded: 201 from unknown[192.0.2.1] for service smtp": "recipient_rate",
+	} {
+		result := parseLogLine("postfix", "Oct  3 10:30:00 mail postfix/smtpd[9934]: warning: "+text)
+		assert.Equal(t, expected, result.smtpd.rateLimit, text)
+	}
+}
+
 func TestParseLogline_UnknownLines(t *testing.T) {
 	t.Parallel()
 
-	result := parseLogLine("postfix", "Apr 26 10:55:19 tcc1 postfix/smtpd[21126]: warning: SASL authentication failure: cannot connect to saslauthd server: Permission denied")
+	result := parseLogLine("postfix", "Apr 26 10:55:19 tcc1 postfix/smtpd[21126]: improper command pipelining after EHLO from unknown[192.0.2.1]: QUIT")
 	assert.True(t, result.unsupported)
 	assert.Equal(t, "smtpd", result.subprocess)
 
 	result = parseLogLine("postfix", "Apr 26 10:55:19 tcc1 postfix/smtpd[21126]: warning: SASL authentication failure: Password verification failed")
-	assert.True(t, result.unsupported)
+	assert.False(t, result.unsupported)
 	assert.Equal(t, "smtpd", result.subprocess)
+	assert.Equal(t, "auth", result.warning)
 }
 
 func TestParseLogline_SASL(t *testing.T) {
@@ -32,6 +203,9 @@ func TestParseLogline_SASL(t *testing.T) {
 	result := parseLogLine("postfix", "Oct 30 13:19:26 mailgw-out1 postfix/smtpd[27530]: EB4B2C19E2: client=xxx[1.2.3.4], sasl_method=PLAIN, sasl_username=user@domain")
 	assert.Equal(t, "PLAIN", result.smtpd.saslMethod)
 
+	assert.Equal(t, "user@domain", result.smtpd.saslUsername)
+	assert.Equal(t, "EB4B2C19E2", result.queueID)
+
 	result = parseLogLine("postfix", "Feb 24 16:42:00 letterman postfix/smtpd[24906]: 1CF582025C: client=xxx[2.3.4.5]")
 	assert.True(t, result.smtpd.process)
 
@@ -60,6 +234,267 @@ func TestParseLogline_Delays(t *testing.T) {
 		connSetup:          0.03,
 		transmission:       0.05,
 	}, result.smtp.delays)
+	assert.Equal(t, "mail.telia.com", result.smtp.relay)
+	assert.Equal(t, "telia.com", result.recipientDomain)
+	assert.Equal(t, 2017.0, result.smtp.delay)
+	assert.Equal(t, "2.0.0", result.dsn)
+	assert.Equal(t, "sent", result.smtp.status)
+}
+
+func TestParseLogline_Amavis(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 10:30:00 mail amavis[2345]: (02345-01) Passed CLEAN {RelayedInbound}, [192.0.2.1]:4321 [192.0.2.1] <a@example.com> -> <b@example.org>, Queue-ID: 4A0F7C0A21, Message-ID: <x@example.com>, mail_id: AbCdEf, Hits: -1.2, size: 1234, queued_as: 5B3E2C0B32, 1534 ms")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "amavis", result.subprocess)
+	assert.Equal(t, "passed", result.amavis.action)
+	assert.Equal(t, "clean", result.amavis.category)
+	assert.Equal(t, 1.534, result.amavis.elapsed)
+
+	result = parseLogLine("postfix", "Oct  2 10:30:00 mail amavis[2345]: (02345-02) Blocked INFECTED (Eicar-Test-Signature) {DiscardedInbound,Quarantined}, [192.0.2.1]:4321 <a@example.com> -> <b@example.org>, Queue-ID: 4A0F7C0A22, mail_id: AbCdEg, Hits: -, size: 2345, 310 ms")
+	assert.Equal(t, "blocked", result.amavis.action)
+	assert.Equal(t, "infected", result.amavis.category)
+
+	result = parseLogLine("postfix", "Oct  2 10:30:00 mail amavisd-new[2345]: (02345-03) Passed BAD-HEADER-7 {RelayedInbound}, <a@example.com> -> <b@example.org>")
+	assert.Equal(t, "bad-header", result.amavis.category)
+	assert.Equal(t, -1.0, result.amavis.elapsed)
+
+	result = parseLogLine("postfix", "Oct  2 10:30:00 mail amavis[2345]: (02345-03) extra modules loaded: /usr/share/perl5/Mail/SpamAssassin.pm")
+	assert.True(t, result.unsupported)
+}
+
+func TestParseLogline_Bounce(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 10:00:00 mail postfix/bounce[5678]: 4A0F7C0A21: sender non-delivery notification: 5B3E2C0B32")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "sender", result.bounce.recipient)
+	assert.Equal(t, "non-delivery", result.bounce.notification)
+
+	result = parseLogLine("postfix", "Oct  2 10:00:00 mail postfix/bounce[5678]: 4A0F7C0A21: sender delay notification: 5B3E2C0B32")
+	assert.Equal(t, "delay", result.bounce.notification)
+
+	result = parseLogLine("postfix", "Oct  2 10:00:00 mail postfix/bounce[5678]: 4A0F7C0A21: postmaster non-delivery notification: 5B3E2C0B32")
+	assert.Equal(t, "postmaster", result.bounce.recipient)
+}
+
+func TestParseLogline_RejectReason(t *testing.T) {
+	t.Parallel()
+
+	for text, reason := range map[string]string{
+		"554 5.7.1 <b@example.net>: Relay access denied":                                                             "relay_denied",
+		"550 5.1.1 <x@example.org>: Recipient address rejected: User unknown in virtual mailbox table":               "unknown_user",
+		"504 5.5.2 <localhost>: Helo command rejected: need fully-qualified hostname":                                "helo",
+		"550 5.7.23 <b@example.org>: Recipient address rejected: Message rejected due to: SPF fail - not authorized": "spf",
+		"554 5.7.1 Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org":                      "rbl",
+		"450 4.7.1 <b@example.org>: Recipient address rejected: Rate limit exceeded":                                 "rate_limit",
+		"450 4.7.25 Client host rejected: cannot find your hostname, [192.0.2.1]":                                    "other",
+	} {
+		result := parseLogLine("postfix", "Oct  2 11:10:00 mail postfix/smtpd[7890]: NOQUEUE: reject: RCPT from unknown[192.0.2.1]: "+text+"; from=<a@example.com> to=<b@example.org> proto=ESMTP helo=<example.com>")
+		assert.Equal(t, reason, result.smtpd.rejectReason, text)
+	}
+}
+
+func TestParseLogline_Dovecot(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 10:50:00 mail dovecot: lmtp(b@example.org)<4567><AbCdEfGh>: msgid=<x@example.com>: saved mail to INBOX")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "dovecot", result.subprocess)
+	assert.Equal(t, "saved", result.dovecot.lmtpResult)
+
+	result = parseLogLine("postfix", "Oct  2 10:50:00 mail dovecot: lmtp(c@example.org)<4567><AbCdEfGi>: msgid=<y@example.com>: save failed to INBOX: Quota exceeded (mailbox for user is full)")
+	assert.Equal(t, "failed", result.dovecot.lmtpResult)
+
+	result = parseLogLine("postfix", "Oct  2 10:50:00 mail dovecot: imap-login: Disconnected (auth failed, 3 attempts in 12 secs): user=<b@example.org>, method=PLAIN, rip=192.0.2.1, lip=192.0.2.25, TLS, session=<AbCd>")
+	assert.Equal(t, "imap-login", result.dovecot.authService)
+	assert.Equal(t, 3.0, result.dovecot.authFailures)
+
+	result = parseLogLine("postfix", "Oct  2 10:50:00 mail dovecot: auth: passwd-file(b@example.org,192.0.2.1,<AbCd>): Password mismatch")
+	assert.Equal(t, "auth", result.dovecot.authService)
+	assert.Equal(t, 1.0, result.dovecot.authFailures)
+
+	result = parseLogLine("postfix", "Oct  2 10:50:00 mail dovecot: imap(b@example.org)<4568><AbCe>: Logged out in=52 out=1088")
+	assert.True(t, result.unsupported)
+}
+
+func TestParseLogline_Greylisting(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 10:10:00 mail postfix/smtpd[4321]: NOQUEUE: reject: RCPT from unknown[192.0.2.1]: 450 4.2.0 <b@example.org>: Recipient address rejected: Greylisted, see http://postgrey.schweikert.ch/help/example.org.html; from=<a@example.com> to=<b@example.org> proto=ESMTP helo=<example.com>")
+	assert.Equal(t, "450", result.smtpd.reject)
+	assert.True(t, result.smtpd.greylisted)
+
+	result = parseLogLine("postfix", "Oct  2 10:10:00 mail postgrey[987]: action=greylist, reason=new, client_name=unknown, client_address=192.0.2.1, sender=a@example.com, recipient=b@example.org")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "postgrey", result.subprocess)
+	assert.Equal(t, "delay", result.postgrey.result)
+
+	result = parseLogLine("postfix", "2023-10-02T10:16:00+02:00 mail postgrey[987]: action=pass, reason=triplet found, delay=360, client_name=unknown, client_address=192.0.2.1, sender=a@example.com, recipient=b@example.org")
+	assert.Equal(t, "pass", result.postgrey.result)
+
+	result = parseLogLine("postfix", "Oct  2 10:10:00 mail postgrey[987]: cleaning up old entries...")
+	assert.True(t, result.unsupported)
+	assert.Equal(t, "postgrey", result.subprocess)
+
+	result = parseLogLine("postfix", "Oct  2 10:10:00 mail sshd[987]: action=pass, reason=triplet found")
+	assert.True(t, result.unsupported)
+	assert.Empty(t, result.subprocess, "Other programs should not be reported as services.")
+}
+
+func TestParseLogline_OpenDKIM(t *testing.T) {
+	t.Parallel()
+
+	for line, expected := range map[string][2]string{
+		"4A0F7C0A21: DKIM-Signature field added (s=mail, d=Example.org)":                                         {"signed", "example.org"},
+		"4A0F7C0A21: DKIM verification successful":                                                               {"pass", ""},
+		"4A0F7C0A21: bad signature data":                                                                         {"fail", ""},
+		"4A0F7C0A21: s=google d=gmail.com SSL error:04091068:rsa routines:INT_RSA_VERIFY:bad signature":          {"fail", "gmail.com"},
+		"4A0F7C0A21: key retrieval failed (s=sel, d=example.com): 'sel._domainkey.example.com' record not found": {"key_retrieval_failed", "example.com"},
+		"4A0F7C0A21: mail.example.com [192.0.2.1]: header.d=example.com; dkim=permerror (no key for signature)":  {"permerror", "example.com"},
+	} {
+		result := parseLogLine("postfix", "Oct  2 10:20:00 mail opendkim[321]: "+line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, "opendkim", result.subprocess)
+		assert.Equal(t, expected[0], result.opendkim.result, line)
+		assert.Equal(t, expected[1], result.opendkim.domain, line)
+	}
+
+	result := parseLogLine("postfix", "Oct  2 10:20:00 mail opendkim[321]: 4A0F7C0A21: no signature data")
+	assert.True(t, result.unsupported)
+}
+
+func TestParseLogline_Postscreen(t *testing.T) {
+	t.Parallel()
+
+	for line, verdict := range map[string]string{
+		"PASS NEW [192.0.2.1]:53426": "pass_new",
+		"PASS OLD [192.0.2.1]:53426": "pass_old",
+		"PREGREET 11 after 0.09 from [192.0.2.1]:53426: EHLO example.com\\r\\n":  "pregreet",
+		"HANGUP after 0.72 from [192.0.2.1]:53426 in tests after SMTP handshake": "hangup",
+		"DNSBL rank 4 for [192.0.2.1]:53426":                                     "dnsbl",
+		"WHITELIST VETO [192.0.2.1]:53426":                                       "whitelist_veto",
+		"ALLOWLIST VETO [192.0.2.1]:53426":                                       "whitelist_veto",
+		"NOQUEUE: reject: RCPT from [192.0.2.1]:53426: 550 5.7.1 Service unavailable; client [192.0.2.1] blocked using zen.spamhaus.org; from=<a@example.com>, to=<b@example.org>, proto=ESMTP, helo=<example.com>": "reject",
+	} {
+		result := parseLogLine("postfix", "Oct  2 10:00:00 mail postfix/postscreen[1234]: "+line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, verdict, result.postscreen.verdict, line)
+	}
+
+	result := parseLogLine("postfix", "Oct  2 10:00:00 mail postfix/postscreen[1234]: CONNECT from [192.0.2.1]:53426 to [192.0.2.25]:25")
+	assert.False(t, result.unsupported)
+	assert.True(t, result.postscreen.connect)
+	assert.Equal(t, "192.0.2.1", result.postscreen.clientIP)
+}
+
+func TestParseLogline_Rspamd(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 10:40:00 mail rspamd[3456]: <a1b2c3>; task; rspamd_task_write_log: id: <x@example.com>, qid: <4A0F7C0A21>, ip: 192.0.2.1, from: <a@example.com>, (default: F (no action): [-1.20/15.00] [R_SPF_ALLOW(-0.20){+ip4:192.0.2.0/24;},MIME_GOOD(-0.10){text/plain;}]), len: 1234, time: 345.6ms, dns req: 12, digest: <0123456789abcdef>, rcpts: <b@example.org>, mime_rcpts: <b@example.org>")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "rspamd", result.subprocess)
+	assert.Equal(t, "no_action", result.rspamd.action)
+	assert.Equal(t, -1.2, result.rspamd.score)
+
+	result = parseLogLine("postfix", "Oct  2 10:40:00 mail rspamd[3456]: <a1b2c4>; proxy; rspamd_task_write_log: id: <y@example.com>, qid: <4A0F7C0A22>, ip: 192.0.2.1, from: <a@example.com>, (default: T (reject): [17.30/15.00] [BAYES_SPAM(5.10){99.99%;}]), len: 2345, time: 512.0ms, dns req: 20, digest: <0123456789abcdee>, rcpts: <b@example.org>")
+	assert.Equal(t, "reject", result.rspamd.action)
+	assert.Equal(t, 17.3, result.rspamd.score)
+
+	result = parseLogLine("postfix", "Oct  2 10:40:00 mail rspamd[3456]: <a1b2c5>; map; rspamd_map_periodic_callback: need to check map https://maps.rspamd.com/rspamd/redirectors.inc.zst")
+	assert.True(t, result.unsupported)
+}
+
+func TestParseLogline_DSN(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 11:00:00 mail postfix/virtual[6789]: 5270320179: to=<b@example.org>, relay=virtual, delay=0.12, delays=0.1/0/0/0.02, dsn=2.0.0, status=sent (delivered to maildir)")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "2.0.0", result.dsn)
+	assert.Equal(t, "sent", result.status)
+
+	result = parseLogLine("postfix", "Oct  2 11:00:00 mail postfix/lmtp[6789]: 5270320179: to=<b@example.org>, relay=mail.example.org[private/dovecot-lmtp], delay=0.3, delays=0.1/0/0.1/0.1, dsn=4.2.2, status=deferred (host mail.example.org[private/dovecot-lmtp] said: 452 4.2.2 <b@example.org> Quota exceeded (mailbox for user is full) (in reply to end of DATA command))")
+	assert.Equal(t, "4.2.2", result.dsn)
+	assert.Equal(t, "deferred", result.status)
+
+	result = parseLogLine("postfix", "Oct  2 11:00:00 mail postfix/local[6789]: 5270320179: to=<c@example.com>, orig_to=<list@example.com>, relay=local, delay=0.1, delays=0/0/0/0.1, dsn=2.0.0, status=sent (forwarded as 6381431280)")
+	assert.Equal(t, "sent", result.status)
+	assert.Equal(t, "6381431280", result.forwardedAs)
+	assert.Empty(t, result.queuedAs)
+
+	result = parseLogLine("postfix", "Oct  2 11:00:00 mail postfix/local[6789]: warning: database /etc/aliases.db is older than source file /etc/aliases")
+	assert.Empty(t, result.dsn)
+	assert.Equal(t, "config", result.warning)
+}
+
+func TestParseLogline_Service(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 11:30:00 mail postfix/submission/smtpd[9012]: connect from unknown[192.0.2.1]")
+	assert.Equal(t, "smtpd", result.subprocess)
+	assert.Equal(t, "submission", result.service)
+	assert.True(t, result.smtpd.connect)
+
+	result = parseLogLine("postfix", "<22>1 2021-09-24T12:00:00Z mail postfix/smtps/smtpd 123 - - connect from unknown[192.0.2.1]")
+	assert.Equal(t, "smtpd", result.subprocess)
+	assert.Equal(t, "smtps", result.service)
+
+	result = parseLogLine("postfix", "Oct  2 11:30:00 mail postfix/smtpd[9012]: connect from unknown[192.0.2.1]")
+	assert.Equal(t, "smtpd", result.subprocess)
+	assert.Equal(t, "smtpd", result.service)
+}
+
+func TestParseLogline_TLSProxy(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 11:40:00 mail postfix/tlsproxy[9123]: CONNECT from [192.0.2.1]:53412")
+	assert.Equal(t, "tlsproxy", result.subprocess)
+	assert.True(t, result.tlsproxy.connect)
+
+	result = parseLogLine("postfix", "Oct  2 11:40:00 mail postfix/tlsproxy[9123]: Anonymous TLS connection established from [192.0.2.1]:53412: TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits)")
+	assert.Equal(t, []string{"Anonymous", "TLSv1.3", "TLS_AES_256_GCM_SHA384", "256", "256"}, result.tlsproxy.tls)
+
+	result = parseLogLine("postfix", "Oct  2 11:40:01 mail postfix/tlsproxy[9123]: DISCONNECT [192.0.2.1]:53412")
+	assert.True(t, result.unsupported)
+}
+
+func TestParseLogline_Scache(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  2 11:50:00 mail postfix/scache[9234]: statistics: start interval Oct  2 11:46:40")
+	assert.Equal(t, "scache", result.subprocess)
+	assert.False(t, result.unsupported)
+
+	result = parseLogLine("postfix", "Oct  2 11:50:00 mail postfix/scache[9234]: statistics: domain lookup hits=5 miss=3 success=62%")
+	assert.Equal(t, "domain", result.scache.lookup)
+	assert.Equal(t, 5.0, result.scache.hits)
+	assert.Equal(t, 3.0, result.scache.misses)
+	assert.Equal(t, 0.62, result.scache.hitRatio)
+
+	result = parseLogLine("postfix", "Oct  2 11:50:00 mail postfix/scache[9234]: statistics: max simultaneous domains=2 addresses=3 connection=4")
+	assert.True(t, result.scache.maxSimultaneous)
+	assert.Equal(t, 2.0, result.scache.domains)
+	assert.Equal(t, 3.0, result.scache.addresses)
+	assert.Equal(t, 4.0, result.scache.connections)
+}
+
+func TestParseLogline_DeferredReason(t *testing.T) {
+	t.Parallel()
+
+	for reason, expected := range map[string]string{
+		"connect to mx.example.com[192.0.2.1]:25: Connection timed out":                                             "connection_timed_out",
+		"delivery temporarily suspended: connect to mx.example.com[192.0.2.1]:25: Connection refused":               "connection_refused",
+		"lost connection with mx.example.com[192.0.2.1] while receiving the initial server greeting":                "lost_connection",
+		"Cannot start TLS: handshake failure":                                                                       "tls_failure",
+		"host mx.example.com[192.0.2.1] said: 451 4.7.1 Please try again later (in reply to RCPT TO command)":       "remote_4xx_policy",
+		"host mx.example.com[192.0.2.1] said: 452 4.2.2 Mailbox full (in reply to RCPT TO command)":                 "remote_4xx",
+		"Host or domain name not found. Name service error for name=example.com type=MX: Host not found, try again": "dns",
+		"unknown mail transport error": "other",
+	} {
+		result := parseLogLine("postfix", "Oct  2 11:20:00 mail postfix/smtp[8901]: 5270320179: to=<b@example.com>, relay=none, delay=30, delays=0.1/0/30/0, dsn=4.4.1, status=deferred ("+reason+")")
+		assert.Equal(t, "deferred", result.smtp.status)
+		assert.Equal(t, expected, result.smtp.deferredReason, reason)
+	}
 }
 
 func TestParseLogline_DifferentInstance(t *testing.T) {
@@ -75,3 +510,296 @@ func TestParseLogline_DifferentInstance(t *testing.T) {
 	assert.False(t, result.ignore)
 	assert.True(t, result.qmgr.removed)
 }
+
+func TestParseLogline_RFC5424(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "<22>1 2021-09-24T12:00:00.123456+02:00 letterman postfix/qmgr 8204 - - AAB4D259B1: removed")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "qmgr", result.subprocess)
+	assert.True(t, result.qmgr.removed)
+
+	result = parseLogLine("postfix", "<22>1 2021-09-24T12:00:00Z letterman postfix/smtpd 123 - [meta sequenceId=\"1\" note=\"a \\] b\"][origin ip=\"1.2.3.4\"] \ufeffconnect from unknown[1.2.3.4]")
+	assert.True(t, result.smtpd.connect)
+
+	result = parseLogLine("postfix", "<22>1 2021-09-24T12:00:00Z letterman dovecot 123 - - some message")
+	assert.True(t, result.unsupported)
+	assert.False(t, result.ignore)
+
+	m, ok := parseRFC5424(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`)
+	require.True(t, ok)
+	assert.Equal(t, syslogMessage{
+		priority:       165,
+		version:        1,
+		timestamp:      "2003-10-11T22:14:15.003Z",
+		hostname:       "mymachine.example.com",
+		appName:        "evntslog",
+		msgID:          "ID47",
+		structuredData: `[exampleSDID@32473 iut="3" eventSource="Application"]`,
+		message:        "An application event",
+	}, m)
+}
+
+func TestParseLogline_Timestamp(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "2023-06-01T10:11:12.123456+02:00 letterman postfix/qmgr[8204]: AAB4D259B1: removed")
+	assert.True(t, result.qmgr.removed)
+	assert.Equal(t, time.Date(2023, 6, 1, 8, 11, 12, 123456000, time.UTC), result.timestamp.UTC())
+
+	result = parseLogLine("postfix", "<22>1 2021-09-24T12:00:00.5Z letterman postfix/qmgr 8204 - - AAB4D259B1: removed")
+	assert.Equal(t, time.Date(2021, 9, 24, 12, 0, 0, 500000000, time.UTC), result.timestamp.UTC())
+
+	result = parseLogLine("postfix", "Feb 11 16:49:24 letterman postfix/qmgr[8204]: AAB4D259B1: removed")
+	assert.True(t, result.timestamp.IsZero(), "BSD timestamps are not retained.")
+}
+
+func TestParseLogline_RemoteStatus(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  4 09:12:01 mail postfix/smtp[4711]: 6B1C2D3E4F: to=<info@example.net>, relay=mx.example.net[192.0.2.25]:25, delay=1.2, delays=0.1/0/0.6/0.5, dsn=5.7.1, status=bounced (host mx.example.net[192.0.2.25] said: 550 5.7.1 Service unavailable (in reply to RCPT TO command))")
+	assert.Equal(t, []string{"5", "7", "1"}, result.remoteStatus)
+
+	result = parseLogLine("postfix", "Oct  2 11:00:00 mail postfix/lmtp[6789]: 5270320179: to=<b@example.org>, relay=mail.example.org[private/dovecot-lmtp], delay=0.3, delays=0.1/0/0.1/0.1, dsn=4.2.2, status=deferred (host mail.example.org[private/dovecot-lmtp] said: 452 4.2.2 <b@example.org> Quota exceeded (in reply to end of DATA command))")
+	assert.Equal(t, []string{"4", "2", "2"}, result.remoteStatus)
+
+	result = parseLogLine("postfix", "Oct  2 11:00:00 mail postfix/smtp[6789]: 5270320179: to=<b@example.org>, relay=none, delay=30, delays=0/0/30/0, dsn=4.4.1, status=deferred (connect to mx.example.org[192.0.2.1]:25: Connection timed out)")
+	assert.Nil(t, result.remoteStatus, "Replies generated by Postfix itself should be ignored.")
+}
+
+func TestClassifyBounce(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		dsn, reason, category string
+	}{
+		{"5.1.1", "host mx.example.com[192.0.2.1] said: 550 5.1.1 <b@example.com>: Recipient address rejected: User unknown in virtual mailbox table (in reply to RCPT TO command)", "user_unknown"},
+		{"5.0.0", "host mx.example.com[192.0.2.1] said: 550 No such user here (in reply to RCPT TO command)", "user_unknown"},
+		{"5.2.2", "host mx.example.com[192.0.2.1] said: 552 5.2.2 Mailbox full (in reply to RCPT TO command)", "mailbox_full"},
+		{"5.0.0", "host mx.example.com[192.0.2.1] said: 552 Quota exceeded (in reply to end of DATA command)", "mailbox_full"},
+		{"5.3.4", "host mx.example.com[192.0.2.1] said: 552 5.3.4 Message size exceeds fixed limit (in reply to end of DATA command)", "message_too_large"},
+		{"5.7.1", "host mx.example.com[192.0.2.1] said: 554 5.7.1 Message rejected as spam (in reply to end of DATA command)", "policy"},
+		{"5.0.0", "host mx.example.com[192.0.2.1] said: 554 Your IP is listed on a blocklist (in reply to end of DATA command)", "policy"},
+		{"5.4.4", "Host or domain name not found. Name service error for name=example.invalid type=MX: Host not found", "other"},
+	} {
+		assert.Equal(t, tc.category, classifyBounce(tc.dsn, tc.reason), tc.reason)
+	}
+
+	result := parseLogLine("postfix", "Oct  2 11:00:00 mail postfix/local[6789]: 5270320179: to=<nobody@example.com>, relay=local, delay=0.01, delays=0/0/0/0, dsn=5.1.1, status=bounced (unknown user: \"nobody\")")
+	assert.Equal(t, "user_unknown", result.bounceCategory)
+}
+
+func TestParseLogline_NoSyslogHeader(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "postfix/smtpd[123]: connect from unknown[192.0.2.1]")
+	assert.False(t, result.unsupported)
+	assert.True(t, result.smtpd.connect)
+
+	result = parseLogLine("postfix", "Oct  5 12:00:00.123456 mail postfix/qmgr[8204]: AAB4D259B1: removed")
+	assert.True(t, result.qmgr.removed)
+
+	result = parseLogLine("postfix", "postfix/postlog: warning: mail_queue_enter: create file maildrop/123456.789: Permission denied")
+	assert.Equal(t, "postlog", result.subprocess)
+	assert.False(t, result.unsupported)
+
+	result = parseLogLine("postfix", "opendkim[55]: 5270320179: DKIM-Signature field added (s=mail, d=example.com)")
+	assert.Equal(t, "opendkim", result.subprocess)
+	assert.Equal(t, "signed", result.opendkim.result)
+
+	result = parseLogLine("postfix", "Oct  5 12:00:00.123456 mail opendkim[55]: 5270320179: DKIM-Signature field added (s=mail, d=example.com)")
+	assert.Equal(t, "signed", result.opendkim.result)
+}
+
+func TestParseLogline_SmtpdTLSHandshakeError(t *testing.T) {
+	t.Parallel()
+
+	for line, category := range map[string]string{
+		"SSL_accept error from unknown[198.51.100.23]: lost connection":                                                                                           "lost_connection",
+		"SSL_accept error from unknown[198.51.100.23]: Connection timed out":                                                                                      "timeout",
+		"SSL_accept error from unknown[198.51.100.23]: error:0A00010B:SSL routines::wrong version number":                                                         "protocol",
+		"SSL_accept error from unknown[198.51.100.23]: error:0A000418:SSL routines::tlsv1 alert unknown ca:../ssl/record/rec_layer_s3.c:1586:SSL alert number 48": "certificate",
+		"SSL_accept error from unknown[198.51.100.23]: something odd":                                                                                             "other",
+		"warning: cannot get RSA certificate from file \"/etc/ssl/certs/mail.pem\": disabling TLS support":                                                        "certificate_load",
+	} {
+		result := parseLogLine("postfix", "Oct  4 09:20:00 mail postfix/smtpd[4801]: "+line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, category, result.smtpd.tlsHandshakeError, line)
+	}
+}
+
+func TestParseLogline_SmtpTLSHandshakeError(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  4 09:21:00 mail postfix/smtp[4803]: SSL_connect error to mx.example.net[192.0.2.44]:25: lost connection")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "mx.example.net", result.smtp.relay)
+	assert.Equal(t, "lost_connection", result.smtp.tlsHandshakeError)
+}
+
+func TestClassifyMIMEEvent(t *testing.T) {
+	t.Parallel()
+
+	for line, event := range map[string]string{
+		"9F3E12A0C1: to=<info@example.org>, relay=mx.example.org[192.0.2.45]:25, delay=1.2, delays=0.1/0/1/0.1, dsn=5.6.7, status=bounced (SMTPUTF8 is required, but was not offered by host mx.example.org[192.0.2.45])": "smtputf8_required",
+		"9F3E12A0C1: to=<info@example.org>, relay=mx.example.org[192.0.2.45]:25, delay=1.2, delays=0.1/0/1/0.1, dsn=5.6.3, status=bounced (message has 8-bit content, but the server does not support 8BITMIME)":          "8bit_conversion_failed",
+		"9F3E12A0C1: to=<info@example.org>, relay=mx.example.org[192.0.2.45]:25, delay=1.2, delays=0.1/0/1/0.1, dsn=2.0.0, status=sent (250 2.0.0 Ok)":                                                                    "",
+		"9F3E12A0C1: to=<info@example.org>, relay=mx.example.org[192.0.2.45]:25, delay=1.2, delays=0.1/0/1/0.1, dsn=2.0.0, status=sent (250 2.0.0 Ok: SMTPUTF8 message queued)":                                           "",
+	} {
+		assert.Equal(t, event, classifyMIMEEvent(line), line)
+	}
+}
+
+func TestParseLogline_SmtpdProxyReject(t *testing.T) {
+	t.Parallel()
+
+	for line, code := range map[string]string{
+		"NOQUEUE: proxy-reject: END-OF-MESSAGE: 550 5.7.1 Message rejected as spam; from=<spam@example.net> to=<info@example.com> proto=ESMTP helo=<spam.example.net>": "550",
+		"3F1A52C0E1: proxy-reject: END-OF-MESSAGE: 451 4.3.0 Error: queue file write error; from=<a@example.net> to=<b@example.com> proto=ESMTP helo=<example.net>":    "451",
+	} {
+		result := parseLogLine("postfix", "Oct  4 09:23:00 mail postfix/smtpd[4805]: "+line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, code, result.smtpd.proxyReject, line)
+	}
+}
+
+func TestParseLogline_SmtpdRejectStage(t *testing.T) {
+	t.Parallel()
+
+	for line, stage := range map[string]string{
+		"NOQUEUE: reject: CONNECT from unknown[192.0.2.1]: 554 5.7.1 <unknown[192.0.2.1]>: Client host rejected: Access denied; proto=SMTP":                                       "connect",
+		"NOQUEUE: reject: EHLO from unknown[192.0.2.1]: 504 5.5.2 <localhost>: Helo command rejected: need fully-qualified hostname; proto=ESMTP helo=<localhost>":                "helo",
+		"NOQUEUE: reject: MAIL from unknown[192.0.2.1]: 552 5.3.4 Message size exceeds fixed limit; proto=ESMTP helo=<example.com>":                                               "mail",
+		"NOQUEUE: reject: RCPT from unknown[192.0.2.1]: 554 5.7.1 <b@example.org>: Relay access denied; from=<a@example.com> to=<b@example.org> proto=ESMTP helo=<example.com>":   "rcpt",
+		"NOQUEUE: reject: DATA from unknown[192.0.2.1]: 550 5.5.3 <DATA>: Data command rejected: Multi-recipient bounce; from=<> proto=ESMTP helo=<example.com>":                  "data",
+		"3F1A52C0E1: reject: END-OF-MESSAGE from unknown[192.0.2.1]: 550 5.7.1 <END-OF-MESSAGE>: End-of-data rejected: spam; from=<a@example.com> proto=ESMTP helo=<example.com>": "end_of_message",
+	} {
+		result := parseLogLine("postfix", "Oct  4 09:24:00 mail postfix/smtpd[4806]: "+line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, stage, result.smtpd.rejectStage, line)
+		assert.Equal(t, "192.0.2.1", result.smtpd.clientIP, line)
+	}
+}
+
+func TestParseLogline_AccessAction(t *testing.T) {
+	t.Parallel()
+
+	for line, action := range map[string]string{
+		"Oct  4 09:25:00 mail postfix/cleanup[4807]: 5B2C1A0D33: hold: header Subject: Invoice from unknown[192.0.2.78]; from=<a@example.net> to=<b@example.com> proto=ESMTP helo=<example.net>":                        "hold",
+		"Oct  4 09:25:00 mail postfix/cleanup[4807]: 5B2C1A0D33: redirect: header To: b@example.com from unknown[192.0.2.78]; from=<a@example.net> to=<b@example.com> proto=ESMTP helo=<example.net>: c@example.com":    "redirect",
+		"Oct  4 09:25:00 mail postfix/smtpd[4808]: 5B2C1A0D33: discard: RCPT from unknown[192.0.2.78]: <b@example.com>: Recipient address triggers DISCARD action; from=<a@example.net> to=<b@example.com> proto=ESMTP": "discard",
+		"Oct  4 09:25:00 mail postfix/smtpd[4808]: NOQUEUE: filter: RCPT from unknown[192.0.2.78]: <a@example.net>: Sender address triggers FILTER smtp:[127.0.0.1]:10025; from=<a@example.net> to=<b@example.com>":     "filter",
+	} {
+		result := parseLogLine("postfix", line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, action, result.accessAction, line)
+	}
+}
+
+func TestParseLogline_SmtpdRejectWarning(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", "Oct  4 09:26:00 mail postfix/smtpd[4809]: NOQUEUE: reject_warning: RCPT from unknown[192.0.2.79]: 450 4.7.1 <mail.example.net>: Helo command rejected: Host not found; from=<a@example.net> to=<b@example.com> proto=ESMTP helo=<mail.example.net>")
+	assert.False(t, result.unsupported)
+	assert.Empty(t, result.warning)
+	assert.True(t, result.smtpd.rejectWarning)
+	assert.Equal(t, "450", result.smtpd.reject)
+	assert.Equal(t, "rcpt", result.smtpd.rejectStage)
+	assert.Equal(t, "helo", result.smtpd.rejectReason)
+}
+
+func TestParseLogline_SmtpConnectError(t *testing.T) {
+	t.Parallel()
+
+	for line, expected := range map[string]string{
+		"connect to mx.example.net[192.0.2.25]:25: Connection timed out":     "timed_out",
+		"connect to mx.example.net[192.0.2.25]:25: Connection refused":       "refused",
+		"connect to mx.example.net[2001:db8::25]:25: Network is unreachable": "network_unreachable",
+		"connect to mx.example.net[192.0.2.25]:25: No route to host":         "no_route_to_host",
+	} {
+		result := parseLogLine("postfix", "Oct  4 09:27:00 mail postfix/smtp[4810]: "+line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, expected, result.smtp.connectError, line)
+		assert.Equal(t, expected == "timed_out", result.smtp.timeout, line)
+	}
+}
+
+func TestRelayIPFamily(t *testing.T) {
+	t.Parallel()
+
+	for relay, expected := range map[string]string{
+		"mx.example.com[192.0.2.1]:25":   "ipv4",
+		"mx.example.com[2001:db8::1]:25": "ipv6",
+		"[::ffff:192.0.2.1]:25":          "ipv4",
+		"none":                           "",
+		"local":                          "",
+	} {
+		assert.Equal(t, expected, relayIPFamily(relay), relay)
+	}
+}
+
+func TestRelayPort(t *testing.T) {
+	t.Parallel()
+
+	for relay, expected := range map[string]string{
+		"mx.example.com[192.0.2.1]:25":      "25",
+		"smtp.example.com[2001:db8::1]:587": "587",
+		"mx.example.com[192.0.2.1]":         "",
+		"none":                              "",
+	} {
+		assert.Equal(t, expected, relayPort(relay), relay)
+	}
+}
+
+func TestParseLogline_Master(t *testing.T) {
+	t.Parallel()
+
+	result := parseLogLine("postfix", `Oct  4 09:28:00 mail postfix/master[1001]: warning: service "submission" (587) has reached its process limit "100": new clients may be delayed`)
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "submission", result.master.processLimit)
+	assert.Equal(t, "resource", result.warning)
+
+	result = parseLogLine("postfix", "Oct  4 09:28:05 mail postfix/master[1001]: warning: process /usr/libexec/postfix/smtpd pid 4811 exit status 1")
+	assert.False(t, result.unsupported)
+	assert.Equal(t, "smtpd", result.master.exitProgram)
+	assert.Equal(t, "exit", result.master.exitKind)
+	assert.Equal(t, "1", result.master.status)
+}
+
+func TestParseLogline_MilterError(t *testing.T) {
+	t.Parallel()
+
+	for line, expected := range map[string][2]string{
+		"Oct  4 09:29:00 mail postfix/smtpd[4812]: warning: connect to Milter service inet:127.0.0.1:8891: Connection refused":                                             {"inet:127.0.0.1:8891", "connection_refused"},
+		"Oct  4 09:29:00 mail postfix/smtpd[4812]: warning: milter unix:/run/opendkim/opendkim.sock: can't read SMFIC_EOH reply packet header: Connection reset by peer":   {"unix:/run/opendkim/opendkim.sock", "connection_lost"},
+		"Oct  4 09:29:00 mail postfix/cleanup[4813]: 5B2C1A0D33: warning: milter inet:127.0.0.1:11332: can't read SMFIC_BODYEOB reply packet header: Connection timed out": {"inet:127.0.0.1:11332", "timeout"},
+	} {
+		result := parseLogLine("postfix", line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, expected[0], result.milter, line)
+		assert.Equal(t, expected[1], result.milterError, line)
+	}
+}
+
+func TestParseLogline_MasterLifecycle(t *testing.T) {
+	t.Parallel()
+
+	for line, expected := range map[string][2]string{
+		"daemon started -- version 3.7.11, configuration /etc/postfix": {"start", "3.7.11"},
+		"reload -- version 3.8.1, configuration /etc/postfix":          {"reload", "3.8.1"},
+		"terminating on signal 15":                                     {"stop", ""},
+	} {
+		result := parseLogLine("postfix", "Oct  4 09:30:00 mail postfix/master[1001]: "+line)
+		assert.False(t, result.unsupported, line)
+		assert.Equal(t, expected[0], result.master.lifecycle, line)
+		assert.Equal(t, expected[1], result.master.version, line)
+	}
+}
+
+func TestPostfixDaemons(t *testing.T) {
+	t.Parallel()
+
+	for subprocess := range postfixParsers {
+		assert.Contains(t, postfixDaemons, subprocess, "The parser should be registered for all daemons it parses.")
+	}
+}
@@ -0,0 +1,129 @@
+// Package logsource defines the interfaces the Postfix exporter reads
+// log lines through, and a registry of factories to create them.
+//
+// Custom log sources can be compiled into the exporter by registering
+// a LogSourceFactory from an `init` function, and importing the package
+// containing it into the exporter's main package (e.g. by adding a file
+// containing just a blank import). The source is then selectable with
+// `--log.source`.
+package logsource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// A LogSource is an interface to read log lines.
+type LogSource interface {
+	// Path returns a representation of the log location.
+	Path() string
+
+	// Read returns the next log line. Returns `io.EOF` at the end of
+	// the log. It must return once the context is done, with the
+	// context's error, so reading can be stopped.
+	Read(context.Context) (string, error)
+}
+
+// A LogSourceCloser is a LogSource which must be closed after use.
+type LogSourceCloser interface {
+	io.Closer
+	LogSource
+}
+
+// A LogSourceFactory provides a repository of log sources that can be
+// instantiated from command line flags.
+type LogSourceFactory interface {
+	// Name identifies a log source.
+	Name() string
+
+	// Init adds the factory's struct fields as flags in the
+	// application.
+	Init(*kingpin.Application)
+
+	// New attempts to create a new log source. This is called after
+	// flags have been parsed. Returning `nil, nil`, means the user
+	// didn't want this log source.
+	New(context.Context) (LogSourceCloser, error)
+}
+
+type onceKey struct{}
+
+// WithOnce returns a context which tells LogSourceFactory.New to create
+// a log source which reads the existing log lines and then returns
+// `io.EOF`, instead of waiting for new lines.
+func WithOnce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, onceKey{}, true)
+}
+
+// Once reports whether the context was created by WithOnce.
+func Once(ctx context.Context) bool {
+	once, _ := ctx.Value(onceKey{}).(bool)
+
+	return once
+}
+
+var (
+	mu        sync.Mutex
+	factories []LogSourceFactory
+)
+
+// Register can be called from module `init` functions to register
+// factories.
+func Register(f LogSourceFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	factories = append(factories, f)
+}
+
+// Factories returns all registered factories, in registration order.
+func Factories() []LogSourceFactory {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]LogSourceFactory(nil), factories...)
+}
+
+// Names returns the sorted names of all registered factories.
+func Names() []string {
+	lsf := Factories()
+	names := make([]string, 0, len(lsf))
+	for _, f := range lsf {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Init runs Init on all factories, in registration order.
+func Init(app *kingpin.Application) {
+	for _, f := range Factories() {
+		f.Init(app)
+	}
+}
+
+// New iterates through the factories and attempts to instantiate the
+// log source with the matching name. The first factory to return success
+// wins.
+func New(ctx context.Context, name string) (LogSourceCloser, error) {
+	for _, f := range Factories() {
+		if f.Name() != name {
+			continue
+		}
+		src, err := f.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if src != nil {
+			return src, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no log source configured")
+}
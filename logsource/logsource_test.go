@@ -0,0 +1,52 @@
+package logsource
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type testSource struct{}
+
+func (testSource) Path() string                         { return "test" }
+func (testSource) Read(context.Context) (string, error) { return "", io.EOF }
+func (testSource) Close() error                         { return nil }
+
+type testFactory struct {
+	enabled bool
+}
+
+func (*testFactory) Name() string { return "test" }
+
+func (f *testFactory) Init(app *kingpin.Application) {
+	app.Flag("test.enabled", "Enable the test log source.").BoolVar(&f.enabled)
+}
+
+func (f *testFactory) New(context.Context) (LogSourceCloser, error) {
+	if !f.enabled {
+		return nil, nil
+	}
+
+	return testSource{}, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register(&testFactory{})
+	assert.Contains(t, Names(), "test")
+
+	app := kingpin.New("test", "")
+	Init(app)
+	_, err := app.Parse([]string{"--test.enabled"})
+	require.NoError(t, err)
+
+	src, err := New(context.Background(), "test")
+	require.NoError(t, err)
+	assert.Equal(t, "test", src.Path())
+
+	_, err = New(context.Background(), "unknown")
+	assert.EqualError(t, err, "no log source configured")
+}
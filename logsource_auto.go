@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/digineo/postfix_exporter/logsource"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// autoDetectOrder lists the log sources probed by --log.source=auto,
+// in order. Sources not compiled in are skipped.
+var autoDetectOrder = []string{"systemd", "file", "docker"}
+
+// A logSourceDetector is a logsource.LogSourceFactory which can check whether its
+// (default) log location contains Postfix log lines.
+type logSourceDetector interface {
+	// Detect returns a new log source if Postfix log lines were found.
+	// Returning `nil, nil` means no Postfix log lines were found.
+	Detect(context.Context) (logsource.LogSourceCloser, error)
+}
+
+// containsPostfixLines checks whether `r` contains at least one line
+// logged by Postfix.
+func containsPostfixLines(r io.Reader) bool {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if _, ok := splitLogLine(scanner.Text()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// An autoLogSourceFactory picks the first log source in autoDetectOrder
+// which contains Postfix log lines.
+type autoLogSourceFactory struct{}
+
+func (*autoLogSourceFactory) Name() string { return "auto" }
+
+func (*autoLogSourceFactory) Init(*kingpin.Application) {}
+
+func (*autoLogSourceFactory) New(ctx context.Context) (logsource.LogSourceCloser, error) {
+	for _, name := range autoDetectOrder {
+		for _, f := range logsource.Factories() {
+			d, ok := f.(logSourceDetector)
+			if !ok || f.Name() != name {
+				continue
+			}
+
+			src, err := d.Detect(ctx)
+			if err != nil {
+				log.Printf("Auto-detection of %s log source failed: %v", name, err)
+
+				continue
+			}
+			if src != nil {
+				log.Printf("Auto-detected %s log source: %s", name, src.Path())
+
+				return src, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no Postfix log lines found in any of %v", autoDetectOrder)
+}
+
+func init() {
+	logsource.Register(&autoLogSourceFactory{})
+}
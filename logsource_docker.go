@@ -10,6 +10,7 @@ import (
 	"log"
 	"strings"
 
+	"github.com/digineo/postfix_exporter/logsource"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -20,7 +21,11 @@ import (
 type DockerLogSource struct {
 	client      DockerClient
 	containerID string
-	reader      *bufio.Reader
+	logs        io.ReadCloser
+
+	lines  chan string   // read from logs, closed at the end
+	err    error         // of reading logs, once lines is closed
+	closed chan struct{} // closed by Close
 }
 
 // A DockerClient is the client interface that client.Client
@@ -30,14 +35,22 @@ type DockerClient interface {
 	ContainerLogs(context.Context, string, types.ContainerLogsOptions) (io.ReadCloser, error)
 }
 
-// NewDockerLogSource returns a log source for reading Docker logs.
+// NewDockerLogSource returns a log source for reading Docker logs. If
+// `ctx` was created by logsource.WithOnce, all existing logs are read
+// instead of following new ones.
 func NewDockerLogSource(ctx context.Context, c DockerClient, containerID string) (*DockerLogSource, error) {
-	r, err := c.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{
+	opts := types.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Follow:     true,
 		Tail:       "0",
-	})
+	}
+	if logsource.Once(ctx) {
+		opts.Follow = false
+		opts.Tail = "all"
+	}
+
+	r, err := c.ContainerLogs(ctx, containerID, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -45,13 +58,41 @@ func NewDockerLogSource(ctx context.Context, c DockerClient, containerID string)
 	logSrc := &DockerLogSource{
 		client:      c,
 		containerID: containerID,
-		reader:      bufio.NewReader(r),
+		logs:        r,
+		lines:       make(chan string),
+		closed:      make(chan struct{}),
 	}
+	go logSrc.readLines()
 
 	return logSrc, nil
 }
 
+// readLines sends the lines of the logs to s.lines, until the end of
+// the logs or until the source is closed. Reading in the background
+// lets Read return once its context is done.
+func (s *DockerLogSource) readLines() {
+	defer close(s.lines)
+
+	reader := bufio.NewReader(s.logs)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			s.err = err
+
+			return
+		}
+		select {
+		case s.lines <- strings.TrimSpace(line):
+		case <-s.closed:
+			return
+		}
+	}
+}
+
 func (s *DockerLogSource) Close() error {
+	close(s.closed)
+	s.logs.Close()
+
 	return s.client.Close()
 }
 
@@ -60,12 +101,16 @@ func (s *DockerLogSource) Path() string {
 }
 
 func (s *DockerLogSource) Read(ctx context.Context) (string, error) {
-	line, err := s.reader.ReadString('\n')
-	if err != nil {
-		return "", err
+	select {
+	case line, ok := <-s.lines:
+		if !ok {
+			return "", s.err
+		}
+
+		return line, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
 	}
-
-	return strings.TrimSpace(line), nil
 }
 
 // A dockerLogSourceFactory is a factory that can create
@@ -80,7 +125,7 @@ func (f *dockerLogSourceFactory) Init(app *kingpin.Application) {
 	app.Flag("docker.container.id", "ID/name of the Postfix Docker container. Environment variable DOCKER_HOST can be used to change the address. See https://pkg.go.dev/github.com/docker/docker/client?tab=doc#NewEnvClient for more information.").Default("postfix").StringVar(&f.containerID)
 }
 
-func (f *dockerLogSourceFactory) New(ctx context.Context) (LogSourceCloser, error) {
+func (f *dockerLogSourceFactory) New(ctx context.Context) (logsource.LogSourceCloser, error) {
 	log.Println("Reading log events from Docker")
 	c, err := client.NewEnvClient()
 	if err != nil {
@@ -90,6 +135,32 @@ func (f *dockerLogSourceFactory) New(ctx context.Context) (LogSourceCloser, erro
 	return NewDockerLogSource(ctx, c, f.containerID)
 }
 
+func (f *dockerLogSourceFactory) Detect(ctx context.Context) (logsource.LogSourceCloser, error) {
+	c, err := client.NewEnvClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	r, err := c.ContainerLogs(ctx, f.containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       "100",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if !containsPostfixLines(r) {
+		log.Printf("No Postfix log lines found in container %s", f.containerID)
+
+		return nil, nil
+	}
+
+	return f.New(ctx)
+}
+
 func init() {
-	logSourceFactories.Register(&dockerLogSourceFactory{})
+	logsource.Register(&dockerLogSourceFactory{})
 }
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/stretchr/testify/assert"
@@ -78,6 +79,10 @@ type fakeDockerClient struct {
 func (c *fakeDockerClient) ContainerLogs(ctx context.Context, containerID string, opts types.ContainerLogsOptions) (io.ReadCloser, error) {
 	c.containerLogsCalls = append(c.containerLogsCalls, containerID)
 
+	if c.logsReader == nil {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
 	return c.logsReader, nil
 }
 
@@ -86,3 +91,20 @@ func (c *fakeDockerClient) Close() error {
 
 	return nil
 }
+
+func TestDockerLogSource_ReadCancel(t *testing.T) {
+	t.Parallel()
+
+	r, w := io.Pipe()
+	defer w.Close()
+	src, err := NewDockerLogSource(context.Background(), &fakeDockerClient{logsReader: r}, "acontainer")
+	if err != nil {
+		t.Fatalf("NewDockerLogSource failed: %v", err)
+	}
+	defer src.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = src.Read(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "Read should return when the context is done.")
+}
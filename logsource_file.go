@@ -2,36 +2,139 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/digineo/postfix_exporter/logsource"
 	"github.com/nxadm/tail"
+	"github.com/nxadm/tail/watch"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+// detectFileSize is the number of bytes at the end of a log file which
+// are inspected when auto-detecting the log source.
+const detectFileSize = 64 * 1024
+
+// detectFilePaths are probed by --log.source=auto in addition to
+// --logfile.path.
+var detectFilePaths = []string{"/var/log/mail.log", "/var/log/maillog"}
+
+// checkpointInterval limits how often the read position of a file is
+// persisted.
+const checkpointInterval = 5 * time.Second
+
 // A FileLogSource can read lines from a file.
 type FileLogSource struct {
 	tailer *tail.Tail
+
+	// stateFile is where the read position is persisted. Checkpointing
+	// is disabled if empty.
+	stateFile string
+
+	mu       sync.Mutex
+	pos      filePosition
+	lastSave time.Time
+	backfill *backfillReader
+}
+
+// filePosition is the checkpointed read position of a log file.
+type filePosition struct {
+	Inode  uint64    `json:"inode"`
+	Offset int64     `json:"offset"`
+	Time   time.Time `json:"time"`
 }
 
 // NewFileLogSource creates a new log source, tailing the given file.
-func NewFileLogSource(path string) (*FileLogSource, error) {
+// If `stateDir` is non-empty, the read position is persisted there and
+// restored on the next start. If additionally `backfill` is set, lines
+// which were written to already rotated files since the last checkpoint
+// are read before tailing the current file. If `once` is set, the file
+// is read from the beginning (or the checkpoint) to its end, instead of
+// tailing it.
+func NewFileLogSource(path, stateDir string, backfill, once bool) (*FileLogSource, error) {
+	s := &FileLogSource{}
+	location := &tail.SeekInfo{Whence: io.SeekEnd} // seek to end of file
+	if once {
+		location.Whence = io.SeekStart
+	}
+
+	if stateDir != "" {
+		s.stateFile = stateFileName(stateDir, "file", path)
+
+		var err error
+		if location, err = s.restorePosition(path, backfill, once); err != nil {
+			return nil, err
+		}
+	}
+
 	tailer, err := tail.TailFile(path, tail.Config{
-		ReOpen:    true,                               // reopen the file if it's rotated
-		MustExist: true,                               // fail immediately if the file is missing or has incorrect permissions
-		Follow:    true,                               // run in follow mode
-		Location:  &tail.SeekInfo{Whence: io.SeekEnd}, // seek to end of file
+		ReOpen:    !once, // reopen the file if it's rotated
+		MustExist: true,  // fail immediately if the file is missing or has incorrect permissions
+		Follow:    !once, // run in follow mode
+		Location:  location,
 		Logger:    tail.DiscardingLogger,
 	})
 	if err != nil {
 		return nil, err
 	}
+	s.tailer = tailer
+
+	return s, nil
+}
+
+// restorePosition loads the checkpoint and determines where to start
+// reading. Without a checkpoint, we start at the end of the file (or
+// at the beginning if `once` is set). If the file was rotated or
+// truncated in the meantime, all of its content is new to us and we
+// start at the beginning (optionally after reading the rotated files).
+func (s *FileLogSource) restorePosition(path string, backfill, once bool) (*tail.SeekInfo, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	inode := fileInode(fi)
+
+	var pos filePosition
+	found, err := readStateFile(s.stateFile, &pos)
+	if err != nil {
+		return nil, err
+	}
+	s.pos.Inode = inode
 
-	return &FileLogSource{tailer}, nil
+	if !found {
+		if once {
+			return &tail.SeekInfo{Whence: io.SeekStart}, nil
+		}
+		s.pos.Offset = fi.Size()
+
+		return &tail.SeekInfo{Whence: io.SeekEnd}, nil
+	}
+
+	if pos.Inode == inode && pos.Offset <= fi.Size() {
+		log.Printf("Resuming %s at offset %d", path, pos.Offset)
+		s.pos.Offset = pos.Offset
+	} else {
+		log.Printf("%s was rotated or truncated, reading from the beginning", path)
+
+		if backfill && !pos.Time.IsZero() {
+			if s.backfill, err = newBackfillReader(path, pos); err != nil {
+				return nil, err
+			}
+			for _, f := range s.backfill.files {
+				log.Printf("Backfilling from %s", f.path)
+			}
+		}
+	}
+
+	return &tail.SeekInfo{Offset: s.pos.Offset, Whence: io.SeekStart}, nil
 }
 
 func (s *FileLogSource) Close() error {
-	defer s.tailer.Cleanup()
 	go func() {
 		// Stop() waits for the tailer goroutine to shut down, but it
 		// can be blocking on sending on the Lines channel...
@@ -39,7 +142,42 @@ func (s *FileLogSource) Close() error {
 		}
 	}()
 
-	return s.tailer.Stop()
+	err := s.tailer.Stop()
+
+	// The inotify watch of the file is removed asynchronously after
+	// Stop(). Wait for it, so the file can be tailed again when the log
+	// source is reopened on a reload (which is also why Cleanup() must
+	// not be called).
+	for i := 0; i < 100 && watched(s.tailer.Filename); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.stateFile != "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.backfill != nil {
+			s.backfill.Close()
+		}
+
+		if serr := s.savePosition(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	return err
+}
+
+// watched returns whether the tail package has an inotify watch of the
+// file at `path`.
+func watched(path string) (ok bool) {
+	defer func() {
+		// The tail package panics if it never watched any file.
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	return watch.Events(filepath.Clean(path)) != nil
 }
 
 func (s *FileLogSource) Path() string {
@@ -47,11 +185,18 @@ func (s *FileLogSource) Path() string {
 }
 
 func (s *FileLogSource) Read(ctx context.Context) (string, error) {
+	if line, ok := s.readBackfill(); ok {
+		return line, nil
+	}
+
 	select {
 	case line, ok := <-s.tailer.Lines:
 		if !ok {
 			return "", io.EOF
 		}
+		if s.stateFile != "" {
+			s.advance(line)
+		}
 
 		return line.Text, nil
 	case <-ctx.Done():
@@ -59,30 +204,149 @@ func (s *FileLogSource) Read(ctx context.Context) (string, error) {
 	}
 }
 
+// readBackfill returns the next line from the rotated files, if
+// backfilling is still in progress.
+func (s *FileLogSource) readBackfill() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.backfill == nil {
+		return "", false
+	}
+
+	line, err := s.backfill.ReadLine()
+	if err == nil {
+		return line, true
+	}
+	if err != io.EOF {
+		log.Printf("Failed to backfill from rotated log files: %v", err)
+	}
+	s.backfill.Close()
+	s.backfill = nil
+
+	return "", false
+}
+
+// advance records the position after the given line and periodically
+// persists it.
+func (s *FileLogSource) advance(line *tail.Line) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if line.Num == 1 {
+		// The tailer (re)opened the file, which might have been
+		// replaced by a new one.
+		if fi, err := os.Stat(s.tailer.Filename); err == nil {
+			s.pos.Inode = fileInode(fi)
+		}
+	}
+	s.pos.Offset = line.SeekInfo.Offset
+
+	if time.Since(s.lastSave) < checkpointInterval {
+		return
+	}
+	if err := s.savePosition(); err != nil {
+		log.Printf("Failed to save read position of %s: %v", s.tailer.Filename, err)
+	}
+}
+
+// savePosition persists the current read position. The caller must
+// hold s.mu.
+func (s *FileLogSource) savePosition() error {
+	s.lastSave = time.Now()
+	s.pos.Time = s.lastSave
+
+	return writeStateFile(s.stateFile, &s.pos)
+}
+
 // A fileLogSourceFactory is a factory than can create log sources
 // from command line flags.
 //
 // Because this factory is enabled by default, it must always be
 // registered last.
 type fileLogSourceFactory struct {
-	path string
+	path     string
+	stateDir string
+	backfill bool
 }
 
 func (*fileLogSourceFactory) Name() string { return "file" }
 
 func (f *fileLogSourceFactory) Init(app *kingpin.Application) {
 	app.Flag("logfile.path", "Path where Postfix writes log entries.").Default("/var/log/mail.log").StringVar(&f.path)
+	app.Flag("log.file.state-dir", "Directory to persist the read position of the log file in. Disabled if empty.").Default("").StringVar(&f.stateDir)
+	app.Flag("log.file.backfill", "On startup, read lines from rotated log files written since the last persisted read position. Requires --log.file.state-dir.").BoolVar(&f.backfill)
 }
 
-func (f *fileLogSourceFactory) New(ctx context.Context) (LogSourceCloser, error) {
+func (f *fileLogSourceFactory) New(ctx context.Context) (logsource.LogSourceCloser, error) {
 	if f.path == "" {
 		return nil, nil
 	}
+	if err := f.checkBackfill(); err != nil {
+		return nil, err
+	}
 	log.Printf("Reading log events from %s", f.path)
 
-	return NewFileLogSource(f.path)
+	return NewFileLogSource(f.path, f.stateDir, f.backfill, logsource.Once(ctx))
+}
+
+// checkBackfill rejects --log.file.backfill without a state directory,
+// which has no read position to backfill from.
+func (f *fileLogSourceFactory) checkBackfill() error {
+	if f.backfill && f.stateDir == "" {
+		return fmt.Errorf("--log.file.backfill requires --log.file.state-dir")
+	}
+
+	return nil
+}
+
+func (f *fileLogSourceFactory) Detect(ctx context.Context) (logsource.LogSourceCloser, error) {
+	if err := f.checkBackfill(); err != nil {
+		return nil, err
+	}
+	paths := []string{f.path}
+	for _, p := range detectFilePaths {
+		if p != f.path {
+			paths = append(paths, p)
+		}
+	}
+
+	for _, p := range paths {
+		found, err := fileContainsPostfixLines(p)
+		if err != nil {
+			log.Printf("Skipping %s: %v", p, err)
+
+			continue
+		}
+		if found {
+			return NewFileLogSource(p, f.stateDir, f.backfill, logsource.Once(ctx))
+		}
+		log.Printf("No Postfix log lines found in %s", p)
+	}
+
+	return nil, nil
+}
+
+// fileContainsPostfixLines checks the end of the given file for Postfix
+// log lines.
+func fileContainsPostfixLines(path string) (bool, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+
+	if fi, err := fd.Stat(); err != nil {
+		return false, err
+	} else if fi.Size() > detectFileSize {
+		if _, err := fd.Seek(-detectFileSize, io.SeekEnd); err != nil {
+			return false, err
+		}
+	}
+
+	return containsPostfixLines(fd), nil
 }
 
 func init() {
-	logSourceFactories.Register(&fileLogSourceFactory{})
+	logsource.Register(&fileLogSourceFactory{})
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// rotatedLogSuffix matches the suffixes logrotate and newsyslog append
+// to rotated log files, e.g. "mail.log.1" or "mail.log.2.gz".
+var rotatedLogSuffix = regexp.MustCompile(`^\.\d+(\.gz)?$`)
+
+// A backfillReader reads the lines of rotated log files which were
+// written while the exporter was not running, oldest first.
+type backfillReader struct {
+	files   []backfillFile
+	reader  *bufio.Reader
+	closers []io.Closer
+}
+
+type backfillFile struct {
+	path   string
+	offset int64 // number of (uncompressed) bytes to skip
+}
+
+// newBackfillReader looks for rotated versions of `path`, which have
+// been modified after the checkpoint `pos` was taken. The oldest of
+// them is assumed to be the file which was tailed at that time, and is
+// read from the checkpointed offset onwards. This is verified using the
+// inode number for uncompressed files. All other files are read in full.
+func newBackfillReader(path string, pos filePosition) (*backfillReader, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		path string
+		fi   os.FileInfo
+	}
+	var candidates []candidate
+	for _, m := range matches {
+		if !rotatedLogSuffix.MatchString(strings.TrimPrefix(m, path)) {
+			continue
+		}
+		fi, err := os.Stat(m)
+		if err != nil {
+			return nil, err
+		}
+		if fi.ModTime().Before(pos.Time) {
+			continue
+		}
+		candidates = append(candidates, candidate{m, fi})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].fi.ModTime().Before(candidates[j].fi.ModTime())
+	})
+
+	r := &backfillReader{}
+	for i, c := range candidates {
+		f := backfillFile{path: c.path}
+		if i == 0 && (strings.HasSuffix(c.path, ".gz") || fileInode(c.fi) == pos.Inode) {
+			f.offset = pos.Offset
+		}
+		r.files = append(r.files, f)
+	}
+
+	return r, nil
+}
+
+// ReadLine returns the next line. Returns `io.EOF` after the last line
+// of the newest file.
+func (r *backfillReader) ReadLine() (string, error) {
+	for {
+		if r.reader == nil {
+			if len(r.files) == 0 {
+				return "", io.EOF
+			}
+			if err := r.open(r.files[0]); err != nil {
+				return "", err
+			}
+			r.files = r.files[1:]
+		}
+
+		line, err := r.reader.ReadString('\n')
+		if err == io.EOF {
+			r.Close()
+			if line == "" {
+				continue
+			}
+		} else if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(line, "\n"), nil
+	}
+}
+
+func (r *backfillReader) open(f backfillFile) error {
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	r.closers = append(r.closers, fd)
+
+	var rd io.Reader = fd
+	if strings.HasSuffix(f.path, ".gz") {
+		gz, err := gzip.NewReader(fd)
+		if err != nil {
+			r.Close()
+
+			return err
+		}
+		r.closers = append(r.closers, gz)
+		rd = gz
+	}
+
+	if _, err := io.CopyN(io.Discard, rd, f.offset); err != nil && err != io.EOF {
+		r.Close()
+
+		return err
+	}
+	r.reader = bufio.NewReader(rd)
+
+	return nil
+}
+
+// Close closes the currently opened file.
+func (r *backfillReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if cerr := r.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	r.closers = nil
+	r.reader = nil
+
+	return err
+}
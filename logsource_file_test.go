@@ -1,10 +1,13 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -21,7 +24,7 @@ func TestFileLogSource_Path(t *testing.T) {
 	}
 	defer closeLog()
 
-	src, err := NewFileLogSource(path)
+	src, err := NewFileLogSource(path, "", false, false)
 	if err != nil {
 		t.Fatalf("NewFileLogSource failed: %v", err)
 	}
@@ -41,7 +44,7 @@ func TestFileLogSource_Read(t *testing.T) {
 	}
 	defer closeLog()
 
-	src, err := NewFileLogSource(path)
+	src, err := NewFileLogSource(path, "", false, false)
 	if err != nil {
 		t.Fatalf("NewFileLogSource failed: %v", err)
 	}
@@ -54,6 +57,32 @@ func TestFileLogSource_Read(t *testing.T) {
 	assert.Equal(t, "Feb 13 23:31:30 ahost anid[123]: aline", s, "Read should get data from the journal entry.")
 }
 
+func TestFileLogSource_Reopen(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path, closeLog, err := setupFakeLogFile()
+	if err != nil {
+		t.Fatalf("setupFakeTailer failed: %v", err)
+	}
+	defer closeLog()
+
+	for i := 0; i < 2; i++ {
+		src, err := NewFileLogSource(path, "", false, false)
+		if err != nil {
+			t.Fatalf("NewFileLogSource failed: %v", err)
+		}
+		if _, err := src.Read(ctx); err != nil {
+			t.Fatalf("Read failed after opening the log file %d times: %v", i+1, err)
+		}
+		if err := src.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+}
+
 func setupFakeLogFile() (string, func(), error) {
 	f, err := ioutil.TempFile("", "filelogsource")
 	if err != nil {
@@ -89,3 +118,192 @@ func setupFakeLogFile() (string, func(), error) {
 		wg.Wait()
 	}, nil
 }
+
+func TestFileLogSource_Once(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "filelogsource")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.log")
+	if err := ioutil.WriteFile(path, []byte("first line\nsecond line\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	src, err := NewFileLogSource(path, "", false, true)
+	if err != nil {
+		t.Fatalf("NewFileLogSource failed: %v", err)
+	}
+	defer src.Close()
+
+	for _, expected := range []string{"first line", "second line"} {
+		s, err := src.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		assert.Equal(t, expected, s, "Read should start at the beginning of the file.")
+	}
+
+	_, err = src.Read(ctx)
+	assert.Equal(t, io.EOF, err, "Read should stop at the end of the file.")
+}
+
+func TestFileLogSource_Checkpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "filelogsource")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.log")
+	if err := ioutil.WriteFile(path, []byte("first line\nsecond line\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	// Pretend we have stopped right at the beginning of the file.
+	stateFile := stateFileName(dir, "file", path)
+	if err := writeStateFile(stateFile, &filePosition{Inode: fileInode(fi)}); err != nil {
+		t.Fatalf("writeStateFile failed: %v", err)
+	}
+
+	for _, expected := range []string{"first line", "second line"} {
+		src, err := NewFileLogSource(path, dir, false, false)
+		if err != nil {
+			t.Fatalf("NewFileLogSource failed: %v", err)
+		}
+
+		s, err := src.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		assert.Equal(t, expected, s, "Read should resume after the checkpoint.")
+
+		if err := src.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+
+	var pos filePosition
+	found, err := readStateFile(stateFile, &pos)
+	if err != nil {
+		t.Fatalf("readStateFile failed: %v", err)
+	}
+	assert.True(t, found, "Close should persist the read position.")
+	assert.Equal(t, fileInode(fi), pos.Inode)
+	assert.Equal(t, fi.Size(), pos.Offset)
+}
+
+func TestFileLogSource_Backfill(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "filelogsource")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.log")
+	if err := ioutil.WriteFile(path, []byte("fourth line\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// This file was rotated after the checkpoint was taken, whose
+	// offset is right after the first line.
+	f, err := os.Create(path + ".1.gz")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	fmt.Fprint(gz, "first line\nsecond line\nthird line\n")
+	gz.Close()
+	f.Close()
+
+	// This one is too old.
+	if err := ioutil.WriteFile(path+".2", []byte("zeroth line\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path+".2", old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	stateFile := stateFileName(dir, "file", path)
+	if err := writeStateFile(stateFile, &filePosition{Offset: 11, Time: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("writeStateFile failed: %v", err)
+	}
+
+	src, err := NewFileLogSource(path, dir, true, false)
+	if err != nil {
+		t.Fatalf("NewFileLogSource failed: %v", err)
+	}
+	defer src.Close()
+
+	for _, expected := range []string{"second line", "third line", "fourth line"} {
+		s, err := src.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		assert.Equal(t, expected, s, "Read should backfill from rotated files first.")
+	}
+}
+
+func TestFileLogSourceFactory_BackfillWithoutStateDir(t *testing.T) {
+	t.Parallel()
+
+	f := &fileLogSourceFactory{path: "/var/log/mail.log", backfill: true}
+	_, err := f.New(context.Background())
+	assert.Error(t, err, "Backfill without state directory should be rejected.")
+}
+
+func TestFileLogSourceFactory_Detect(t *testing.T) { //nolint:paralleltest // modifies detectFilePaths
+	defer func(paths []string) { detectFilePaths = paths }(detectFilePaths)
+	detectFilePaths = nil
+
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "filelogsource")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.log")
+	if err := ioutil.WriteFile(path, []byte("Feb 13 23:31:30 ahost dovecot[123]: aline\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	f := &fileLogSourceFactory{path: path}
+	src, err := f.Detect(ctx)
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+	assert.Nil(t, src, "Detect should ignore files without Postfix lines.")
+
+	if err := ioutil.WriteFile(path, []byte("Feb 13 23:31:30 ahost postfix/smtpd[123]: aline\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	src, err = f.Detect(ctx)
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+	if assert.NotNil(t, src, "Detect should pick up files with Postfix lines.") {
+		assert.Equal(t, path, src.Path())
+		src.Close()
+	}
+}
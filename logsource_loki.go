@@ -0,0 +1,270 @@
+//go:build !noloki
+// +build !noloki
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/digineo/postfix_exporter/logsource"
+	"golang.org/x/net/websocket"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// A LokiLogSource reads log lines from Grafana Loki, using its tail
+// API. See https://grafana.com/docs/loki/latest/api/#stream-log-messages
+type LokiLogSource struct {
+	baseURL *url.URL
+	query   string
+	orgID   string
+
+	// mu is held while reading, so that concurrent readers, e.g. of
+	// multiple instances, take turns on the connection.
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	pending []lokiEntry
+	lastTS  int64
+}
+
+type lokiEntry struct {
+	ts   int64
+	line string
+}
+
+// lokiTailResponse is a message sent by Loki over the websocket.
+type lokiTailResponse struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	} `json:"streams"`
+	DroppedEntries []struct {
+		Labels    map[string]string `json:"labels"`
+		Timestamp string            `json:"timestamp"`
+	} `json:"dropped_entries"`
+}
+
+// NewLokiLogSource connects to the Loki instance at `baseURL` and
+// starts tailing the log lines matching the LogQL `query`. If `orgID`
+// is non-empty, it is sent as tenant ID.
+func NewLokiLogSource(baseURL, query, orgID string) (*LokiLogSource, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &LokiLogSource{
+		baseURL: u,
+		query:   query,
+		orgID:   orgID,
+		lastTS:  time.Now().UnixNano(),
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// connect (re-)establishes the websocket connection, starting right
+// after the last received entry.
+func (s *LokiLogSource) connect() error {
+	u := *s.baseURL
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path += "/loki/api/v1/tail"
+	u.User = nil
+	u.RawQuery = url.Values{
+		"query": {s.query},
+		"start": {strconv.FormatInt(s.lastTS+1, 10)}, // don't receive the last entry twice
+	}.Encode()
+
+	origin := *s.baseURL
+	origin.User = nil
+	cfg, err := websocket.NewConfig(u.String(), origin.String())
+	if err != nil {
+		return err
+	}
+	if s.orgID != "" {
+		cfg.Header.Set("X-Scope-OrgID", s.orgID)
+	}
+	if user := s.baseURL.User; user != nil {
+		pass, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+		cfg.Header.Set("Authorization", "Basic "+auth)
+	}
+
+	conn, err := websocket.DialConfig(cfg)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+
+	return nil
+}
+
+func (s *LokiLogSource) Close() error {
+	return s.conn.Close()
+}
+
+func (s *LokiLogSource) Path() string {
+	return fmt.Sprintf("loki:%s %s", s.baseURL.Redacted(), s.query)
+}
+
+func (s *LokiLogSource) Read(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.pending) == 0 {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := s.receive(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	e := s.pending[0]
+	s.pending = s.pending[1:]
+	if e.ts > s.lastTS {
+		s.lastTS = e.ts
+	}
+
+	return e.line, nil
+}
+
+// receive waits for the next message from Loki. If receiving fails,
+// e.g. because Loki closed the connection, it reconnects, with backoff,
+// until `ctx` is done. The caller must hold s.mu.
+func (s *LokiLogSource) receive(ctx context.Context) error {
+	var (
+		resp lokiTailResponse
+		b    backoff
+	)
+	for {
+		err := s.receiveMessage(ctx, &resp)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err == io.EOF {
+			log.Printf("Loki closed the connection, reconnecting")
+		} else {
+			log.Printf("Failed to receive from Loki, reconnecting: %s", err)
+		}
+		if err := s.reconnect(ctx, &b); err != nil {
+			return err
+		}
+	}
+
+	if n := len(resp.DroppedEntries); n > 0 {
+		log.Printf("Loki dropped %d entries", n)
+	}
+
+	for _, stream := range resp.Streams {
+		for _, v := range stream.Values {
+			ts, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				log.Printf("Skipping Loki entry with invalid timestamp %q", v[0])
+
+				continue
+			}
+			s.pending = append(s.pending, lokiEntry{ts: ts, line: v[1]})
+		}
+	}
+
+	// Entries of different streams are interleaved.
+	sort.SliceStable(s.pending, func(i, j int) bool {
+		return s.pending[i].ts < s.pending[j].ts
+	})
+
+	return nil
+}
+
+// reconnect replaces the connection, retrying with backoff `b` until
+// `ctx` is done.
+func (s *LokiLogSource) reconnect(ctx context.Context, b *backoff) error {
+	s.conn.Close()
+	for {
+		if err := b.Wait(ctx); err != nil {
+			return err
+		}
+		err := s.connect()
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to reconnect to Loki: %s", err)
+	}
+}
+
+// receiveMessage receives a single message, until `ctx` is done. The
+// read deadline of the connection is used to interrupt the receiving.
+func (s *LokiLogSource) receiveMessage(ctx context.Context, resp *lokiTailResponse) error {
+	conn := s.conn
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err := websocket.JSON.Receive(conn, resp)
+	close(done)
+	<-stopped
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// A lokiLogSourceFactory is a factory that can create LokiLogSources
+// from command line flags.
+type lokiLogSourceFactory struct {
+	url, query, orgID string
+}
+
+func (*lokiLogSourceFactory) Name() string { return "loki" }
+
+func (f *lokiLogSourceFactory) Init(app *kingpin.Application) {
+	app.Flag("loki.url", "Base URL of the Loki server. May contain basic auth credentials.").Default("http://localhost:3100").StringVar(&f.url)
+	app.Flag("loki.query", "LogQL query selecting the Postfix log lines.").Default(`{job="postfix"}`).StringVar(&f.query)
+	app.Flag("loki.org-id", "Tenant ID to send to Loki (X-Scope-OrgID header).").Default("").StringVar(&f.orgID)
+}
+
+func (f *lokiLogSourceFactory) New(ctx context.Context) (logsource.LogSourceCloser, error) {
+	if logsource.Once(ctx) {
+		return nil, fmt.Errorf("the Loki log source can't be read once")
+	}
+
+	log.Println("Reading log events from Loki")
+
+	return NewLokiLogSource(f.url, f.query, f.orgID)
+}
+
+func init() {
+	logsource.Register(&lokiLogSourceFactory{})
+}
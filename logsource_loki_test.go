@@ -0,0 +1,177 @@
+//go:build !noloki
+// +build !noloki
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestLokiLogSource_Read(t *testing.T) {
+	t.Parallel()
+
+	var (
+		query, orgID, auth string
+	)
+	handler := websocket.Handler(func(c *websocket.Conn) {
+		defer c.Close()
+
+		// Two streams, with interleaved entries.
+		websocket.Message.Send(c, `{"streams":[
+			{"stream":{"host":"a"},"values":[["1000","line 1"],["3000","line 3"]]},
+			{"stream":{"host":"b"},"values":[["2000","line 2"]]}
+		]}`)
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/loki/api/v1/tail", r.URL.Path)
+		query = r.URL.Query().Get("query")
+		orgID = r.Header.Get("X-Scope-OrgID")
+		auth = r.Header.Get("Authorization")
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	u := strings.Replace(srv.URL, "http://", "http://user:secret@", 1)
+	src, err := NewLokiLogSource(u, `{job="postfix"}`, "tenant")
+	require.NoError(t, err)
+	defer src.Close()
+
+	assert.Equal(t, "loki:"+strings.Replace(u, "secret", "xxxxx", 1)+` {job="postfix"}`, src.Path())
+
+	for _, expected := range []string{"line 1", "line 2", "line 3"} {
+		s, err := src.Read(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, expected, s, "Read should return entries ordered by time.")
+	}
+
+	assert.Equal(t, `{job="postfix"}`, query)
+	assert.Equal(t, "tenant", orgID)
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", auth)
+}
+
+func TestLokiLogSource_ReadCancel(t *testing.T) {
+	t.Parallel()
+
+	closed := make(chan struct{})
+	srv := httptest.NewServer(websocket.Handler(func(c *websocket.Conn) {
+		<-closed // sends nothing
+		c.Close()
+	}))
+	defer srv.Close()
+	defer close(closed)
+
+	src, err := NewLokiLogSource(srv.URL, `{job="postfix"}`, "")
+	require.NoError(t, err)
+	defer src.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = src.Read(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second, "Read should return when the context is done.")
+}
+
+// TestLokiLogSource_ReadConcurrently detects unsynchronized reads when
+// run with -race.
+func TestLokiLogSource_ReadConcurrently(t *testing.T) {
+	t.Parallel()
+
+	closed := make(chan struct{})
+	srv := httptest.NewServer(websocket.Handler(func(c *websocket.Conn) {
+		websocket.Message.Send(c, `{"streams":[{"stream":{},"values":[["1000","line 1"],["2000","line 2"]]}]}`)
+		websocket.Message.Send(c, `{"streams":[{"stream":{},"values":[["3000","line 3"],["4000","line 4"]]}]}`)
+		<-closed
+		c.Close()
+	}))
+	defer srv.Close()
+	defer close(closed)
+
+	src, err := NewLokiLogSource(srv.URL, `{job="postfix"}`, "")
+	require.NoError(t, err)
+	defer src.Close()
+
+	// Like the readers of multiple instances.
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		lines []string
+	)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2; j++ {
+				s, err := src.Read(context.Background())
+				assert.NoError(t, err)
+				mu.Lock()
+				lines = append(lines, s)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.ElementsMatch(t, []string{"line 1", "line 2", "line 3", "line 4"}, lines)
+}
+
+func TestLokiLogSource_Reconnect(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		starts []string
+	)
+	closed := make(chan struct{})
+	handler := websocket.Handler(func(c *websocket.Conn) {
+		defer c.Close()
+
+		mu.Lock()
+		n := len(starts)
+		mu.Unlock()
+		if n == 1 {
+			websocket.Message.Send(c, `{"streams":[{"stream":{},"values":[["x","line 0"],["1000","line 1"]]}]}`)
+			websocket.Message.Send(c, "not JSON")
+			<-closed
+
+			return
+		}
+		websocket.Message.Send(c, `{"streams":[{"stream":{},"values":[["2000","line 2"]]}]}`)
+		<-closed
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		starts = append(starts, r.URL.Query().Get("start"))
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	defer close(closed)
+
+	src, err := NewLokiLogSource(srv.URL, `{job="postfix"}`, "")
+	require.NoError(t, err)
+	defer src.Close()
+
+	for _, expected := range []string{"line 1", "line 2"} {
+		s, err := src.Read(context.Background())
+		require.NoError(t, err, "Read should reconnect after an error.")
+		assert.Equal(t, expected, s, "Entries with invalid timestamps should be skipped.")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, starts, 2)
+	assert.Equal(t, "1001", starts[1], "The last entry should not be received twice.")
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/digineo/postfix_exporter/logsource"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// maxSyslogMessageSize limits the size of a single syslog message.
+const maxSyslogMessageSize = 64 * 1024
+
+// defaultSyslogQueueSize is the default number of received messages
+// which are buffered until they're processed.
+const defaultSyslogQueueSize = 10000
+
+// A SyslogLogSource receives log records from remote syslog daemons,
+// e.g. rsyslog or syslog-ng forwarding the Postfix logs.
+//
+// Received messages are buffered in a bounded queue. If the queue is
+// full, because messages arrive faster than they are processed, new
+// messages are dropped (and counted) instead of blocking the network
+// reader.
+type SyslogLogSource struct {
+	path     string
+	listener net.Listener   // stream sockets (tcp)
+	conn     net.PacketConn // datagram sockets (udp)
+	lines    chan string
+	dropped  prometheus.Counter
+
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+	closed  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewSyslogLogSource starts listening on the given address. `network`
+// must be either "tcp" or "udp". If `tlsConfig` is non-nil, TCP
+// connections are wrapped in TLS. At most `queueSize` messages are
+// buffered.
+func NewSyslogLogSource(network, addr string, tlsConfig *tls.Config, queueSize int) (*SyslogLogSource, error) {
+	switch network {
+	case "tcp":
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewStreamSyslogLogSource(l, tlsConfig, queueSize), nil
+	case "udp":
+		if tlsConfig != nil {
+			return nil, fmt.Errorf("TLS is not supported for %s", network)
+		}
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewDatagramSyslogLogSource(conn, queueSize), nil
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q", network)
+	}
+}
+
+// NewStreamSyslogLogSource receives syslog messages from connections
+// accepted by `l`. If `tlsConfig` is non-nil, connections are wrapped
+// in TLS.
+func NewStreamSyslogLogSource(l net.Listener, tlsConfig *tls.Config, queueSize int) *SyslogLogSource {
+	network := l.Addr().Network()
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+		network += "+tls"
+	}
+
+	s := newSyslogLogSource(fmt.Sprintf("syslog:%s://%s", network, l.Addr()), queueSize)
+	s.listener = l
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s
+}
+
+// NewDatagramSyslogLogSource receives syslog messages from `conn`.
+func NewDatagramSyslogLogSource(conn net.PacketConn, queueSize int) *SyslogLogSource {
+	s := newSyslogLogSource(fmt.Sprintf("syslog:%s://%s", conn.LocalAddr().Network(), conn.LocalAddr()), queueSize)
+	s.conn = conn
+
+	s.wg.Add(1)
+	go s.receive()
+
+	return s
+}
+
+func newSyslogLogSource(path string, queueSize int) *SyslogLogSource {
+	return &SyslogLogSource{
+		path:  path,
+		lines: make(chan string, queueSize),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   "postfix_exporter",
+			Name:        "dropped_lines_total",
+			Help:        "Total number of received log lines dropped because the queue was full.",
+			ConstLabels: prometheus.Labels{"path": path},
+		}),
+		clients: make(map[net.Conn]struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (s *SyslogLogSource) Close() error {
+	close(s.closed)
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	if s.conn != nil {
+		err = s.conn.Close()
+	}
+
+	s.mu.Lock()
+	for c := range s.clients {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *SyslogLogSource) Path() string {
+	return s.path
+}
+
+// Describe implements prometheus.Collector.
+func (s *SyslogLogSource) Describe(ch chan<- *prometheus.Desc) {
+	s.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *SyslogLogSource) Collect(ch chan<- prometheus.Metric) {
+	s.dropped.Collect(ch)
+}
+
+func (s *SyslogLogSource) Read(ctx context.Context) (string, error) {
+	select {
+	case line := <-s.lines:
+		return line, nil
+	case <-s.closed:
+		return "", io.EOF
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// accept handles incoming stream connections.
+func (s *SyslogLogSource) accept() {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.closed:
+			default:
+				log.Printf("Failed to accept syslog connection: %v", err)
+			}
+
+			return
+		}
+
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			c.Close()
+
+			return
+		default:
+			s.clients[c] = struct{}{}
+		}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// handle reads syslog messages from a stream connection, until the
+// client disconnects.
+func (s *SyslogLogSource) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	for {
+		line, err := readSyslogFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-s.closed:
+				default:
+					log.Printf("Failed to read from syslog client %s: %v", c.RemoteAddr(), err)
+				}
+			}
+
+			return
+		}
+		if !s.emit(line) {
+			return
+		}
+	}
+}
+
+// receive reads syslog messages from a datagram socket. Each datagram
+// carries one message.
+func (s *SyslogLogSource) receive() {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxSyslogMessageSize)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+			default:
+				log.Printf("Failed to read syslog datagram: %v", err)
+			}
+
+			return
+		}
+		if !s.emit(strings.TrimRight(string(buf[:n]), "\r\n")) {
+			return
+		}
+	}
+}
+
+// emit queues a line for Read. If the queue is full, the line is
+// dropped. Returns false if the log source was closed in the meantime.
+func (s *SyslogLogSource) emit(line string) bool {
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+
+	select {
+	case s.lines <- line:
+	default:
+		s.dropped.Inc()
+	}
+
+	return true
+}
+
+// maxSyslogFrameLengthDigits is the number of digits of the length
+// of octet-counted frames at most, enough for maxSyslogMessageSize.
+const maxSyslogFrameLengthDigits = 10
+
+// readSyslogFrame reads the next message from a syslog stream. Both
+// octet-counting and non-transparent (newline) framing are supported,
+// see RFC 6587, section 3.4. Frames larger than maxSyslogMessageSize
+// are rejected.
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	n, ok, err := peekSyslogFrameLength(r)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return readSyslogLine(r)
+	}
+	if n > maxSyslogMessageSize {
+		return "", fmt.Errorf("syslog frame too large (%d bytes)", n)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// peekSyslogFrameLength reports whether the next frame is octet-counted,
+// i.e. starts with its length followed by a space, and consumes the
+// length. Other frames, e.g. starting with a timestamp instead of a
+// PRI, are left for newline framing. Only as many bytes are peeked as
+// needed to tell, so that a short last message is not waited upon.
+func peekSyslogFrameLength(r *bufio.Reader) (n int, ok bool, err error) {
+	for i := 0; i <= maxSyslogFrameLengthDigits; i++ {
+		b, err := r.Peek(i + 1)
+		if len(b) <= i {
+			if i == 0 {
+				return 0, false, err
+			}
+
+			return 0, false, nil
+		}
+
+		c := b[i]
+		if c == ' ' && i > 0 {
+			if n, err = strconv.Atoi(string(b[:i])); err != nil {
+				return 0, false, fmt.Errorf("invalid syslog frame length %q", b[:i])
+			}
+			if _, err = r.Discard(i + 1); err != nil {
+				return 0, false, err
+			}
+
+			return n, true, nil
+		}
+		if c < '0' || c > '9' || c == '0' && i == 0 {
+			return 0, false, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// readSyslogLine reads a newline-terminated message. Longer messages
+// than maxSyslogMessageSize are rejected before reading them further.
+func readSyslogLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadSlice('\n')
+		if len(line)+len(b) > maxSyslogMessageSize {
+			return "", fmt.Errorf("syslog message too large (more than %d bytes)", maxSyslogMessageSize)
+		}
+		line = append(line, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return "", err
+		}
+
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// newSyslogTLSConfig creates a TLS server configuration. If
+// `clientCAFile` is non-empty, clients must present a certificate
+// signed by one of the CAs contained in that file.
+func newSyslogTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// A syslogLogSourceFactory is a factory that can create
+// SyslogLogSources from command line flags.
+type syslogLogSourceFactory struct {
+	network, addr                    string
+	systemdSocket                    bool
+	queueSize                        int
+	tlsCert, tlsKey, tlsClientCAFile string
+}
+
+func (*syslogLogSourceFactory) Name() string { return "syslog" }
+
+func (f *syslogLogSourceFactory) Init(app *kingpin.Application) {
+	app.Flag("syslog.listen-address", "Address to receive syslog messages on.").Default(":5140").StringVar(&f.addr)
+	app.Flag("syslog.protocol", "Transport protocol to receive syslog messages with.").Default("tcp").EnumVar(&f.network, "tcp", "udp")
+	app.Flag("syslog.systemd-socket", "Use the socket named \""+systemdSocketSyslog+"\" passed by systemd socket activation instead of --syslog.listen-address.").BoolVar(&f.systemdSocket)
+	app.Flag("syslog.queue-size", "Maximum number of received messages to buffer. Further messages are dropped. Must be positive.").Default(strconv.Itoa(defaultSyslogQueueSize)).IntVar(&f.queueSize)
+	app.Flag("syslog.tls.cert-file", "Server certificate file. Enables TLS (tcp only).").Default("").StringVar(&f.tlsCert)
+	app.Flag("syslog.tls.key-file", "Server private key file.").Default("").StringVar(&f.tlsKey)
+	app.Flag("syslog.tls.client-ca-file", "CA certificates to verify client certificates with. Enables mutual authentication.").Default("").StringVar(&f.tlsClientCAFile)
+}
+
+func (f *syslogLogSourceFactory) New(ctx context.Context) (logsource.LogSourceCloser, error) {
+	if logsource.Once(ctx) {
+		return nil, fmt.Errorf("the syslog log source can't be read once")
+	}
+	if f.queueSize < 1 {
+		return nil, fmt.Errorf("--syslog.queue-size must be positive")
+	}
+
+	var tlsConfig *tls.Config
+	if f.tlsCert != "" {
+		var err error
+		if tlsConfig, err = newSyslogTLSConfig(f.tlsCert, f.tlsKey, f.tlsClientCAFile); err != nil {
+			return nil, err
+		}
+	} else if f.tlsClientCAFile != "" {
+		return nil, fmt.Errorf("client certificate verification requires --syslog.tls.cert-file")
+	}
+
+	src, err := f.newLogSource(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Reading log events from %s", src.Path())
+
+	return src, nil
+}
+
+func (f *syslogLogSourceFactory) newLogSource(tlsConfig *tls.Config) (*SyslogLogSource, error) {
+	if !f.systemdSocket {
+		return NewSyslogLogSource(f.network, f.addr, tlsConfig, f.queueSize)
+	}
+
+	if f.network == "udp" {
+		if tlsConfig != nil {
+			return nil, fmt.Errorf("TLS is not supported for %s", f.network)
+		}
+		conn, err := systemdPacketConn(systemdSocketSyslog)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewDatagramSyslogLogSource(conn, f.queueSize), nil
+	}
+
+	l, err := systemdListener(systemdSocketSyslog)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStreamSyslogLogSource(l, tlsConfig, f.queueSize), nil
+}
+
+func init() {
+	logsource.Register(&syslogLogSourceFactory{})
+}
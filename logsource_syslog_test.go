@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const syslogTestLine = "<22>Feb 13 23:31:30 ahost postfix/smtpd[123]: aline"
+
+func TestSyslogLogSource_ReadTCP(t *testing.T) {
+	t.Parallel()
+
+	src, err := NewSyslogLogSource("tcp", "127.0.0.1:0", nil, defaultSyslogQueueSize)
+	require.NoError(t, err)
+	defer src.Close()
+
+	assert.True(t, strings.HasPrefix(src.Path(), "syslog:tcp://127.0.0.1:"), "Path should contain the listen address.")
+
+	c, err := net.Dial("tcp", src.listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	fmt.Fprintf(c, "%s\n%d %s", syslogTestLine, len(syslogTestLine), syslogTestLine)
+
+	for i := 0; i < 2; i++ {
+		s, err := src.Read(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, syslogTestLine, s, "Read should support both framing methods.")
+	}
+}
+
+func TestSyslogLogSource_ReadUDP(t *testing.T) {
+	t.Parallel()
+
+	src, err := NewSyslogLogSource("udp", "127.0.0.1:0", nil, defaultSyslogQueueSize)
+	require.NoError(t, err)
+	defer src.Close()
+
+	c, err := net.Dial("udp", src.conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Write([]byte(syslogTestLine))
+	require.NoError(t, err)
+
+	s, err := src.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, syslogTestLine, s)
+}
+
+func TestSyslogLogSource_MutualTLS(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCertificate(t, "ca", nil)
+	server := newTestCertificate(t, "server", &ca)
+	client := newTestCertificate(t, "client", &ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	src, err := NewSyslogLogSource("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, defaultSyslogQueueSize)
+	require.NoError(t, err)
+	defer src.Close()
+
+	addr := src.listener.Addr().String()
+	assert.Equal(t, "syslog:tcp+tls://"+addr, src.Path())
+
+	// Clients without certificate are rejected.
+	c, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, ServerName: "server", MinVersion: tls.VersionTLS13})
+	if err == nil {
+		// With TLS 1.3, the server verifies the client certificate
+		// after the client considers the handshake to be complete.
+		_, err = bufio.NewReader(c).ReadByte()
+		c.Close()
+	}
+	assert.Error(t, err, "Clients without certificate should be rejected.")
+
+	c, err = tls.Dial("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{client},
+		RootCAs:      pool,
+		ServerName:   "server",
+		MinVersion:   tls.VersionTLS12,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	fmt.Fprintln(c, syslogTestLine)
+
+	s, err := src.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, syslogTestLine, s)
+}
+
+func TestSyslogLogSource_Drop(t *testing.T) {
+	t.Parallel()
+
+	src, err := NewSyslogLogSource("tcp", "127.0.0.1:0", nil, 1)
+	require.NoError(t, err)
+	defer src.Close()
+
+	c, err := net.Dial("tcp", src.listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	fmt.Fprintf(c, "first\nsecond\nthird\n")
+	c.Close()
+
+	// Wait for the connection to be fully processed.
+	require.Eventually(t, func() bool {
+		src.mu.Lock()
+		defer src.mu.Unlock()
+
+		return len(src.clients) == 0
+	}, time.Second, time.Millisecond)
+
+	s, err := src.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "first", s, "The oldest line should be kept.")
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(src)
+	n, err := testutil.GatherAndCount(reg, "postfix_exporter_dropped_lines_total")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 2.0, testutil.ToFloat64(src.dropped), "Further lines should be dropped.")
+}
+
+func TestSyslogLogSourceFactory_QueueSize(t *testing.T) {
+	t.Parallel()
+
+	for _, size := range []int{-1, 0} {
+		f := &syslogLogSourceFactory{network: "udp", addr: "127.0.0.1:0", queueSize: size}
+		_, err := f.New(context.Background())
+		assert.Error(t, err, "Queue size %d should be rejected.", size)
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	t.Parallel()
+
+	r := bufio.NewReader(strings.NewReader("5 hello3 foobar\r\n99999 "))
+
+	for _, expected := range []string{"hello", "foo", "bar"} {
+		s, err := readSyslogFrame(r)
+		require.NoError(t, err)
+		assert.Equal(t, expected, s)
+	}
+
+	_, err := readSyslogFrame(r)
+	assert.EqualError(t, err, "syslog frame too large (99999 bytes)")
+}
+
+func TestReadSyslogFrame_NewlineWithoutPRI(t *testing.T) {
+	t.Parallel()
+
+	const line = "2023-06-01T10:00:00Z mail postfix/smtpd[123]: connect from unknown[192.0.2.1]"
+	r := bufio.NewReader(strings.NewReader(line + "\n12345\n"))
+
+	for _, expected := range []string{line, "12345"} {
+		s, err := readSyslogFrame(r)
+		require.NoError(t, err)
+		assert.Equal(t, expected, s)
+	}
+}
+
+func TestReadSyslogFrame_TooLarge(t *testing.T) {
+	t.Parallel()
+
+	for _, frame := range []string{
+		strings.Repeat("a", maxSyslogMessageSize) + "\n",
+		strings.Repeat("1", maxSyslogMessageSize+1),
+	} {
+		_, err := readSyslogFrame(bufio.NewReader(strings.NewReader(frame)))
+		assert.EqualError(t, err, "syslog message too large (more than 65536 bytes)")
+	}
+}
+
+// newTestCertificate creates a certificate for the given common name,
+// signed by `parent`. If `parent` is nil, a self-signed CA certificate
+// is created.
+func newTestCertificate(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
@@ -8,20 +8,44 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-systemd/v22/sdjournal"
+	"github.com/digineo/postfix_exporter/logsource"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-// timeNow is a test fake injection point.
-var timeNow = time.Now
+// Start modes of the SystemdLogSource.
+const (
+	systemdStartResume    = "resume"     // continue after the persisted cursor, tail without one
+	systemdStartTail      = "tail"       // only read new entries
+	systemdStartReplayAll = "replay-all" // read the whole journal
+
+	// systemdStartResumeOrReplayAll continues after the persisted
+	// cursor, and reads the whole journal without one. Used for --once.
+	systemdStartResumeOrReplayAll = "resume-or-replay-all"
+)
 
 // A SystemdLogSource reads log records from the given Systemd
 // journal.
 type SystemdLogSource struct {
 	journal SystemdJournal
 	path    string
+
+	// stateFile is where the journal cursor is persisted. Disabled if
+	// empty.
+	stateFile string
+
+	mu       sync.Mutex
+	cursor   string
+	lastSave time.Time
+}
+
+// journalPosition is the checkpointed read position in the journal.
+type journalPosition struct {
+	Cursor string `json:"cursor"`
 }
 
 // A SystemdJournal is the journal interface that sdjournal.Journal
@@ -31,15 +55,22 @@ type SystemdJournal interface {
 	AddMatch(match string) error
 	GetEntry() (*sdjournal.JournalEntry, error)
 	Next() (uint64, error)
+	SeekCursor(cursor string) error
+	SeekHead() error
 	SeekRealtimeUsec(usec uint64) error
 	Wait(timeout time.Duration) int
 }
 
 // NewSystemdLogSource returns a log source for reading Systemd
 // journal entries. `unit` and `slice` provide filtering if non-empty
-// (with `slice` taking precedence).
-func NewSystemdLogSource(j SystemdJournal, path, unit, slice string) (*SystemdLogSource, error) {
+// (with `slice` taking precedence). `start` determines where to start
+// reading (see systemdStart* constants). If `stateDir` is non-empty,
+// the journal cursor is persisted there.
+func NewSystemdLogSource(j SystemdJournal, path, unit, slice, start, stateDir string) (*SystemdLogSource, error) {
 	logSrc := &SystemdLogSource{journal: j, path: path}
+	if stateDir != "" {
+		logSrc.stateFile = stateFileName(stateDir, "journal", path)
+	}
 
 	var err error
 	if slice != "" {
@@ -53,8 +84,7 @@ func NewSystemdLogSource(j SystemdJournal, path, unit, slice string) (*SystemdLo
 		return nil, err
 	}
 
-	// Start at end of journal
-	if err := logSrc.journal.SeekRealtimeUsec(uint64(timeNow().UnixNano() / 1000)); err != nil {
+	if err := logSrc.seek(start); err != nil {
 		logSrc.journal.Close()
 
 		return nil, err
@@ -69,7 +99,58 @@ func NewSystemdLogSource(j SystemdJournal, path, unit, slice string) (*SystemdLo
 	return logSrc, nil
 }
 
+// seek moves to the start position.
+func (s *SystemdLogSource) seek(start string) error {
+	switch start {
+	case systemdStartReplayAll:
+		return s.journal.SeekHead()
+	case systemdStartResume, systemdStartResumeOrReplayAll:
+		if s.stateFile == "" {
+			break
+		}
+
+		var pos journalPosition
+		found, err := readStateFile(s.stateFile, &pos)
+		if err != nil {
+			return err
+		}
+		if !found || pos.Cursor == "" {
+			break
+		}
+
+		log.Printf("Resuming journal at cursor %s", pos.Cursor)
+		if err := s.journal.SeekCursor(pos.Cursor); err != nil {
+			return err
+		}
+		// The entry at the cursor has already been read.
+		_, err = s.journal.Next()
+		s.cursor = pos.Cursor
+
+		return err
+	case systemdStartTail:
+	default:
+		return fmt.Errorf("unknown journal start mode %q", start)
+	}
+
+	if start == systemdStartResumeOrReplayAll {
+		return s.journal.SeekHead()
+	}
+
+	// Start at end of journal
+	return s.journal.SeekRealtimeUsec(uint64(timeNow().UnixNano() / 1000))
+}
+
 func (s *SystemdLogSource) Close() error {
+	if s.stateFile != "" {
+		s.mu.Lock()
+		err := s.saveCursor()
+		s.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Failed to save journal cursor: %v", err)
+		}
+	}
+
 	return s.journal.Close()
 }
 
@@ -90,6 +171,9 @@ func (s *SystemdLogSource) Read(ctx context.Context) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if s.stateFile != "" {
+		s.advance(e.Cursor)
+	}
 	ts := time.Unix(0, int64(e.RealtimeTimestamp)*int64(time.Microsecond))
 
 	return fmt.Sprintf(
@@ -102,10 +186,36 @@ func (s *SystemdLogSource) Read(ctx context.Context) (string, error) {
 	), nil
 }
 
+// advance records the cursor of the last read entry and periodically
+// persists it.
+func (s *SystemdLogSource) advance(cursor string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cursor = cursor
+	if time.Since(s.lastSave) < checkpointInterval {
+		return
+	}
+	if err := s.saveCursor(); err != nil {
+		log.Printf("Failed to save journal cursor: %v", err)
+	}
+}
+
+// saveCursor persists the current cursor. The caller must hold s.mu.
+func (s *SystemdLogSource) saveCursor() error {
+	s.lastSave = time.Now()
+	if s.cursor == "" {
+		return nil
+	}
+
+	return writeStateFile(s.stateFile, &journalPosition{Cursor: s.cursor})
+}
+
 // A systemdLogSourceFactory is a factory that can create
 // SystemdLogSources from command line flags.
 type systemdLogSourceFactory struct {
 	unit, slice, path string
+	start, stateDir   string
 }
 
 func (*systemdLogSourceFactory) Name() string { return "systemd" }
@@ -114,16 +224,78 @@ func (f *systemdLogSourceFactory) Init(app *kingpin.Application) {
 	app.Flag("systemd.unit", "Name of the Postfix systemd unit.").Default("postfix@-.service").StringVar(&f.unit)
 	app.Flag("systemd.slice", "Name of the Postfix systemd slice. Overrides the systemd unit.").Default("").StringVar(&f.slice)
 	app.Flag("systemd.journal_path", "Path to the systemd journal").Default("").StringVar(&f.path)
+	app.Flag("systemd.start", "Where to start reading the journal: after the persisted cursor (falling back to tail), at the end, or at the beginning.").Default(systemdStartResume).EnumVar(&f.start, systemdStartResume, systemdStartTail, systemdStartReplayAll)
+	app.Flag("systemd.state-dir", "Directory to persist the journal cursor in. Disabled if empty.").Default("").StringVar(&f.stateDir)
 }
 
-func (f *systemdLogSourceFactory) New(ctx context.Context) (LogSourceCloser, error) {
+func (f *systemdLogSourceFactory) New(ctx context.Context) (logsource.LogSourceCloser, error) {
 	log.Println("Reading log events from systemd")
 	j, path, err := newSystemdJournal(f.path)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewSystemdLogSource(j, path, f.unit, f.slice)
+	start := f.start
+	if start == systemdStartResume && logsource.Once(ctx) {
+		start = systemdStartResumeOrReplayAll
+	}
+
+	return NewSystemdLogSource(j, path, f.unit, f.slice, start, f.stateDir)
+}
+
+// detectEntries is the number of most recent journal entries which are
+// inspected when auto-detecting the log source.
+const detectEntries = 100
+
+func (f *systemdLogSourceFactory) Detect(ctx context.Context) (logsource.LogSourceCloser, error) {
+	j, path, err := newSystemdJournal(f.path)
+	if err != nil {
+		return nil, err
+	}
+	found, err := journalContainsPostfixEntries(j, f.unit, f.slice)
+	j.Close()
+	if err != nil || !found {
+		if err == nil {
+			log.Printf("No Postfix log entries found in %s", path)
+		}
+
+		return nil, err
+	}
+
+	return f.New(ctx)
+}
+
+// journalContainsPostfixEntries checks the most recent entries of the
+// given unit (or slice) for Postfix log entries.
+func journalContainsPostfixEntries(j *sdjournal.Journal, unit, slice string) (bool, error) {
+	var err error
+	if slice != "" {
+		err = j.AddMatch("_SYSTEMD_SLICE=" + slice)
+	} else if unit != "" {
+		err = j.AddMatch("_SYSTEMD_UNIT=" + unit)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := j.SeekTail(); err != nil {
+		return false, err
+	}
+	for i := 0; i < detectEntries; i++ {
+		n, err := j.Previous()
+		if err != nil || n == 0 {
+			return false, err
+		}
+		e, err := j.GetEntry()
+		if err != nil {
+			return false, err
+		}
+		if strings.HasPrefix(e.Fields["SYSLOG_IDENTIFIER"], "postfix") {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
 // newSystemdJournal creates a journal handle. It returns the handle
@@ -142,5 +314,5 @@ func newSystemdJournal(path string) (*sdjournal.Journal, string, error) {
 }
 
 func init() {
-	logSourceFactories.Register(&systemdLogSourceFactory{})
+	logsource.Register(&systemdLogSourceFactory{})
 }
@@ -6,6 +6,7 @@ package main
 import (
 	"context"
 	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
@@ -18,7 +19,7 @@ func TestNewSystemdLogSource(t *testing.T) {
 	t.Parallel()
 
 	j := &fakeSystemdJournal{}
-	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice")
+	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice", systemdStartTail, "")
 	if err != nil {
 		t.Fatalf("NewSystemdLogSource failed: %v", err)
 	}
@@ -38,7 +39,7 @@ func TestSystemdLogSource_Path(t *testing.T) {
 	t.Parallel()
 
 	j := &fakeSystemdJournal{}
-	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice")
+	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice", systemdStartTail, "")
 	if err != nil {
 		t.Fatalf("NewSystemdLogSource failed: %v", err)
 	}
@@ -66,7 +67,7 @@ func TestSystemdLogSource_Read(t *testing.T) {
 		},
 		nextValues: []uint64{1},
 	}
-	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice")
+	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice", systemdStartTail, "")
 	if err != nil {
 		t.Fatalf("NewSystemdLogSource failed: %v", err)
 	}
@@ -87,7 +88,7 @@ func TestSystemdLogSource_ReadEOF(t *testing.T) {
 	j := &fakeSystemdJournal{
 		nextValues: []uint64{0},
 	}
-	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice")
+	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice", systemdStartTail, "")
 	if err != nil {
 		t.Fatalf("NewSystemdLogSource failed: %v", err)
 	}
@@ -97,15 +98,74 @@ func TestSystemdLogSource_ReadEOF(t *testing.T) {
 	assert.Equal(t, io.EOF, err, "Should interpret Next 0 as EOF.")
 }
 
-func TestMain(m *testing.M) {
-	// We compare Unix timestamps to date strings, so make it deterministic.
-	os.Setenv("TZ", "UTC")
-	timeNow = func() time.Time { return time.Date(2009, 2, 13, 23, 31, 30, 0, time.UTC) }
-	defer func() {
-		timeNow = time.Now
-	}()
+func TestSystemdLogSource_ReplayAll(t *testing.T) {
+	t.Parallel()
+
+	j := &fakeSystemdJournal{}
+	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice", systemdStartReplayAll, "")
+	if err != nil {
+		t.Fatalf("NewSystemdLogSource failed: %v", err)
+	}
+	defer src.Close()
+
+	assert.Equal(t, 1, j.seekHeadCalls, "A call to SeekHead should be made.")
+	assert.Empty(t, j.seekRealtimeUsecCalls, "No call to SeekRealtimeUsec should be made.")
+}
+
+func TestSystemdLogSource_ResumeOrReplayAll(t *testing.T) {
+	t.Parallel()
+
+	j := &fakeSystemdJournal{}
+	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice", systemdStartResumeOrReplayAll, "")
+	if err != nil {
+		t.Fatalf("NewSystemdLogSource failed: %v", err)
+	}
+	defer src.Close()
+
+	assert.Equal(t, 1, j.seekHeadCalls, "Without a cursor, a call to SeekHead should be made.")
+	assert.Empty(t, j.seekRealtimeUsecCalls, "No call to SeekRealtimeUsec should be made.")
+}
+
+func TestSystemdLogSource_Resume(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "systemdlogsource")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
 
-	os.Exit(m.Run())
+	// Without a cursor, start at the end.
+	j := &fakeSystemdJournal{
+		getEntryValues: []sdjournal.JournalEntry{{Cursor: "acursor"}},
+		nextValues:     []uint64{1},
+	}
+	src, err := NewSystemdLogSource(j, "apath", "aunit", "aslice", systemdStartResume, dir)
+	if err != nil {
+		t.Fatalf("NewSystemdLogSource failed: %v", err)
+	}
+	assert.Equal(t, []uint64{1234567890000000}, j.seekRealtimeUsecCalls, "A call to SeekRealtimeUsec should be made.")
+
+	if _, err := src.Read(ctx); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if err := src.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Continue after the persisted cursor.
+	j = &fakeSystemdJournal{nextValues: []uint64{1}}
+	src, err = NewSystemdLogSource(j, "apath", "aunit", "aslice", systemdStartResume, dir)
+	if err != nil {
+		t.Fatalf("NewSystemdLogSource failed: %v", err)
+	}
+	defer src.Close()
+
+	assert.Equal(t, []string{"acursor"}, j.seekCursorCalls, "A call to SeekCursor should be made.")
+	assert.Empty(t, j.seekRealtimeUsecCalls, "No call to SeekRealtimeUsec should be made.")
+	assert.Empty(t, j.nextValues, "The entry at the cursor should be skipped.")
 }
 
 type fakeSystemdJournal struct {
@@ -116,6 +176,8 @@ type fakeSystemdJournal struct {
 
 	addMatchCalls         []string
 	closeCalls            int
+	seekCursorCalls       []string
+	seekHeadCalls         int
 	seekRealtimeUsecCalls []uint64
 	waitCalls             []time.Duration
 }
@@ -152,6 +214,18 @@ func (j *fakeSystemdJournal) Next() (uint64, error) {
 	return v, nil
 }
 
+func (j *fakeSystemdJournal) SeekCursor(cursor string) error {
+	j.seekCursorCalls = append(j.seekCursorCalls, cursor)
+
+	return nil
+}
+
+func (j *fakeSystemdJournal) SeekHead() error {
+	j.seekHeadCalls++
+
+	return nil
+}
+
 func (j *fakeSystemdJournal) SeekRealtimeUsec(usec uint64) error {
 	j.seekRealtimeUsecCalls = append(j.seekRealtimeUsecCalls, usec)
 
@@ -1,32 +1,114 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 
+	"github.com/digineo/postfix_exporter/logsource"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func main() {
 	var (
-		ctx                 = context.Background()
-		app                 = kingpin.New("postfix_exporter", "Prometheus metrics exporter for postfix")
-		listenAddress       = app.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9154").String()
-		metricsPath         = app.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-		instances           = app.Flag("postfix.instance", "Name of postfix instances.").Default("postfix").Strings()
-		logSourceName       = app.Flag("log.source", "Postfix log source").Default("file").Enum(logSourceFactories.Names()...)
-		logUnsupportedLines = app.Flag("log.unsupported", "Log all unsupported lines.").Bool()
+		ctx                  = context.Background()
+		app                  = kingpin.New("postfix_exporter", "Prometheus metrics exporter for postfix")
+		listenAddress        = app.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9154").String()
+		systemdSocket        = app.Flag("web.systemd-socket", "Use the socket named \""+systemdSocketWeb+"\" passed by systemd socket activation instead of --web.listen-address.").Bool()
+		metricsPath          = app.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		instances            = app.Flag("postfix.instance", "Name of postfix instances.").Default("postfix").Strings()
+		lookupSyslogName     = app.Flag("postfix.lookup-syslog-name", "Look up the syslog_name of the instances with postconf and postmulti, instead of assuming it equals the instance name.").Bool()
+		disableShowq         = app.Flag("postfix.disable-showq", "Do not collect metrics from the mail queue, e.g. when reading the logs of remote mail servers.").Bool()
+		showqPaths           = app.Flag("postfix.showq-path", "Path of the showq socket, as \"instance=path\" or a path for all other instances (option can be repeated). Defaults to /var/spool/<instance>/public/showq.").Strings()
+		showqTimeout         = app.Flag("showq.timeout", "Timeout of reading the mail queue from a showq socket or postqueue. Must be positive.").Default(defaultShowqTimeout.String()).Duration()
+		showqConcurrency     = app.Flag("showq.concurrency", "Number of mail queues of instances scraped at the same time.").Default(strconv.Itoa(defaultShowqConcurrency)).Int()
+		showqInterval        = app.Flag("showq.interval", "Refresh the mail queue metrics in the background at this interval, instead of at every scrape. Disabled if 0.").Default("0").Duration()
+		showqDeferredDomains = app.Flag("showq.deferred-domains", "Number of recipient domains with the most recipients in the deferred queue to export. Disabled if 0.").Default("0").Int()
+		showqQShape          = app.Flag("showq.qshape", "Export the number of messages in the active and deferred queue by age and sender or recipient domain, like qshape.").Enum("sender", "recipient")
+		showqQShapeDomains   = app.Flag("showq.qshape-domains", "Number of domains with the most messages per queue in the qshape matrix, see --showq.qshape.").Default("10").Int()
+		showqTopSenders      = app.Flag("showq.top-senders", "Number of envelope senders with the most messages in the queue to export. Disabled if 0.").Default("0").Int()
+		showqMailerDaemon    = app.Flag("showq.mailer-daemon", "Whether messages from MAILER-DAEMON (bounces) are included in the queue metrics, excluded, or counted separately.").Default("include").Enum("include", "exclude", "separate")
+		showqDomains         = app.Flag("showq.domain", "Only include messages with a sender or recipient in this domain (or its subdomains) in the queue metrics (option can be repeated).").Strings()
+		showqExcludeDomains  = app.Flag("showq.exclude-domain", "Leave messages with a sender or recipient in this domain (or its subdomains) out of the queue metrics (option can be repeated).").Strings()
+		showqMaxMessages     = app.Flag("showq.max-messages", "Number of messages read from the mail queue at most, further messages are ignored. Unlimited if 0.").Default("0").Int()
+		showqFSFallback      = app.Flag("showq.filesystem-fallback", "Count the files in the queue directories if reading from the showq socket fails.").Bool()
+		showqExec            = app.Flag("showq.exec", "List the mail queue by running postqueue -j (json) or postqueue -p (text) instead of reading the showq socket.").Enum("json", "text")
+		showqSudo            = app.Flag("showq.sudo", "Run postqueue with sudo -n, see --showq.exec.").Bool()
+		logSourceName        = app.Flag("log.source", "Postfix log source").Default("file").Enum(logsource.Names()...)
+		logFormat            = app.Flag("log.format", "Format of the log lines.").Default(logFormatPlain).Enum(logFormatPlain, logFormatJSON)
+		logUnsupportedLines  = app.Flag("log.unsupported", "Log all unsupported lines.").Bool()
+		kuminaMetrics        = app.Flag("compat.kumina-metrics", "Export the metrics known from kumina/postfix_exporter under its names and labels, summed up over the additional labels of this exporter.").Bool()
+		kuminaPath           = app.Flag("compat.kumina-path", "Path under which to expose the metrics of --compat.kumina-metrics, in addition to the unchanged metrics at --web.telemetry-path. If empty, they replace those.").String()
+		reloadTokenFile      = app.Flag("web.reload-token-file", "File with a bearer token required by POST requests to /-/reload, which reload the --config.file. Disabled if empty.").Default("").String()
+		probe                = app.Flag("web.probe", "Serve the mail queue metrics of remote showq services forwarded over TCP at /probe?target=host:port&instance=name.").Bool()
+		unsupportedSamples   = app.Flag("web.debug-unsupported", "Number of the most recent unsupported lines of each subprocess to show at /debug/unsupported. Disabled if 0.").Default("0").Int()
+		logDovecot           = app.Flag("log.dovecot", "Also collect metrics from Dovecot LMTP delivery and authentication failure log lines.").Bool()
+		smtpRelayLabel       = app.Flag("smtp.relay-label", "Label postfix_smtp_status_total and postfix_smtp_tls_handshake_errors_total by relay host.").Bool()
+		saslUsernameLabel    = app.Flag("smtpd.sasl-username-label", "Label smtpd processed message and SASL metrics by SASL user name.").Bool()
+		maxSASLUsers         = app.Flag("smtpd.max-sasl-users", "Maximum number of distinct SASL user names to use as label values. Further users are reported as \"other\".").Default(strconv.Itoa(defaultMaxSASLUsers)).Int()
+		saslTopUsers         = app.Flag("smtpd.sasl-top-users", "Number of SASL users with the most submitted messages and recipients to export. Disabled if 0.").Default("0").Int()
+		saslWindow           = app.Flag("smtpd.sasl-window", "Sliding window to count the messages and recipients of SASL users in.").Default("1h").Duration()
+		topClients           = app.Flag("smtpd.top-clients", "Number of clients with the most connections to export. Disabled if 0.").Default("0").Int()
+		topClientsHalfLife   = app.Flag("smtpd.top-clients-half-life", "Half-life of the decaying connection counts of the top clients.").Default("1h").Duration()
+		messageTTL           = app.Flag("message.tracking-ttl", "Correlate the log lines of messages by queue ID to export their time in queue, forgetting messages not seen for this long. Disabled if 0.").Default("0").Duration()
+		duplicateIDWindow    = app.Flag("message.duplicate-id-window", "Count Message-IDs seen by cleanup more than once within this window, e.g. because of mail loops. Disabled if 0.").Default("0").Duration()
+		senderDomainLabel    = app.Flag("delivery.sender-domain-label", "Label postfix_delivery_recipients_total by sender domain. Requires --message.tracking-ttl.").Bool()
+		recipientDomainLabel = app.Flag("delivery.recipient-domain-label", "Label postfix_delivery_recipients_total by recipient domain.").Bool()
+		domainAnonymization  = app.Flag("delivery.domain-anonymization", "Anonymization of the sender and recipient domain labels.").Default("none").Enum("none", "hash")
+		geoIPDatabase        = app.Flag("geoip.database", "Path to a MaxMind DB file (e.g. GeoLite2-Country.mmdb) to label smtpd connects and rejects by client country with. Disabled if empty.").Default("").String()
+		smtpDelayRelays      = app.Flag("smtp.delay-relay", "Relay host (or parent domain) to label postfix_smtp_delivery_delay_seconds by (option can be repeated). Other relays are reported as \"other\".").Strings()
+		smtpDomains          = app.Flag("smtp.domain", "Recipient domain to export per-domain delivery metrics for (option can be repeated).").Strings()
+		histogramFactor      = app.Flag("metrics.native-histogram-bucket-factor", "Growth factor of the buckets of native histograms of delays and the mail queue, exported in addition to the classic buckets, e.g. 1.1. Disabled if 0.").Default("0").Float64()
+		maxDomains           = app.Flag("metrics.max-domains", "Maximum number of distinct domains to use as label values, per metric. Further domains are reported as \"other\".").Default(strconv.Itoa(defaultMaxDomains)).Int()
+		configFile           = app.Flag("config.file", "Path to a YAML configuration file, e.g. with custom metrics. Flags given on the command line override it. Disabled if empty.").Default("").String()
+		once                 = app.Flag("once", "Read the log source until its end, print the metrics and exit.").Bool()
 	)
 
-	logSourceFactories.Init(app)
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logsource.Init(app)
+	args, cfg, err := configArgs(app, os.Args[1:])
+	if err != nil {
+		app.Fatalf("invalid --config.file: %s", err)
+	}
+	kingpin.MustParse(app.Parse(args))
+	if *senderDomainLabel && *messageTTL == 0 {
+		app.Fatalf("--delivery.sender-domain-label requires --message.tracking-ttl")
+	}
+	if *showqTimeout <= 0 {
+		app.Fatalf("--showq.timeout must be positive")
+	}
+	if *histogramFactor != 0 && *histogramFactor <= 1 {
+		app.Fatalf("--metrics.native-histogram-bucket-factor must be greater than 1")
+	}
+
+	if *once {
+		ctx = logsource.WithOnce(ctx)
+	}
 
-	logSrc, err := logSourceFactories.New(*logSourceName, ctx)
+	openLogSource := func(ctx context.Context) (logsource.LogSourceCloser, prometheus.Collector, error) {
+		src, err := logsource.New(ctx, *logSourceName)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Some log sources provide metrics about themselves.
+		collector, _ := src.(prometheus.Collector)
+		formatted, err := newFormatLogSource(src, *logFormat)
+		if err != nil {
+			src.Close()
+
+			return nil, nil, err
+		}
+
+		return formatted, collector, nil
+	}
+	logSrc, srcCollector, err := openLogSource(ctx)
 	if err != nil {
 		log.Fatalf("Error opening log source: %s", err)
 	}
@@ -36,9 +118,128 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create PostfixExporter: %s", err)
 	}
+	exporter.SetMaxDomains(*maxDomains)
+	if cfg != nil {
+		exporter.SetDelayBuckets(cfg.Buckets.Delay)
+	}
+	exporter.SetNativeHistogramBucketFactor(*histogramFactor)
+	resolveShowqPaths := func(ctx context.Context, instances, values []string) (map[string]string, error) {
+		paths, err := parseShowqPaths(values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --postfix.showq-path: %w", err)
+		}
+		if _, ok := paths[""]; !ok && !*once && !*disableShowq && *showqExec == "" {
+			var missing []string
+			for _, instance := range instances {
+				if _, ok := paths[instance]; !ok {
+					missing = append(missing, instance)
+				}
+			}
+			for instance, path := range lookupShowqPaths(ctx, missing) {
+				paths[instance] = path
+			}
+		}
+
+		return paths, nil
+	}
+	paths, err := resolveShowqPaths(ctx, *instances, *showqPaths)
+	if err != nil {
+		app.Fatalf("%s", err)
+	}
+	exporter.SetShowqPaths(paths)
+	exporter.SetShowqDisabled(*disableShowq)
+	exporter.SetShowqTimeout(*showqTimeout)
+	exporter.SetShowqExec(*showqExec, *showqSudo)
+	exporter.SetShowqInterval(*showqInterval)
+	exporter.SetShowqConcurrency(*showqConcurrency)
+	exporter.SetShowqFilesystemFallback(*showqFSFallback)
+	exporter.SetShowqDeferredDomains(*showqDeferredDomains)
+	exporter.SetShowqMaxMessages(*showqMaxMessages)
+	exporter.SetShowqTopSenders(*showqTopSenders)
+	exporter.SetShowqMailerDaemon(*showqMailerDaemon)
+	exporter.SetShowqDomains(*showqDomains, *showqExcludeDomains)
+	exporter.SetShowqQShape(*showqQShape, *showqQShapeDomains)
+	if *lookupSyslogName {
+		names, err := lookupSyslogNames(ctx, *instances)
+		if err != nil {
+			log.Fatalf("Error looking up syslog names: %s", err)
+		}
+		exporter.SetSyslogNames(names)
+	}
+	exporter.collectDovecot = *logDovecot
+	exporter.SetUnsupportedSamples(*unsupportedSamples)
+	exporter.SetSMTPRelayLabel(*smtpRelayLabel)
+	exporter.SetSMTPDomains(*smtpDomains)
+	exporter.SetSMTPDelayRelays(*smtpDelayRelays)
+	exporter.SetSASLUsernameLabel(*saslUsernameLabel, *maxSASLUsers)
+	exporter.SetSASLTopUsers(*saslTopUsers, *saslWindow)
+	exporter.SetTopClients(*topClients, *topClientsHalfLife)
+	exporter.SetMessageTracking(*messageTTL)
+	exporter.SetDuplicateMessageIDWindow(*duplicateIDWindow)
+	exporter.SetDeliveryDomainLabels(*senderDomainLabel, *recipientDomainLabel, *domainAnonymization == "hash")
+	if cfg != nil {
+		cm, err := newCustomMetrics(cfg.CustomMetrics)
+		if err == nil {
+			err = exporter.checkCustomMetrics(cm)
+		}
+		if err != nil {
+			log.Fatalf("Error in config file %s: %s", *configFile, err)
+		}
+		exporter.SetCustomMetrics(cm)
+	}
+	if *geoIPDatabase != "" {
+		db, err := openGeoIPDB(*geoIPDatabase)
+		if err != nil {
+			log.Fatalf("Error opening GeoIP database: %s", err)
+		}
+		exporter.SetGeoIP(db)
+	}
+
+	if *once {
+		// The mail queue is unrelated to past log lines.
+		exporter.SetShowqDisabled(true)
+		exporter.CollectOnce(ctx)
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(exporter)
+		if srcCollector != nil {
+			reg.MustRegister(srcCollector)
+		}
+		if err := writeMetrics(os.Stdout, reg); err != nil {
+			log.Fatalf("Failed to write metrics: %s", err)
+		}
+
+		return
+	}
+
 	prometheus.MustRegister(exporter)
+	if srcCollector != nil {
+		prometheus.MustRegister(srcCollector)
+	}
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	gatherer := prometheus.Gatherer(prometheus.DefaultGatherer)
+	var labels *instanceLabelsGatherer
+	if cfg != nil {
+		labels = newInstanceLabelsGatherer(prometheus.DefaultGatherer, cfg.instanceLabels())
+		gatherer = labels
+	}
+	switch {
+	case *kuminaMetrics && *kuminaPath == "":
+		kumina := newKuminaGatherer(gatherer, exporter.showqPath)
+		http.Handle(*metricsPath, promhttp.HandlerFor(kumina, promhttp.HandlerOpts{}))
+	case *kuminaMetrics:
+		kumina := newKuminaGatherer(gatherer, exporter.showqPath)
+		http.Handle(*kuminaPath, promhttp.HandlerFor(kumina, promhttp.HandlerOpts{}))
+		http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
+	default:
+		http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
+	}
+	if exporter.unsupportedSamples != nil {
+		http.Handle("/debug/unsupported", exporter.unsupportedSamples)
+	}
+	if *probe {
+		http.Handle("/probe", exporter.ProbeHandler())
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if _, err := fmt.Fprintf(w, indexHTML, *metricsPath); err != nil {
 			log.Printf("Error writing index page: %v", err)
@@ -47,14 +248,68 @@ func main() {
 	ctx, cancelFunc := context.WithCancel(ctx)
 	defer cancelFunc()
 
-	for _, instance := range exporter.instances {
-		go exporter.StartMetricCollection(ctx, instance)
+	r := &reloader{
+		ctx:               ctx,
+		e:                 exporter,
+		path:              *configFile,
+		cfg:               cfg,
+		reg:               prometheus.DefaultRegisterer,
+		labels:            labels,
+		instances:         *instances,
+		showqPaths:        *showqPaths,
+		resolveShowqPaths: resolveShowqPaths,
+		openLogSource:     openLogSource,
+		logSrc:            logSrc,
+		srcCollector:      srcCollector,
+	}
+	if *reloadTokenFile != "" {
+		token, err := os.ReadFile(*reloadTokenFile)
+		if err != nil {
+			log.Fatalf("Error reading reload token: %s", err)
+		}
+		if len(bytes.TrimSpace(token)) == 0 {
+			log.Fatalf("Empty reload token in %s", *reloadTokenFile)
+		}
+		http.Handle("/-/reload", r.Handler(string(bytes.TrimSpace(token))))
+	}
+	if *lookupSyslogName {
+		r.lookupSyslogNames = lookupSyslogNames
+	}
+	r.start()
+	go r.WatchSignals(ctx)
+	go exporter.RefreshShowq(ctx)
+
+	if *systemdSocket {
+		l, err := systemdListener(systemdSocketWeb)
+		if err != nil {
+			log.Fatalf("Failed to use systemd socket: %s", err)
+		}
+		log.Print("Listening on systemd socket ", l.Addr())
+		log.Fatal(http.Serve(l, nil))
 	}
 
 	log.Print("Listening on ", *listenAddress)
 	log.Fatal(http.ListenAndServe(*listenAddress, nil))
 }
 
+// writeMetrics writes the metrics gathered from `g` to `w`, using the
+// Prometheus text format.
+func writeMetrics(w io.Writer, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 const indexHTML = `<!doctype html>
 <html>
 <head>
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var postfixMasterUpDesc = prometheus.NewDesc(
+	prometheus.BuildFQName("postfix", "master", "up"),
+	"Whether the Postfix master process is running, according to the lock on its PID file.",
+	[]string{"name"}, nil)
+
+// errFileLocksUnsupported is returned by fileLocked on platforms
+// without flock(2), where postfix_master_up is not exported.
+var errFileLocksUnsupported = errors.New("checking file locks is not supported on this platform")
+
+// masterRunning returns whether the master process of the Postfix
+// instance with the queue directory `dir` is running, i.e. whether a
+// process holds the lock on its pid/master.pid file, as checked by
+// 'postfix status'. The file is kept when Postfix is stopped. Unlike
+// the PID in it, the lock can be checked from another PID namespace.
+func masterRunning(dir string) (bool, error) {
+	running, err := fileLocked(filepath.Join(dir, "pid", "master.pid"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return running, err
+}
+
+// collectMasterUp sends postfix_master_up of all instances. Instances
+// whose PID file cannot be checked are logged and left out. Nothing is
+// sent on platforms without file locks.
+func (e *PostfixExporter) collectMasterUp(ch chan<- prometheus.Metric) {
+	for _, instance := range e.instances {
+		// The showq socket is in the public subdirectory of the
+		// queue_directory.
+		dir := filepath.Dir(filepath.Dir(e.showqPath(instance)))
+		running, err := masterRunning(dir)
+		if errors.Is(err, errFileLocksUnsupported) {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to check the master process of %s: %s", instance, err)
+
+			continue
+		}
+		up := 0.0
+		if running {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(postfixMasterUpDesc, prometheus.GaugeValue, up, instance)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterRunning(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	running, err := masterRunning(dir)
+	require.NoError(t, err)
+	assert.False(t, running, "A missing PID file means Postfix was never started.")
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "pid"), 0o700))
+	path := filepath.Join(dir, "pid", "master.pid")
+	require.NoError(t, os.WriteFile(path, []byte("       1234\n"), 0o600))
+	running, err = masterRunning(dir)
+	require.NoError(t, err)
+	assert.False(t, running, "An unlocked PID file means Postfix is stopped.")
+
+	// Lock it like the master process.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB))
+	running, err = masterRunning(dir)
+	require.NoError(t, err)
+	assert.True(t, running)
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB), "The check should not keep a lock.")
+
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_UN))
+	running, err = masterRunning(dir)
+	require.NoError(t, err)
+	assert.False(t, running)
+}
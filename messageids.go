@@ -0,0 +1,157 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxMessageIDs bounds the number of Message-IDs remembered by a
+// messageIDTracker, roughly 100 bytes each.
+const maxMessageIDs = 100000
+
+// A messageIDKey identifies a Message-ID in a Postfix instance.
+type messageIDKey struct {
+	instance, messageID string
+}
+
+// A messageIDEntry is the time a Message-ID was last seen, and the
+// queue ID of the message it was seen in.
+type messageIDEntry struct {
+	key     messageIDKey
+	seen    time.Time
+	queueID string
+}
+
+// A pendingMessage is a message seen by cleanup, until qmgr removes it.
+type pendingMessage struct {
+	arrived    time.Time
+	duplicates []string // queue IDs of later messages with the same Message-ID
+}
+
+// A messageIDTracker detects Message-IDs seen by cleanup more than once
+// within a window, e.g. because of mail loops. It remembers the most
+// recently seen `maxEntries` Message-IDs.
+//
+// A message handed off to a post-queue content filter or forwarded by
+// local(8) passes cleanup again under a new queue ID, before the
+// delivery to the filter or the forwarding is logged. So a duplicate of
+// a message still in the queue is only counted once that message is
+// removed, unless it was handed off as the duplicate meanwhile, see
+// HandOff. Messages whose removal is not seen within the window are
+// forgotten, and their duplicates counted.
+type messageIDTracker struct {
+	window     time.Duration
+	maxEntries int
+
+	mu        sync.Mutex
+	entries   map[messageIDKey]*list.Element
+	lru       *list.List // of *messageIDEntry, most recently seen first
+	queued    map[messageKey]*pendingMessage
+	lastPrune time.Time
+}
+
+func newMessageIDTracker(window time.Duration, maxEntries int) *messageIDTracker {
+	return &messageIDTracker{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[messageIDKey]*list.Element),
+		lru:        list.New(),
+		queued:     make(map[messageKey]*pendingMessage),
+	}
+}
+
+// Seen records a Message-ID of the message `queueID` at `at`. It returns
+// the instances of the duplicates counted meanwhile: a Message-ID seen
+// in the same instance within the window, or the pending duplicates of
+// forgotten messages.
+func (t *messageIDTracker) Seen(instance, messageID, queueID string, at time.Time) (duplicates []string) {
+	key := messageIDKey{instance, messageID}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	duplicates = t.prune(at)
+	if el := t.entries[key]; el != nil {
+		entry := el.Value.(*messageIDEntry)
+		if at.Sub(entry.seen) <= t.window {
+			if q := t.queued[messageKey{instance, entry.queueID}]; q != nil {
+				q.duplicates = append(q.duplicates, queueID)
+			} else {
+				duplicates = append(duplicates, instance)
+			}
+		}
+		entry.seen, entry.queueID = at, queueID
+		t.lru.MoveToFront(el)
+	} else {
+		t.entries[key] = t.lru.PushFront(&messageIDEntry{key: key, seen: at, queueID: queueID})
+		for t.lru.Len() > t.maxEntries {
+			el := t.lru.Back()
+			delete(t.entries, el.Value.(*messageIDEntry).key)
+			t.lru.Remove(el)
+		}
+	}
+	if queueID != "" {
+		t.queued[messageKey{instance, queueID}] = &pendingMessage{arrived: at}
+	}
+
+	return duplicates
+}
+
+// HandOff records that the message `queueID` was queued again as
+// `nextQueueID`, e.g. by a content filter, so the latter is no
+// duplicate.
+func (t *messageIDTracker) HandOff(instance, queueID, nextQueueID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q := t.queued[messageKey{instance, queueID}]
+	if q == nil {
+		return
+	}
+	for i, id := range q.duplicates {
+		if id == nextQueueID {
+			q.duplicates = append(q.duplicates[:i], q.duplicates[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// Remove forgets a message, after qmgr removed it from the queue, and
+// returns the number of its duplicates.
+func (t *messageIDTracker) Remove(instance, queueID string) int {
+	key := messageKey{instance, queueID}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q := t.queued[key]
+	if q == nil {
+		return 0
+	}
+	delete(t.queued, key)
+
+	return len(q.duplicates)
+}
+
+// prune forgets the messages which arrived more than the window before
+// `at`, and returns the instances of their duplicates. Pruning requires
+// a full scan, so it is done at most every tenth of the window.
+func (t *messageIDTracker) prune(at time.Time) (duplicates []string) {
+	if at.Sub(t.lastPrune) < t.window/10 {
+		return nil
+	}
+	t.lastPrune = at
+
+	for k, q := range t.queued {
+		if at.Sub(q.arrived) > t.window {
+			delete(t.queued, k)
+			for range q.duplicates {
+				duplicates = append(duplicates, k.instance)
+			}
+		}
+	}
+
+	return duplicates
+}
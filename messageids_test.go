@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageIDTracker(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	tracker := newMessageIDTracker(time.Hour, 2)
+
+	assert.Empty(t, tracker.Seen("postfix", "1@example.com", "", start))
+	assert.Equal(t, []string{"postfix"}, tracker.Seen("postfix", "1@example.com", "", start.Add(time.Minute)))
+	assert.Empty(t, tracker.Seen("postfix-out", "1@example.com", "", start), "Instances should be tracked separately.")
+	assert.Empty(t, tracker.Seen("postfix", "1@example.com", "", start.Add(2*time.Hour)), "Message-IDs outside of the window are no duplicates.")
+
+	assert.Empty(t, tracker.Seen("postfix", "2@example.com", "", start.Add(2*time.Hour)))
+	assert.Len(t, tracker.entries, 2, "The least recently seen Message-ID should be evicted.")
+	assert.Empty(t, tracker.Seen("postfix-out", "1@example.com", "", start.Add(2*time.Hour)))
+}
+
+func TestMessageIDTracker_Queued(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	tracker := newMessageIDTracker(time.Hour, 10)
+
+	// Handed off to a content filter, which queued it again.
+	assert.Empty(t, tracker.Seen("postfix", "1@example.com", "A", start))
+	assert.Empty(t, tracker.Seen("postfix", "1@example.com", "B", start.Add(time.Second)))
+	tracker.HandOff("postfix", "A", "B")
+	assert.Equal(t, 0, tracker.Remove("postfix", "A"))
+	assert.Equal(t, 0, tracker.Remove("postfix", "B"))
+
+	// Submitted twice.
+	assert.Empty(t, tracker.Seen("postfix", "2@example.com", "C", start))
+	assert.Empty(t, tracker.Seen("postfix", "2@example.com", "D", start.Add(time.Second)), "The duplicate should wait for the removal of C.")
+	tracker.HandOff("postfix", "C", "E")
+	assert.Equal(t, 1, tracker.Remove("postfix", "C"))
+	assert.Equal(t, 0, tracker.Remove("postfix", "C"))
+
+	// Removal not seen.
+	assert.Empty(t, tracker.Seen("postfix", "3@example.com", "F", start))
+	assert.Empty(t, tracker.Seen("postfix", "3@example.com", "G", start.Add(time.Second)))
+	assert.Equal(t, []string{"postfix"}, tracker.Seen("postfix-out", "4@example.com", "H", start.Add(2*time.Hour)), "Duplicates of forgotten messages should be counted.")
+	assert.NotContains(t, tracker.queued, messageKey{"postfix", "F"})
+}
+
+func TestPostfixExporter_DuplicateMessageIDs(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetDuplicateMessageIDWindow(time.Hour)
+
+	for _, line := range []string{
+		"2023-06-01T10:00:00Z mail postfix/cleanup[100]: 3F1A52C0E1: message-id=<1@example.com>",
+		"2023-06-01T10:00:01Z mail postfix/qmgr[101]: 3F1A52C0E1: removed",
+		"2023-06-01T10:00:05Z mail postfix/cleanup[100]: 4A2B63D1F2: message-id=<1@example.com>",
+		"2023-06-01T10:00:06Z mail postfix/cleanup[100]: 5B3C74E2A3: message-id=<2@example.com>",
+
+		// Re-injected by a content filter.
+		"2023-06-01T10:01:00Z mail postfix/cleanup[100]: 6C4D85F3B4: message-id=<3@example.com>",
+		"2023-06-01T10:01:02Z mail postfix/cleanup[100]: 7D5E96A4C5: message-id=<3@example.com>",
+		"2023-06-01T10:01:02Z mail postfix/smtp-amavis/smtp[102]: 6C4D85F3B4: to=<b@example.org>, relay=127.0.0.1[127.0.0.1]:10024, delay=2, delays=0/0/0.5/1.5, dsn=2.0.0, status=sent (250 2.0.0 from MTA(smtp:[127.0.0.1]:10025): 250 2.0.0 Ok: queued as 7D5E96A4C5)",
+		"2023-06-01T10:01:02Z mail postfix/qmgr[101]: 6C4D85F3B4: removed",
+
+		// Forwarded by an alias.
+		"2023-06-01T10:02:00Z mail postfix/cleanup[100]: 8E6FA7B5D6: message-id=<4@example.com>",
+		"2023-06-01T10:02:01Z mail postfix/cleanup[100]: 9F7AB8C6E7: message-id=<4@example.com>",
+		"2023-06-01T10:02:01Z mail postfix/local[103]: 8E6FA7B5D6: to=<c@example.com>, orig_to=<list@example.com>, relay=local, delay=1, delays=0/0/0/1, dsn=2.0.0, status=sent (forwarded as 9F7AB8C6E7)",
+		"2023-06-01T10:02:01Z mail postfix/qmgr[101]: 8E6FA7B5D6: removed",
+	} {
+		ex.CollectFromLogLine("postfix", line)
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.cleanupDuplicateMessageIDs.WithLabelValues("postfix")))
+
+	// Submitted again while queued.
+	ex.CollectFromLogLine("postfix", "2023-06-01T10:03:00Z mail postfix/cleanup[100]: AB8BC9D7F8: message-id=<2@example.com>")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.cleanupDuplicateMessageIDs.WithLabelValues("postfix")), "The duplicate should wait for the removal of the first message.")
+	ex.CollectFromLogLine("postfix", "2023-06-01T10:03:01Z mail postfix/qmgr[101]: 5B3C74E2A3: removed")
+	assert.Equal(t, 2.0, testutil.ToFloat64(ex.cleanupDuplicateMessageIDs.WithLabelValues("postfix")))
+}
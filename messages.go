@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// A messageKey identifies a message in the queue of a Postfix instance.
+type messageKey struct {
+	instance, queueID string
+}
+
+// A trackedMessage holds the times a message arrived and was activated.
+type trackedMessage struct {
+	arrived, activated time.Time
+	senderDomain       string
+	seen               time.Time // of the last log line, for eviction
+}
+
+// A messageTracker correlates the log lines of a message by queue ID,
+// from its arrival (cleanup) to its activation (qmgr) and the final
+// delivery status of its recipients. Messages are forgotten once qmgr
+// removes them, or after not being seen for `ttl`.
+type messageTracker struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	messages  map[messageKey]*trackedMessage
+	lastEvict time.Time
+}
+
+func newMessageTracker(ttl time.Duration) *messageTracker {
+	return &messageTracker{
+		ttl:      ttl,
+		messages: make(map[messageKey]*trackedMessage),
+	}
+}
+
+// Arrive records the arrival of a message at `t`. It returns the
+// instances of messages which got evicted because of their age.
+func (t *messageTracker) Arrive(key messageKey, at time.Time) (evicted []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Evicting requires a full scan, so it is done at most every tenth
+	// of the TTL.
+	if at.Sub(t.lastEvict) >= t.ttl/10 {
+		for k, msg := range t.messages {
+			if at.Sub(msg.seen) > t.ttl {
+				delete(t.messages, k)
+				evicted = append(evicted, k.instance)
+			}
+		}
+		t.lastEvict = at
+	}
+	t.messages[key] = &trackedMessage{arrived: at, seen: at}
+
+	return evicted
+}
+
+// Activate records the activation of a message by qmgr at `t`, along
+// with its sender domain, and returns the time since its arrival. ok is
+// false if the message's arrival was not seen, or it was activated
+// before.
+func (t *messageTracker) Activate(key messageKey, at time.Time, senderDomain string) (d time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msg := t.messages[key]
+	if msg == nil || !msg.activated.IsZero() {
+		return 0, false
+	}
+	msg.activated, msg.seen = at, at
+	msg.senderDomain = senderDomain
+
+	return at.Sub(msg.arrived), true
+}
+
+// Deliver records a final delivery status of a recipient of a message
+// at `t`, and returns the time since the message's arrival. ok is false
+// if the message's arrival was not seen.
+func (t *messageTracker) Deliver(key messageKey, at time.Time) (d time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msg := t.messages[key]
+	if msg == nil {
+		return 0, false
+	}
+	msg.seen = at
+
+	return at.Sub(msg.arrived), true
+}
+
+// Arrived reports whether the arrival of a message was seen.
+func (t *messageTracker) Arrived(key messageKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.messages[key] != nil
+}
+
+// SenderDomain returns the sender domain of a message, or the empty
+// string if unknown.
+func (t *messageTracker) SenderDomain(key messageKey) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if msg := t.messages[key]; msg != nil {
+		return msg.senderDomain
+	}
+
+	return ""
+}
+
+// Remove forgets a message, after qmgr removed it from the queue.
+func (t *messageTracker) Remove(key messageKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.messages, key)
+}
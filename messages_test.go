@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostfixExporter_MessageTracking(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetMessageTracking(time.Hour)
+
+	for _, line := range []string{
+		"2023-06-01T10:00:00Z mail postfix/cleanup[100]: 3F1A52C0E1: message-id=<1@example.com>",
+		"2023-06-01T10:00:01Z mail postfix/qmgr[101]: 3F1A52C0E1: from=<a@example.com>, size=1234, nrcpt=2 (queue active)",
+		"2023-06-01T10:00:03Z mail postfix/smtp[102]: 3F1A52C0E1: to=<b@example.org>, relay=mx.example.org[192.0.2.1]:25, delay=3, delays=1/0/1/1, dsn=2.0.0, status=sent (250 2.0.0 OK)",
+		"2023-06-01T10:00:04Z mail postfix/smtp[102]: 3F1A52C0E1: to=<c@example.net>, relay=mx.example.net[192.0.2.2]:25, delay=4, delays=1/0/2/1, dsn=4.4.1, status=deferred (connect to mx.example.net[192.0.2.2]:25: Connection timed out)",
+		"2023-06-01T10:30:00Z mail postfix/smtp[102]: 3F1A52C0E1: to=<c@example.net>, relay=mx.example.net[192.0.2.2]:25, delay=1800, delays=1799/0/0.5/0.5, dsn=5.1.1, status=bounced (host mx.example.net[192.0.2.2] said: 550 5.1.1 No such user)",
+		"2023-06-01T10:30:00Z mail postfix/qmgr[101]: 3F1A52C0E1: removed",
+		// Not tracked, as its arrival was not logged.
+		"2023-06-01T10:30:00Z mail postfix/local[103]: 4A2B63D1F2: to=<d@example.com>, relay=local, delay=0.1, delays=0/0/0/0.1, dsn=2.0.0, status=sent (delivered to mailbox)",
+	} {
+		ex.CollectFromLogLine("postfix", line)
+	}
+
+	expected := `
+# HELP postfix_message_time_in_queue_seconds Time from the arrival of messages to the final delivery status of their recipients in seconds, by status.
+# TYPE postfix_message_time_in_queue_seconds histogram
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="0.001"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="0.01"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="0.1"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="1"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="10"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="60"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="3600"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="86400"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="172800"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="bounced",le="+Inf"} 1
+postfix_message_time_in_queue_seconds_sum{name="postfix",status="bounced"} 1800
+postfix_message_time_in_queue_seconds_count{name="postfix",status="bounced"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="0.001"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="0.01"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="0.1"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="1"} 0
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="10"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="60"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="3600"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="86400"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="172800"} 1
+postfix_message_time_in_queue_seconds_bucket{name="postfix",status="sent",le="+Inf"} 1
+postfix_message_time_in_queue_seconds_sum{name="postfix",status="sent"} 3
+postfix_message_time_in_queue_seconds_count{name="postfix",status="sent"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(ex.messageTimeInQueue, strings.NewReader(expected)))
+	assert.Equal(t, 1, testutil.CollectAndCount(ex.messageTimeToActivation))
+	assert.Empty(t, ex.messages.messages, "Removed messages should be forgotten.")
+}
+
+func TestPostfixExporter_Reinjection(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetMessageTracking(time.Hour)
+
+	for _, line := range []string{
+		"2023-06-01T10:00:00Z mail postfix/cleanup[100]: 3F1A52C0E1: message-id=<1@example.com>",
+		"2023-06-01T10:00:01Z mail postfix/qmgr[101]: 3F1A52C0E1: from=<a@example.com>, size=1234, nrcpt=1 (queue active)",
+		"2023-06-01T10:00:03Z mail postfix/cleanup[100]: 5C2B63D1F3: message-id=<1@example.com>",
+		"2023-06-01T10:00:03Z mail postfix/smtp-amavis/smtp[102]: 3F1A52C0E1: to=<b@example.org>, relay=127.0.0.1[127.0.0.1]:10024, delay=3, delays=1/0/0.5/1.5, dsn=2.0.0, status=sent (250 2.0.0 from MTA(smtp:[127.0.0.1]:10025): 250 2.0.0 Ok: queued as 5C2B63D1F3)",
+		// Queue IDs of other servers are not re-injections.
+		"2023-06-01T10:00:05Z mail postfix/smtp[103]: 5C2B63D1F3: to=<b@example.org>, relay=mx.example.org[192.0.2.1]:25, delay=2, delays=0/0/1/1, dsn=2.0.0, status=sent (250 2.0.0 Ok: queued as 6D3C74E2A4)",
+	} {
+		ex.CollectFromLogLine("postfix", line)
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.filterReinjections.WithLabelValues("postfix", "smtp-amavis")))
+	assert.Equal(t, 1, testutil.CollectAndCount(ex.filterReinjections))
+	assert.Equal(t, 1, testutil.CollectAndCount(ex.filterProcessingTime))
+}
+
+func TestMessageTracker_Evict(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	tr := newMessageTracker(time.Hour)
+
+	assert.Empty(t, tr.Arrive(messageKey{"postfix", "A"}, start))
+	assert.Empty(t, tr.Arrive(messageKey{"postfix", "B"}, start.Add(30*time.Minute)))
+	_, ok := tr.Activate(messageKey{"postfix", "B"}, start.Add(40*time.Minute), "example.com")
+	assert.True(t, ok)
+	_, ok = tr.Activate(messageKey{"postfix", "B"}, start.Add(41*time.Minute), "")
+	assert.False(t, ok, "Messages should only be activated once.")
+
+	assert.Equal(t, []string{"postfix"}, tr.Arrive(messageKey{"postfix-out", "C"}, start.Add(90*time.Minute)))
+	assert.NotContains(t, tr.messages, messageKey{"postfix", "A"})
+	assert.Contains(t, tr.messages, messageKey{"postfix", "B"})
+	assert.Equal(t, "example.com", tr.SenderDomain(messageKey{"postfix", "B"}))
+
+	d, ok := tr.Deliver(messageKey{"postfix", "B"}, start.Add(2*time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Minute, d)
+}
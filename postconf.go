@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// defaultInstance is the name of the default Postfix instance, which is
+// configured with postconf directly instead of postmulti.
+const defaultInstance = "postfix"
+
+// runCommand runs a command and returns its standard output. It is a
+// test fake injection point.
+var runCommand = func(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return "", fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(ee.Stderr)))
+		}
+
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+
+	return string(out), nil
+}
+
+// streamCommand starts a command and returns its standard output, to be
+// read while the command runs. Closing it waits for the command to exit.
+// It is a test fake injection point.
+var streamCommand = func(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return &commandOutput{ReadCloser: stdout, name: name, cmd: cmd, stderr: &stderr}, nil
+}
+
+// commandOutput is the standard output of a running command.
+type commandOutput struct {
+	io.ReadCloser
+	name   string
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+// Close discards the remaining output, so the command does not fail
+// writing it, and waits for the command to exit.
+func (o *commandOutput) Close() error {
+	_, _ = io.Copy(io.Discard, o.ReadCloser)
+	if err := o.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(o.stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", o.name, err, msg)
+		}
+
+		return fmt.Errorf("%s: %w", o.name, err)
+	}
+
+	return nil
+}
+
+// postconf returns the value of the main.cf parameter `param` of a
+// Postfix instance, with variables expanded. Instances other than the
+// default one are looked up with postmulti.
+func postconf(ctx context.Context, instance, param string) (string, error) {
+	args := []string{"-h", "-x", param}
+	name := "postconf"
+	if instance != defaultInstance {
+		args = append([]string{"-i", instance, "-x", name}, args...)
+		name = "postmulti"
+	}
+
+	out, err := runCommand(ctx, name, args...)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// lookupSyslogNames returns the syslog_name of the given instances, as
+// used in their log lines. Services may append a suffix to it (e.g.
+// "postfix/submission"), which is stripped.
+func lookupSyslogNames(ctx context.Context, instances []string) (map[string]string, error) {
+	names := make(map[string]string, len(instances))
+	for _, instance := range instances {
+		name, err := postconf(ctx, instance, "syslog_name")
+		if err != nil {
+			return nil, fmt.Errorf("looking up syslog_name of %s: %w", instance, err)
+		}
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name = name[:i]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty syslog_name of %s", instance)
+		}
+		names[instance] = name
+	}
+
+	return names, nil
+}
+
+// lookupShowqPaths returns the path of the showq socket in the
+// queue_directory of the given instances. Instances whose
+// queue_directory cannot be looked up (e.g. because Postfix is not
+// installed where the exporter runs) are logged and left out.
+func lookupShowqPaths(ctx context.Context, instances []string) map[string]string {
+	paths := make(map[string]string, len(instances))
+	for _, instance := range instances {
+		dir, err := postconf(ctx, instance, "queue_directory")
+		if err != nil || dir == "" {
+			log.Printf("Could not look up queue_directory of %s, using the default showq path: %v", instance, err)
+
+			continue
+		}
+		paths[instance] = filepath.Join(dir, "public/showq")
+	}
+
+	return paths
+}
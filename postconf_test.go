@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePostconf replaces runCommand and streamCommand with a lookup of
+// `outputs` by command line, until the test ends.
+func fakePostconf(t *testing.T, outputs map[string]string) {
+	t.Helper()
+
+	origRun, origStream := runCommand, streamCommand
+	runCommand = func(_ context.Context, name string, args ...string) (string, error) {
+		cmd := strings.Join(append([]string{name}, args...), " ")
+		if out, ok := outputs[cmd]; ok {
+			return out, nil
+		}
+
+		return "", errors.New("unexpected command: " + cmd)
+	}
+	streamCommand = func(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+		out, err := runCommand(ctx, name, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NopCloser(strings.NewReader(out)), nil
+	}
+	t.Cleanup(func() { runCommand, streamCommand = origRun, origStream })
+}
+
+func TestLookupSyslogNames(t *testing.T) {
+	fakePostconf(t, map[string]string{
+		"postconf -h -x syslog_name":                             "postfix\n",
+		"postmulti -i postfix-out -x postconf -h -x syslog_name": "mta-out\n",
+		"postmulti -i postfix-sub -x postconf -h -x syslog_name": "postfix-sub/submission\n",
+	})
+
+	names, err := lookupSyslogNames(context.Background(), []string{"postfix", "postfix-out", "postfix-sub"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"postfix": "postfix", "postfix-out": "mta-out", "postfix-sub": "postfix-sub"}, names)
+
+	_, err = lookupSyslogNames(context.Background(), []string{"postfix-missing"})
+	assert.Error(t, err)
+}
+
+func TestLookupShowqPaths(t *testing.T) {
+	fakePostconf(t, map[string]string{
+		"postconf -h -x queue_directory":                             "/var/spool/postfix\n",
+		"postmulti -i postfix-out -x postconf -h -x queue_directory": "/srv/queue/out\n",
+	})
+
+	paths := lookupShowqPaths(context.Background(), []string{"postfix", "postfix-out", "postfix-missing"})
+	assert.Equal(t, map[string]string{
+		"postfix":     "/var/spool/postfix/public/showq",
+		"postfix-out": "/srv/queue/out/public/showq",
+	}, paths)
+}
+
+func TestPostfixExporter_SyslogNames(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix-out"}, nil, false)
+	require.NoError(t, err)
+	ex.SetSyslogNames(map[string]string{"postfix-out": "mta-out"})
+
+	ex.CollectFromLogLine("postfix-out", "Feb 11 16:49:24 letterman mta-out/qmgr[8204]: AAB4D259B1: removed")
+	ex.CollectFromLogLine("postfix-out", "Feb 11 16:49:24 letterman postfix-out/qmgr[8204]: AAB4D259B2: removed")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.qmgrRemoves.WithLabelValues("postfix-out")))
+}
+
+func TestStreamCommand(t *testing.T) {
+	t.Parallel()
+
+	out, err := streamCommand(context.Background(), "sh", "-c", "echo first; yes | head -n 100000")
+	require.NoError(t, err)
+	line := make([]byte, 6)
+	_, err = io.ReadFull(out, line)
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", string(line))
+	assert.NoError(t, out.Close(), "The remaining output should be discarded.")
+
+	out, err = streamCommand(context.Background(), "sh", "-c", "echo oops >&2; exit 1")
+	require.NoError(t, err)
+	err = out.Close()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "oops")
+}
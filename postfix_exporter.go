@@ -15,13 +15,27 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"log"
+	"net"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/digineo/postfix_exporter/lineparser"
+	"github.com/digineo/postfix_exporter/logsource"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// timeNow is a test fake injection point.
+var timeNow = time.Now
+
 var postfixUpDesc = prometheus.NewDesc(
 	prometheus.BuildFQName("postfix", "", "up"),
 	"Whether scraping Postfix's metrics was successful.",
@@ -30,65 +44,981 @@ var postfixUpDesc = prometheus.NewDesc(
 // PostfixExporter holds the state that should be preserved by the
 // Postfix Prometheus metrics exporter across scrapes.
 type PostfixExporter struct {
-	instances           []string
-	skipShowq           bool // set in tests
-	logSrc              LogSource
-	logUnsupportedLines bool
+	instances            []string
+	disableShowq         bool // see SetShowqDisabled
+	logSrc               logsource.LogSource
+	lineParsers          map[string][]lineparser.LineParser // pipelines by subprocess
+	logUnsupportedLines  bool
+	collectDovecot       bool
+	smtpRelayLabel       bool
+	saslUsernameLabel    bool
+	senderDomainLabel    bool
+	recipientDomainLabel bool
+	hashDomainLabels     bool
+	timeBuckets          []float64 // of the delay histograms
+	histogramFactor      float64   // native histogram bucket factor, 0 if disabled
+
+	// Limits for metrics labeled by domain.
+	opendkimDomains *labelLimiter
+
+	smtpTLSDestinations     *labelLimiter
+	senderDomains           *labelLimiter
+	recipientDomains        *labelLimiter
+	saslUsers               *labelLimiter
+	saslTopUsers            *saslUserTracker    // nil if disabled
+	geoIP                   *geoIPDB            // nil if disabled
+	topClients              *topClientTracker   // nil if disabled
+	messages                *messageTracker     // nil if disabled
+	messageIDs              *messageIDTracker   // nil if disabled
+	customMetrics           *customMetrics      // nil if disabled
+	unsupportedSamples      *unsupportedSamples // nil if disabled
+	syslogNames             map[string]string   // by instance, if not equal
+	smtpDomains             []string            // allowlist of recipient domains
+	smtpDelayRelays         []string            // allowlist of relays to label the smtp delays by
+	showqPaths              map[string]string   // by instance, "" for all others
+	showqTimeout            time.Duration
+	showqExecFormat         string // "json" or "text" to run postqueue instead of reading showq, see SetShowqExec
+	showqExecSudo           bool
+	showqInterval           time.Duration // of refreshing showqCache, see SetShowqInterval
+	showqCache              *showqCache
+	showqOptions            ShowqOptions
+	showqConcurrency        int // number of mail queues scraped at the same time
+	showqFilesystemFallback bool
+
+	// reloadMu is held by Reload, which replaces instances, showqPaths,
+	// customMetrics and logSrc.
+	reloadMu sync.RWMutex
+
+	logUpMu sync.Mutex
+	logUp   map[string]bool // whether the log lines of an instance are read without errors
+
+	showqScrapeDuration  *prometheus.GaugeVec
+	showqScrapeErrors    *prometheus.CounterVec
+	showqMessagesScanned *prometheus.CounterVec
+	showqParseErrors     *prometheus.CounterVec
+
+	versionsMu sync.Mutex
+	versions   map[string]string // of postfix_version_info, by instance
 
 	// Metrics that should persist after refreshes, based on logs.
+	amavisVerdicts                  *prometheus.CounterVec
+	amavisScanDuration              prometheus.Histogram
+	bounceNonDeliveryNotifications  *prometheus.CounterVec
+	bounceDelayNotifications        *prometheus.CounterVec
 	cleanupProcesses                *prometheus.CounterVec
+	cleanupDuplicateMessageIDs      *prometheus.CounterVec
 	cleanupRejects                  *prometheus.CounterVec
+	accessActions                   *prometheus.CounterVec
+	milterErrors                    *prometheus.CounterVec
 	cleanupNotAccepted              *prometheus.CounterVec
+	deliveryDSNs                    *prometheus.CounterVec
+	deliveryRecipients              *prometheus.CounterVec
+	deliveryRemoteStatus            *prometheus.CounterVec
+	deliveryBounces                 *prometheus.CounterVec
+	mimeEvents                      *prometheus.CounterVec
+	dovecotLMTPDeliveries           *prometheus.CounterVec
+	dovecotAuthFailures             *prometheus.CounterVec
 	lmtpDelays                      *prometheus.HistogramVec
+	opendkimResults                 *prometheus.CounterVec
 	pipeDelays                      *prometheus.HistogramVec
+	postgreyResults                 *prometheus.CounterVec
+	postscreenConnects              *prometheus.CounterVec
+	postscreenVerdicts              *prometheus.CounterVec
 	qmgrInsertsNrcpt                *prometheus.HistogramVec
 	qmgrInsertsSize                 *prometheus.HistogramVec
 	qmgrRemoves                     *prometheus.CounterVec
+	qmgrExpired                     *prometheus.CounterVec
+	messageTimeInQueue              *prometheus.HistogramVec
+	messageTimeToActivation         *prometheus.HistogramVec
+	messageTrackingEvictions        *prometheus.CounterVec
+	filterReinjections              *prometheus.CounterVec
+	filterProcessingTime            *prometheus.HistogramVec
+	rspamdActions                   *prometheus.CounterVec
+	rspamdScores                    prometheus.Histogram
+	scacheLookups                   *prometheus.GaugeVec
+	scacheLookupHitRatio            *prometheus.GaugeVec
+	scacheMaxSimultaneous           *prometheus.GaugeVec
 	smtpDelays                      *prometheus.HistogramVec
+	smtpDelayTotal                  *prometheus.HistogramVec
+	smtpDomainStatus                *prometheus.CounterVec
+	smtpDomainDelays                *prometheus.HistogramVec
+	smtpDeferred                    *prometheus.CounterVec
+	smtpDNSErrors                   *prometheus.CounterVec
 	smtpTLSConnects                 *prometheus.CounterVec
+	smtpTLSVerifications            *prometheus.CounterVec
+	smtpTLSPolicyFailures           *prometheus.CounterVec
+	smtpTLSHandshakeErrors          *prometheus.CounterVec
 	smtpConnectionTimedOut          *prometheus.CounterVec
+	smtpConnectErrors               *prometheus.CounterVec
+	smtpLostConnections             *prometheus.CounterVec
 	smtpdConnects                   *prometheus.CounterVec
 	smtpdDisconnects                *prometheus.CounterVec
 	smtpdFCrDNSErrors               *prometheus.CounterVec
 	smtpdLostConnections            *prometheus.CounterVec
+	smtpdTimeouts                   *prometheus.CounterVec
 	smtpdProcesses                  *prometheus.CounterVec
 	smtpdRejects                    *prometheus.CounterVec
+	smtpdProxied                    *prometheus.CounterVec
+	smtpdRejectRestrictions         *prometheus.CounterVec
+	smtpdRateLimits                 *prometheus.CounterVec
+	smtpdGreylisted                 *prometheus.CounterVec
+	smtpdRejectWarnings             *prometheus.CounterVec
+	smtpdProxyRejects               *prometheus.CounterVec
 	smtpdSASLConnects               *prometheus.CounterVec
 	smtpdSASLAuthenticationFailures *prometheus.CounterVec
 	smtpdTLSConnects                *prometheus.CounterVec
+	smtpdTLSHandshakeErrors         *prometheus.CounterVec
+	tlsproxyConnects                *prometheus.CounterVec
+	tlsproxyTLSConnects             *prometheus.CounterVec
+	fatalErrors                     *prometheus.CounterVec
+	panics                          *prometheus.CounterVec
+	warnings                        *prometheus.CounterVec
+	masterProcessLimits             *prometheus.CounterVec
+	masterProcessExits              *prometheus.CounterVec
+	lifecycleEvents                 *prometheus.CounterVec
+	starts                          *prometheus.CounterVec
+	lastReload                      *prometheus.GaugeVec
+	versionInfo                     *prometheus.GaugeVec
 	unsupportedLogEntries           *prometheus.CounterVec
 	smtpStatus                      *prometheus.CounterVec
+	lastLogEventTime                *prometheus.GaugeVec
+
+	// Metrics about the log source itself.
+	logSourceLines        *prometheus.CounterVec
+	logSourceReadErrors   *prometheus.CounterVec
+	logSourceLastReadTime *prometheus.GaugeVec
 }
 
-// A LogSource is an interface to read log lines.
-type LogSource interface {
-	// Path returns a representation of the log location.
-	Path() string
+// SetMaxDomains limits the number of distinct domains used as label
+// values, per metric. Further domains are reported as "other".
+func (e *PostfixExporter) SetMaxDomains(max int) {
+	e.opendkimDomains.SetMax(max)
+	e.smtpTLSDestinations.SetMax(max)
+	e.senderDomains.SetMax(max)
+	e.recipientDomains.SetMax(max)
+}
 
-	// Read returns the next log line. Returns `io.EOF` at the end of
-	// the log.
-	Read(context.Context) (string, error)
+// SetSMTPRelayLabel controls whether postfix_smtp_status_total is
+// labeled by relay host. It must be called before the exporter is
+// registered.
+func (e *PostfixExporter) SetSMTPRelayLabel(enabled bool) {
+	e.smtpRelayLabel = enabled
+	e.smtpStatus = newSMTPStatusVec(enabled)
+	e.smtpTLSHandshakeErrors = newSMTPTLSHandshakeErrorsVec(enabled)
 }
 
-// CollectFromLogline collects metrict from a Postfix log line.
-func (e *PostfixExporter) CollectFromLogLine(instance, line string) { //nolint:gocognit
-	r := parseLogLine(instance, line)
+// SetSMTPDelayRelays labels postfix_smtp_delivery_delay_seconds by
+// relay host, if `relays` is not empty. Relays which are neither equal
+// to nor a subdomain of an entry are reported as "other". It must be
+// called before the exporter is registered.
+func (e *PostfixExporter) SetSMTPDelayRelays(relays []string) {
+	if len(relays) == 0 {
+		return
+	}
+	e.smtpDelayRelays = make([]string, 0, len(relays))
+	for _, r := range relays {
+		e.smtpDelayRelays = append(e.smtpDelayRelays, strings.ToLower(strings.TrimPrefix(r, ".")))
+	}
+	e.smtpDelays = newSMTPDelaysVec(true, e.timeBuckets, e.histogramFactor)
+}
+
+// SetDelayBuckets sets the buckets of the delay histograms, in seconds,
+// if `buckets` is not empty. It must be called before the exporter is
+// registered.
+func (e *PostfixExporter) SetDelayBuckets(buckets []float64) {
+	if len(buckets) == 0 {
+		return
+	}
+	e.timeBuckets = buckets
+	e.newDelayHistograms()
+}
+
+// SetNativeHistogramBucketFactor enables native histograms of delays
+// and of the mail queue with this bucket factor, in addition to the
+// classic buckets. It must be called before the exporter is registered.
+func (e *PostfixExporter) SetNativeHistogramBucketFactor(factor float64) {
+	e.histogramFactor = factor
+	e.newDelayHistograms()
+}
+
+// SetSASLUsernameLabel controls whether the smtpd message and SASL
+// metrics are labeled by SASL user name, with at most `maxUsers`
+// distinct users. It must be called before the exporter is registered.
+func (e *PostfixExporter) SetSASLUsernameLabel(enabled bool, maxUsers int) {
+	e.saslUsernameLabel = enabled
+	e.saslUsers = newLabelLimiter(maxUsers)
+	e.smtpdProcesses, e.smtpdSASLConnects, e.smtpdSASLAuthenticationFailures = newSMTPDSASLVecs(enabled)
+}
+
+// SetSASLTopUsers enables exporting the `n` SASL users which submitted
+// the most messages and recipients within `window`. It must be called
+// before the exporter is registered.
+func (e *PostfixExporter) SetSASLTopUsers(n int, window time.Duration) {
+	if n <= 0 {
+		e.saslTopUsers = nil
+
+		return
+	}
+	e.saslTopUsers = newSASLUserTracker(n, window)
+}
+
+// SetTopClients enables exporting the `n` clients with the most smtpd
+// connections, counted with exponential decay with the given half-life.
+// It must be called before the exporter is registered.
+func (e *PostfixExporter) SetTopClients(n int, halfLife time.Duration) {
+	if n <= 0 {
+		e.topClients = nil
+
+		return
+	}
+	e.topClients = newTopClientTracker(n, halfLife)
+}
+
+// SetMessageTracking enables correlating the log lines of messages by
+// queue ID to export their time in queue. Messages not seen for `ttl`
+// are forgotten. It must be called before the exporter is registered.
+func (e *PostfixExporter) SetMessageTracking(ttl time.Duration) {
+	if ttl <= 0 {
+		e.messages = nil
+
+		return
+	}
+	e.messages = newMessageTracker(ttl)
+}
+
+// SetDuplicateMessageIDWindow enables counting Message-IDs seen by
+// cleanup more than once within `window`. It must be called before the
+// exporter is registered.
+func (e *PostfixExporter) SetDuplicateMessageIDWindow(window time.Duration) {
+	if window <= 0 {
+		e.messageIDs = nil
+
+		return
+	}
+	e.messageIDs = newMessageIDTracker(window, maxMessageIDs)
+}
+
+// trackDelivery observes the time in queue of a message once the final
+// status of a recipient is logged.
+func (e *PostfixExporter) trackDelivery(r *loglineResult, instance, status string) {
+	if e.messages == nil || status == "" || status == "deferred" {
+		return
+	}
+	if d, ok := e.messages.Deliver(messageKey{instance, r.queueID}, logTime(r)); ok {
+		e.messageTimeInQueue.WithLabelValues(instance, status).Observe(d.Seconds())
+	}
+}
+
+// trackReinjection detects deliveries to content filters which queued
+// the message again in the same instance, i.e. the next hop replied with
+// the queue ID of a message whose arrival was seen. The time spent
+// connecting to and transmitting to the filter includes its processing.
+func (e *PostfixExporter) trackReinjection(r *loglineResult, instance string) {
+	if e.messages == nil || r.queuedAs == "" || !e.messages.Arrived(messageKey{instance, r.queuedAs}) {
+		return
+	}
+	e.filterReinjections.WithLabelValues(instance, r.service).Inc()
+
+	d := r.smtp.delays
+	if d == nil {
+		d = r.lmtp.delays
+	}
+	if d != nil {
+		e.filterProcessingTime.WithLabelValues(instance, r.service).Observe(d.connSetup + d.transmission)
+	}
+}
+
+// trackLifecycle counts the start, reload and stop of the master
+// daemon, and exports the version it logs.
+func (e *PostfixExporter) trackLifecycle(r *loglineResult, instance string) {
+	e.lifecycleEvents.WithLabelValues(instance, r.master.lifecycle).Inc()
+	switch r.master.lifecycle {
+	case "start":
+		e.starts.WithLabelValues(instance).Inc()
+	case "reload":
+		e.lastReload.WithLabelValues(instance).Set(float64(logTime(r).UnixNano()) / 1e9)
+	}
+
+	if v := r.master.version; v != "" {
+		e.versionsMu.Lock()
+		defer e.versionsMu.Unlock()
+
+		if old, ok := e.versions[instance]; ok && old != v {
+			e.versionInfo.DeleteLabelValues(instance, old)
+		}
+		e.versions[instance] = v
+		e.versionInfo.WithLabelValues(instance, v).Set(1)
+	}
+}
+
+// logTime returns the timestamp of `r`, or the current time if unknown.
+func logTime(r *loglineResult) time.Time {
+	if r.timestamp.IsZero() {
+		return timeNow()
+	}
+
+	return r.timestamp
+}
+
+// SetUnsupportedSamples enables keeping the `n` most recent unsupported
+// lines of each subprocess, for the /debug/unsupported endpoint.
+func (e *PostfixExporter) SetUnsupportedSamples(n int) {
+	if n <= 0 {
+		e.unsupportedSamples = nil
+
+		return
+	}
+	e.unsupportedSamples = newUnsupportedSamples(n)
+}
+
+// SetShowqPaths sets the paths of the showq sockets by instance, see
+// parseShowqPaths. Instances without one use the default path.
+func (e *PostfixExporter) SetShowqPaths(paths map[string]string) {
+	e.showqPaths = paths
+}
+
+// SetShowqTimeout sets the time after which reading from a showq socket
+// is aborted.
+func (e *PostfixExporter) SetShowqTimeout(timeout time.Duration) {
+	e.showqTimeout = timeout
+}
+
+// SetShowqDisabled disables collecting metrics from the mail queue, for
+// deployments without access to it, e.g. reading the logs of remote
+// mail servers. postfix_up then indicates whether the log lines of an
+// instance are read without errors.
+func (e *PostfixExporter) SetShowqDisabled(disabled bool) {
+	e.disableShowq = disabled
+}
+
+// setLogUp records whether the log lines of an instance are read without
+// errors.
+func (e *PostfixExporter) setLogUp(instance string, up bool) {
+	e.logUpMu.Lock()
+	defer e.logUpMu.Unlock()
+
+	if e.logUp == nil {
+		e.logUp = make(map[string]bool)
+	}
+	e.logUp[instance] = up
+}
+
+// collectLogUp sends postfix_up of the instances whose log lines are
+// read.
+func (e *PostfixExporter) collectLogUp(ch chan<- prometheus.Metric) {
+	e.logUpMu.Lock()
+	defer e.logUpMu.Unlock()
+
+	for _, instance := range e.instances {
+		up, ok := e.logUp[instance]
+		if !ok {
+			continue
+		}
+		v := 0.0
+		if up {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(postfixUpDesc, prometheus.GaugeValue, v, instance)
+	}
+}
+
+// SetShowqExec makes the exporter list the mail queue by running
+// postqueue in `format` ("json" or "text"), optionally via sudo, instead
+// of reading the showq socket. An empty format reads the socket.
+func (e *PostfixExporter) SetShowqExec(format string, sudo bool) {
+	e.showqExecFormat = format
+	e.showqExecSudo = sudo
+}
+
+// collectShowq collects the mail queue statistics of an instance.
+func (e *PostfixExporter) collectShowq(ctx context.Context, instance string, ch chan<- prometheus.Metric) error {
+	if e.showqExecFormat != "" {
+		return CollectShowqFromCommand(ctx, instance, e.showqExecFormat, e.showqExecSudo, e.showqOptions, ch)
+	}
+
+	path := e.showqPath(instance)
+	if !e.showqFilesystemFallback {
+		return CollectShowqFromSocket(ctx, path, instance, e.showqOptions, ch)
+	}
+
+	// The readers send what they parsed even if showq fails, e.g. times
+	// out. That is dropped if the fallback, which sends the same
+	// series, succeeds.
+	metrics, err := gatherMetrics(func(ch chan<- prometheus.Metric) error {
+		return CollectShowqFromSocket(ctx, path, instance, e.showqOptions, ch)
+	})
+	fallback := 0.0
+	if err == nil {
+		for _, m := range metrics {
+			ch <- m
+		}
+	} else {
+		// The showq socket is in the public subdirectory of the
+		// queue_directory. `ctx` is done if showq timed out, so
+		// counting gets a timeout of its own.
+		dir := filepath.Dir(filepath.Dir(path))
+		fsCtx, cancel := context.WithTimeout(context.Background(), e.showqTimeout)
+		defer cancel()
+		if fsErr := CollectQueueDirectory(fsCtx, dir, instance, ch); fsErr != nil {
+			log.Printf("Failed to count the queue files of %s: %s", instance, fsErr)
+			for _, m := range metrics {
+				ch <- m
+			}
+		} else {
+			fallback = 1
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(showqFilesystemFallbackDesc, prometheus.GaugeValue, fallback, instance)
+
+	return err
+}
+
+// SetShowqFilesystemFallback makes the exporter count the queue files in
+// the queue_directory if reading from the showq socket fails.
+func (e *PostfixExporter) SetShowqFilesystemFallback(enabled bool) {
+	e.showqFilesystemFallback = enabled
+}
+
+// SetShowqInterval makes the exporter refresh the mail queue metrics in
+// the background every `interval`, see RefreshShowq, and serve them from
+// a cache. The metrics are collected at every scrape if 0.
+func (e *PostfixExporter) SetShowqInterval(interval time.Duration) {
+	e.showqInterval = interval
+	e.showqCache = nil
+	if interval > 0 {
+		e.showqCache = newShowqCache()
+	}
+}
+
+// RefreshShowq refreshes the cached mail queue metrics of all instances
+// every showqInterval, until `ctx` is done.
+func (e *PostfixExporter) RefreshShowq(ctx context.Context) {
+	if e.showqCache == nil || e.disableShowq {
+		return
+	}
+
+	ticker := time.NewTicker(e.showqInterval)
+	defer ticker.Stop()
+
+	for {
+		e.reloadMu.RLock()
+		for i, r := range e.scrapeShowqs(ctx) {
+			if r.err != nil {
+				log.Printf("Failed to scrape showq: %s", r.err)
+			}
+			e.showqCache.Update(e.instances[i], r.metrics, r.err, timeNow())
+		}
+		e.reloadMu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// A showqResult is the result of scraping the mail queue of an instance.
+type showqResult struct {
+	metrics []prometheus.Metric
+	err     error
+}
+
+// scrapeShowqs scrapes the mail queues of all instances, up to
+// showqConcurrency at a time, each with its own timeout. The results are
+// in the order of the instances.
+func (e *PostfixExporter) scrapeShowqs(ctx context.Context) []showqResult {
+	results := make([]showqResult, len(e.instances))
+	workers := e.showqConcurrency
+	if workers > len(e.instances) {
+		workers = len(e.instances)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				metrics, err := e.scrapeShowq(ctx, e.instances[i])
+				results[i] = showqResult{metrics, err}
+			}
+		}()
+	}
+	for i := range e.instances {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// SetShowqConcurrency sets the number of mail queues scraped at the same
+// time.
+func (e *PostfixExporter) SetShowqConcurrency(n int) {
+	e.showqConcurrency = n
+}
+
+// scrapeShowq returns the mail queue metrics of an instance, and records
+// the duration and result of listing the mail queue.
+func (e *PostfixExporter) scrapeShowq(ctx context.Context, instance string) ([]prometheus.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.showqTimeout)
+	defer cancel()
+
+	start := time.Now()
+	metrics, err := gatherMetrics(func(ch chan<- prometheus.Metric) error {
+		return e.collectShowq(ctx, instance, ch)
+	})
+
+	e.showqScrapeDuration.WithLabelValues(instance).Set(time.Since(start).Seconds())
+	if err != nil {
+		e.showqScrapeErrors.WithLabelValues(instance).Inc()
+	}
+
+	return metrics, err
+}
+
+// gatherMetrics returns the metrics sent by `collect`, and its error.
+func gatherMetrics(collect func(ch chan<- prometheus.Metric) error) ([]prometheus.Metric, error) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	err := collect(ch)
+	close(ch)
+
+	return <-done, err
+}
+
+// showqPath returns the path of the showq socket of an instance.
+func (e *PostfixExporter) showqPath(instance string) string {
+	if path, ok := e.showqPaths[instance]; ok {
+		return path
+	}
+	if path, ok := e.showqPaths[""]; ok {
+		return path
+	}
+
+	return defaultShowqPath(instance)
+}
+
+// Reload replaces the instances, the paths of their showq sockets and
+// the names they log as, the custom metrics and the log source, e.g.
+// after the config file changed. All other metrics keep their values,
+// as do custom metrics with unchanged rules. The log collection must be
+// stopped meanwhile.
+func (e *PostfixExporter) Reload(instances []string, showqPaths, syslogNames map[string]string, cm *customMetrics, logSrc logsource.LogSource) {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	e.instances = instances
+	e.showqPaths = showqPaths
+	e.syslogNames = syslogNames
+	if cm != nil {
+		cm.keep(e.customMetrics)
+	}
+	e.customMetrics = cm
+	e.logSrc = logSrc
+}
+
+// setLogSource replaces the log source, e.g. once it could be reopened
+// after a failed reload. The log collection must be stopped meanwhile.
+func (e *PostfixExporter) setLogSource(logSrc logsource.LogSource) {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	e.logSrc = logSrc
+}
+
+// checkCustomMetrics returns an error if a metric of `cm` collides with
+// one of the exporter's own metrics, e.g. has the same name, which
+// would make every scrape fail.
+func (e *PostfixExporter) checkCustomMetrics(cm *customMetrics) error {
+	current := make(map[*prometheus.Desc]bool)
+	if e.customMetrics != nil {
+		for _, d := range describe(e.customMetrics) {
+			current[d] = true
+		}
+	}
+	var own descCollector
+	for _, d := range describe(e) {
+		if !current[d] {
+			own = append(own, d)
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(own); err != nil {
+		return err
+	}
+	if err := reg.Register(cm); err != nil {
+		return fmt.Errorf("custom metrics: %w", err)
+	}
+
+	return nil
+}
+
+// SetCustomMetrics enables the user-defined metrics of `cm`, evaluated
+// on unsupported log lines. It must be called before the exporter is
+// registered.
+func (e *PostfixExporter) SetCustomMetrics(cm *customMetrics) {
+	e.customMetrics = cm
+}
+
+// A builtinParser is the exporter's own lineparser.LineParser, of
+// either Postfix daemons or other programs. It is registered for the
+// subprocesses it supports like other parsers, but the metrics of the
+// lines it parses are exported by the PostfixExporter passing them.
+type builtinParser struct {
+	postfix bool
+}
+
+var (
+	builtinPostfixParser = &builtinParser{postfix: true}
+	builtinOtherParser   = &builtinParser{}
+)
+
+func init() {
+	for _, daemon := range postfixDaemons {
+		lineparser.Register(daemon, builtinPostfixParser)
+	}
+	for program := range otherPrograms {
+		lineparser.Register(program, builtinOtherParser)
+	}
+}
+
+func (*builtinParser) Describe(chan<- *prometheus.Desc) {}
+func (*builtinParser) Collect(chan<- prometheus.Metric) {}
+
+// ParseLine implements lineparser.LineParser. It only reports whether
+// the line is supported; the exporter collects the metrics of the lines
+// passed to its pipelines itself.
+func (b *builtinParser) ParseLine(l lineparser.Line) bool {
+	return !b.parse(l).unsupported
+}
+
+// parse parses a line of a subprocess the parser is registered for.
+func (b *builtinParser) parse(l lineparser.Line) loglineResult {
+	r := loglineResult{
+		service:   l.Service,
+		queueID:   l.QueueID,
+		timestamp: l.Timestamp,
+		message:   l.Message,
+	}
+	if b.postfix {
+		r.subprocess = l.Subprocess
+		parsePostfixMessage(&r)
+	} else {
+		r.program = l.Subprocess
+		parseOtherMessage(&r)
+	}
+
+	return r
+}
+
+// newLineParserPipelines returns the pipelines of the parsers used for
+// each subprocess, with the exporter's own parser first.
+func newLineParserPipelines() map[string][]lineparser.LineParser {
+	pipelines := make(map[string][]lineparser.LineParser)
+	for _, subprocess := range lineparser.Subprocesses() {
+		parsers := lineparser.ForSubprocess(subprocess)
+		sort.SliceStable(parsers, func(i, j int) bool {
+			_, builtinI := parsers[i].(*builtinParser)
+			_, builtinJ := parsers[j].(*builtinParser)
+
+			return builtinI && !builtinJ
+		})
+		pipelines[subprocess] = parsers
+	}
+
+	return pipelines
+}
+
+// parseWithLineParsers passes a line to the pipeline of parsers of its
+// subprocess. It reports whether any of them supported the line.
+func (e *PostfixExporter) parseWithLineParsers(instance, line string, r loglineResult) bool {
+	subprocess := r.subprocess
+	if r.program != "" {
+		subprocess = r.program
+	}
 
-	if r.unsupported {
-		if !r.ignore {
-			e.addToUnsupportedLine(line, instance, r.subprocess)
+	l := lineparser.Line{
+		Instance:   instance,
+		Subprocess: subprocess,
+		Service:    r.service,
+		QueueID:    r.queueID,
+		Timestamp:  r.timestamp,
+		Message:    r.message,
+		Raw:        line,
+	}
+	supported := false
+	for _, p := range e.lineParsers[subprocess] {
+		if b, ok := p.(*builtinParser); ok {
+			if res := b.parse(l); !res.unsupported && (res.subprocess != "dovecot" || e.collectDovecot) {
+				e.collect(instance, res)
+				supported = true
+			}
+		} else if p.ParseLine(l) {
+			supported = true
 		}
+	}
+
+	return supported
+}
+
+// SetSyslogNames sets the syslog_name of instances, where it differs
+// from the instance name.
+func (e *PostfixExporter) SetSyslogNames(names map[string]string) {
+	e.syslogNames = names
+}
+
+// syslogName returns the name `instance` logs as.
+func (e *PostfixExporter) syslogName(instance string) string {
+	if name, ok := e.syslogNames[instance]; ok {
+		return name
+	}
+
+	return instance
+}
+
+// SetGeoIP enables labeling the smtpd connect and reject metrics by the
+// country of the client, looked up in `db`. It must be called before
+// the exporter is registered.
+func (e *PostfixExporter) SetGeoIP(db *geoIPDB) {
+	e.geoIP = db
+	e.smtpdConnects, e.smtpdRejects = newSMTPDClientVecs(db != nil)
+}
+
+// countryLabels appends the country of the smtpd client of `r` to
+// `labels`, if enabled.
+func (e *PostfixExporter) countryLabels(r loglineResult, labels ...string) []string {
+	if e.geoIP == nil {
+		return labels
+	}
+
+	var country string
+	if ip := net.ParseIP(r.smtpd.clientIP); ip != nil {
+		country = e.geoIP.Country(ip)
+	}
+
+	return append(labels, country)
+}
+
+// saslUserLabels appends the SASL user name of `r` to `labels`, if
+// enabled.
+func (e *PostfixExporter) saslUserLabels(r loglineResult, labels ...string) []string {
+	if !e.saslUsernameLabel {
+		return labels
+	}
+
+	return append(labels, e.saslUsers.Value(r.smtpd.saslUsername))
+}
+
+// SetDeliveryDomainLabels controls whether postfix_delivery_recipients_total
+// is labeled by sender and recipient domain, optionally hashed. The
+// sender domain requires message tracking. It must be called before the
+// exporter is registered.
+func (e *PostfixExporter) SetDeliveryDomainLabels(sender, recipient, hash bool) {
+	e.senderDomainLabel, e.recipientDomainLabel, e.hashDomainLabels = sender, recipient, hash
+	e.showqOptions.HashDomains = hash
+	e.deliveryRecipients = newDeliveryRecipientsVec(sender, recipient)
+}
+
+// deliveryRecipientLabels returns the label values of
+// postfix_delivery_recipients_total for the delivery agent line `r`.
+func (e *PostfixExporter) deliveryRecipientLabels(r loglineResult, instance string) []string {
+	labels := []string{instance, r.subprocess, r.status}
+	if e.senderDomainLabel {
+		var domain string
+		if e.messages != nil {
+			domain = e.messages.SenderDomain(messageKey{instance, r.queueID})
+		}
+		labels = append(labels, e.senderDomains.Value(e.anonymizeDomain(domain)))
+	}
+	if e.recipientDomainLabel {
+		labels = append(labels, e.recipientDomains.Value(e.anonymizeDomain(r.recipientDomain)))
+	}
+
+	return labels
+}
 
+// anonymizeDomain replaces `domain` by a hash, if enabled.
+func (e *PostfixExporter) anonymizeDomain(domain string) string {
+	if !e.hashDomainLabels || domain == "" {
+		return domain
+	}
+
+	return hashDomain(domain)
+}
+
+// hashDomain returns a hash of `domain`, to use as label value instead.
+func hashDomain(domain string) string {
+	sum := sha256.Sum256([]byte(domain))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// SetShowqQShape enables the qshape-like matrix of the active and
+// deferred queue by age and `mode` ("sender" or "recipient") domain, for
+// the `domains` domains with the most messages. Disabled if `mode` is
+// empty.
+func (e *PostfixExporter) SetShowqQShape(mode string, domains int) {
+	e.showqOptions.QShape, e.showqOptions.QShapeDomains = mode, domains
+}
+
+// SetShowqMaxMessages sets the number of messages read from the mail
+// queue at most. Unlimited if 0.
+func (e *PostfixExporter) SetShowqMaxMessages(n int) {
+	e.showqOptions.MaxMessages = n
+}
+
+// SetShowqTopSenders sets the number of envelope senders with the most
+// messages in the queue exported. Disabled if 0.
+func (e *PostfixExporter) SetShowqTopSenders(n int) {
+	e.showqOptions.TopSenders = n
+}
+
+// SetShowqMailerDaemon sets whether messages from MAILER-DAEMON are
+// included in the queue metrics, see ShowqOptions.MailerDaemon.
+func (e *PostfixExporter) SetShowqMailerDaemon(mode string) {
+	if mode == "include" {
+		mode = ""
+	}
+	e.showqOptions.MailerDaemon = mode
+}
+
+// SetShowqDomains limits the queue metrics to messages with a sender or
+// recipient domain in `domains`, if not empty, and without one in
+// `exclude`.
+func (e *PostfixExporter) SetShowqDomains(domains, exclude []string) {
+	e.showqOptions.Domains, e.showqOptions.ExcludeDomains = domains, exclude
+}
+
+// SetShowqDeferredDomains sets the number of recipient domains with the
+// most recipients in the deferred queue exported. Disabled if 0.
+func (e *PostfixExporter) SetShowqDeferredDomains(n int) {
+	e.showqOptions.DeferredDomains = n
+}
+
+// SetSMTPDomains sets the recipient domains (including subdomains) for
+// which per-domain SMTP delivery metrics are exported.
+func (e *PostfixExporter) SetSMTPDomains(domains []string) {
+	e.smtpDomains = make([]string, 0, len(domains))
+	for _, d := range domains {
+		e.smtpDomains = append(e.smtpDomains, strings.ToLower(strings.TrimPrefix(d, ".")))
+	}
+}
+
+// CollectFromLogline collects metrict from a Postfix log line.
+func (e *PostfixExporter) CollectFromLogLine(instance, line string) {
+	r := parseLogHeader(e.syslogName(instance), line)
+
+	if !r.timestamp.IsZero() {
+		e.lastLogEventTime.WithLabelValues(instance).Set(float64(r.timestamp.UnixNano()) / 1e9)
+	}
+
+	if !r.unsupported && e.parseWithLineParsers(instance, line, r) {
 		return
 	}
+	if e.customMetrics != nil && e.customMetrics.CollectFromLogLine(instance, line) {
+		return
+	}
+	if !r.ignore {
+		subprocess := r.subprocess
+		if r.program != "" {
+			subprocess = otherPrograms[r.program]
+		}
+		if subprocess == "dovecot" && !e.collectDovecot {
+			subprocess = ""
+		}
+		e.addToUnsupportedLine(line, instance, subprocess)
+	}
+}
+
+// collect updates the metrics of a line supported by the exporter's
+// own parser.
+func (e *PostfixExporter) collect(instance string, r loglineResult) { //nolint:gocognit
+	if r.warning != "" {
+		e.warnings.WithLabelValues(instance, r.subprocess, r.warning).Inc()
+	} else if r.fatal {
+		e.fatalErrors.WithLabelValues(instance, r.subprocess).Inc()
+	} else if r.panic {
+		e.panics.WithLabelValues(instance, r.subprocess).Inc()
+	}
+	if r.dsn != "" {
+		e.deliveryDSNs.WithLabelValues(instance, r.subprocess, r.dsn).Inc()
+	}
+	if r.status != "" {
+		e.deliveryRecipients.WithLabelValues(e.deliveryRecipientLabels(r, instance)...).Inc()
+	}
+	if v := r.remoteStatus; v != nil {
+		e.deliveryRemoteStatus.WithLabelValues(append([]string{instance, r.subprocess}, v...)...).Inc()
+	}
+	if v := r.bounceCategory; v != "" {
+		e.deliveryBounces.WithLabelValues(instance, r.subprocess, v).Inc()
+	}
+	if v := r.milterError; v != "" {
+		e.milterErrors.WithLabelValues(instance, r.service, r.milter, v).Inc()
+	}
+	if v := r.accessAction; v != "" {
+		e.accessActions.WithLabelValues(instance, r.service, v).Inc()
+	}
+	if v := r.mimeEvent; v != "" {
+		e.mimeEvents.WithLabelValues(instance, r.subprocess, v).Inc()
+	}
+	e.trackDelivery(&r, instance, r.status)
+	e.trackReinjection(&r, instance)
+	if e.messageIDs != nil {
+		for _, next := range []string{r.queuedAs, r.forwardedAs} {
+			if next != "" {
+				e.messageIDs.HandOff(instance, r.queueID, next)
+			}
+		}
+	}
 
 	switch r.subprocess {
+	case "bounce":
+		// Copies sent to the postmaster are not counted.
+		if r.bounce.recipient == "sender" {
+			switch r.bounce.notification {
+			case "non-delivery":
+				e.bounceNonDeliveryNotifications.WithLabelValues(instance).Inc()
+			case "delay":
+				e.bounceDelayNotifications.WithLabelValues(instance).Inc()
+			}
+		}
 	case "cleanup":
 		if r.cleanup.process {
 			e.cleanupProcesses.WithLabelValues(instance).Inc()
+			if v := r.cleanup.messageID; e.messageIDs != nil && v != "" {
+				for _, name := range e.messageIDs.Seen(instance, v, r.queueID, logTime(&r)) {
+					e.cleanupDuplicateMessageIDs.WithLabelValues(name).Inc()
+				}
+			}
+			if e.messages != nil && r.queueID != "" {
+				for _, name := range e.messages.Arrive(messageKey{instance, r.queueID}, logTime(&r)) {
+					e.messageTrackingEvictions.WithLabelValues(name).Inc()
+				}
+			}
 		} else if r.cleanup.reject {
 			e.cleanupRejects.WithLabelValues(instance).Inc()
 		}
+	case "dovecot":
+		if v := r.dovecot.lmtpResult; v != "" {
+			e.dovecotLMTPDeliveries.WithLabelValues(v).Inc()
+		} else if v := r.dovecot.authService; v != "" {
+			e.dovecotAuthFailures.WithLabelValues(v).Add(r.dovecot.authFailures)
+		}
 	case "lmtp":
 		if v := r.lmtp.delays; v != nil {
 			e.lmtpDelays.WithLabelValues(instance, "before_queue_manager").Observe(v.beforeQueueManager)
@@ -96,6 +1026,14 @@ func (e *PostfixExporter) CollectFromLogLine(instance, line string) { //nolint:g
 			e.lmtpDelays.WithLabelValues(instance, "connection_setup").Observe(v.connSetup)
 			e.lmtpDelays.WithLabelValues(instance, "transmission").Observe(v.transmission)
 		}
+	case "master":
+		if v := r.master.processLimit; v != "" {
+			e.masterProcessLimits.WithLabelValues(instance, v).Inc()
+		} else if v := r.master.exitProgram; v != "" {
+			e.masterProcessExits.WithLabelValues(instance, v, r.master.exitKind, r.master.status).Inc()
+		} else if v := r.master.lifecycle; v != "" {
+			e.trackLifecycle(&r, instance)
+		}
 	case "pipe":
 		if v := r.pipe.delays; v != nil {
 			e.pipeDelays.WithLabelValues(instance, r.pipe.relay, "before_queue_manager").Observe(v.beforeQueueManager)
@@ -103,49 +1041,175 @@ func (e *PostfixExporter) CollectFromLogLine(instance, line string) { //nolint:g
 			e.pipeDelays.WithLabelValues(instance, r.pipe.relay, "connection_setup").Observe(v.connSetup)
 			e.pipeDelays.WithLabelValues(instance, r.pipe.relay, "transmission").Observe(v.transmission)
 		}
+	case "amavis":
+		e.amavisVerdicts.WithLabelValues(r.amavis.action, r.amavis.category).Inc()
+		if r.amavis.elapsed >= 0 {
+			e.amavisScanDuration.Observe(r.amavis.elapsed)
+		}
+	case "opendkim":
+		e.opendkimResults.WithLabelValues(r.opendkim.result, e.opendkimDomains.Value(r.opendkim.domain)).Inc()
+	case "postgrey":
+		e.postgreyResults.WithLabelValues(r.postgrey.result).Inc()
+	case "postscreen":
+		if r.postscreen.connect {
+			e.postscreenConnects.WithLabelValues(instance).Inc()
+		} else if v := r.postscreen.verdict; v != "" {
+			e.postscreenVerdicts.WithLabelValues(instance, v).Inc()
+		}
 	case "qmgr":
 		if r.qmgr.removed {
 			e.qmgrRemoves.WithLabelValues(instance).Inc()
-		} else {
+			if e.messages != nil {
+				e.messages.Remove(messageKey{instance, r.queueID})
+			}
+			if e.messageIDs != nil {
+				if n := e.messageIDs.Remove(instance, r.queueID); n > 0 {
+					e.cleanupDuplicateMessageIDs.WithLabelValues(instance).Add(float64(n))
+				}
+			}
+		} else if v := r.qmgr.expired; v != "" {
+			e.qmgrExpired.WithLabelValues(instance, v).Inc()
+			e.trackDelivery(&r, instance, "expired")
+		} else if r.qmgr.inserted {
+			if e.messages != nil {
+				if d, ok := e.messages.Activate(messageKey{instance, r.queueID}, logTime(&r), r.qmgr.senderDomain); ok {
+					e.messageTimeToActivation.WithLabelValues(instance).Observe(d.Seconds())
+				}
+			}
 			e.qmgrInsertsSize.WithLabelValues(instance).Observe(r.qmgr.size)
 			e.qmgrInsertsNrcpt.WithLabelValues(instance).Observe(r.qmgr.nrcpt)
+			if e.saslTopUsers != nil {
+				e.saslTopUsers.AddRecipients(r.queueID, r.qmgr.nrcpt)
+			}
+		}
+	case "rspamd":
+		e.rspamdActions.WithLabelValues(r.rspamd.action).Inc()
+		e.rspamdScores.Observe(r.rspamd.score)
+	case "scache":
+		if v := r.scache.lookup; v != "" {
+			e.scacheLookups.WithLabelValues(instance, v, "hit").Set(r.scache.hits)
+			e.scacheLookups.WithLabelValues(instance, v, "miss").Set(r.scache.misses)
+			e.scacheLookupHitRatio.WithLabelValues(instance, v).Set(r.scache.hitRatio)
+		} else if r.scache.maxSimultaneous {
+			e.scacheMaxSimultaneous.WithLabelValues(instance, "domains").Set(r.scache.domains)
+			e.scacheMaxSimultaneous.WithLabelValues(instance, "addresses").Set(r.scache.addresses)
+			e.scacheMaxSimultaneous.WithLabelValues(instance, "connections").Set(r.scache.connections)
 		}
 	case "smtp":
 		if v := r.smtp.delays; v != nil {
-			e.smtpDelays.WithLabelValues(instance, "before_queue_manager").Observe(v.beforeQueueManager)
-			e.smtpDelays.WithLabelValues(instance, "queue_manager").Observe(v.queueManager)
-			e.smtpDelays.WithLabelValues(instance, "connection_setup").Observe(v.connSetup)
-			e.smtpDelays.WithLabelValues(instance, "transmission").Observe(v.transmission)
+			labels := []string{instance, ""}
+			if e.smtpDelayRelays != nil {
+				relay := matchDomain(strings.ToLower(r.smtp.relay), e.smtpDelayRelays)
+				if relay == "" {
+					relay = otherLabelValue
+				}
+				labels = append(labels, relay)
+			}
+			for stage, d := range map[string]float64{
+				"before_queue_manager": v.beforeQueueManager,
+				"queue_manager":        v.queueManager,
+				"connection_setup":     v.connSetup,
+				"transmission":         v.transmission,
+			} {
+				labels[1] = stage
+				e.smtpDelays.WithLabelValues(labels...).Observe(d)
+			}
+			e.smtpDelayTotal.WithLabelValues(instance).Observe(r.smtp.delay)
 
 			if r.smtp.status != "" {
-				e.smtpStatus.WithLabelValues(instance, r.smtp.status)
+				labels := []string{instance, r.smtp.status, r.smtp.ipFamily, r.smtp.port}
+				if e.smtpRelayLabel {
+					labels = append(labels, r.smtp.relay)
+				}
+				e.smtpStatus.WithLabelValues(labels...).Inc()
+			}
+			if v := r.smtp.deferredReason; v != "" {
+				e.smtpDeferred.WithLabelValues(instance, v).Inc()
+			}
+			if v := r.smtp.tlsPolicy; v != "" {
+				e.smtpTLSPolicyFailures.WithLabelValues(instance, v).Inc()
+			}
+			if v := r.smtp.dnsError; v != "" {
+				e.smtpDNSErrors.WithLabelValues(instance, v).Inc()
+			}
+			if d := matchDomain(r.recipientDomain, e.smtpDomains); d != "" {
+				if r.smtp.status != "" {
+					e.smtpDomainStatus.WithLabelValues(instance, d, r.smtp.status).Inc()
+				}
+				e.smtpDomainDelays.WithLabelValues(instance, d).Observe(r.smtp.delay)
 			}
 		} else if v := r.smtp.tls; v != nil {
-			e.smtpTLSConnects.WithLabelValues(append([]string{instance}, v...)...).Inc()
-		} else if r.smtp.timeout {
-			e.smtpConnectionTimedOut.WithLabelValues(instance).Inc()
+			e.smtpTLSConnects.WithLabelValues(append(append([]string{instance}, v...), r.smtp.port)...).Inc()
+			if r.smtp.tlsVerification != "" {
+				e.smtpTLSVerifications.WithLabelValues(instance, e.smtpTLSDestinations.Value(r.smtp.tlsDestination), r.smtp.tlsVerification).Inc()
+			}
+		} else if r.smtp.tlsVerification == "failed" {
+			e.smtpTLSVerifications.WithLabelValues(instance, e.smtpTLSDestinations.Value(r.smtp.tlsDestination), r.smtp.tlsVerification).Inc()
+		} else if v := r.smtp.connectError; v != "" {
+			if r.smtp.timeout {
+				e.smtpConnectionTimedOut.WithLabelValues(instance).Inc()
+			}
+			e.smtpConnectErrors.WithLabelValues(instance, v, r.smtp.ipFamily).Inc()
+		} else if v := r.smtp.lostConnection; v != "" {
+			e.smtpLostConnections.WithLabelValues(instance, v).Inc()
+		} else if v := r.smtp.tlsHandshakeError; v != "" {
+			labels := []string{instance, v}
+			if e.smtpRelayLabel {
+				labels = append(labels, e.smtpTLSDestinations.Value(r.smtp.relay))
+			}
+			e.smtpTLSHandshakeErrors.WithLabelValues(labels...).Inc()
 		}
 	case "smtpd":
 		if r.smtpd.connect {
-			e.smtpdConnects.WithLabelValues(instance).Inc()
+			e.smtpdConnects.WithLabelValues(e.countryLabels(r, instance, r.service)...).Inc()
+			if e.topClients != nil && r.smtpd.clientIP != "" {
+				e.topClients.AddConnection(instance, r.smtpd.clientIP, r.smtpd.clientHost)
+			}
 		} else if r.smtpd.disconnect {
-			e.smtpdDisconnects.WithLabelValues(instance).Inc()
+			e.smtpdDisconnects.WithLabelValues(instance, r.service).Inc()
 		} else if r.smtpd.dnsError {
-			e.smtpdFCrDNSErrors.WithLabelValues(instance).Inc()
+			e.smtpdFCrDNSErrors.WithLabelValues(instance, r.service).Inc()
 		} else if v := r.smtpd.lostConnection; v != "" {
-			e.smtpdLostConnections.WithLabelValues(instance, v).Inc()
-		} else if v := r.smtpd.saslMethod; v != "" {
-			e.smtpdSASLConnects.WithLabelValues(instance, v).Inc()
+			e.smtpdLostConnections.WithLabelValues(instance, r.service, v).Inc()
+		} else if v := r.smtpd.timeout; v != "" {
+			e.smtpdTimeouts.WithLabelValues(instance, r.service, v).Inc()
 		} else if r.smtpd.process {
-			e.smtpdProcesses.WithLabelValues(instance).Inc()
+			e.smtpdProcesses.WithLabelValues(e.saslUserLabels(r, instance, r.service)...).Inc()
+			if r.smtpd.proxied {
+				e.smtpdProxied.WithLabelValues(instance, r.service).Inc()
+			}
+			if v := r.smtpd.saslMethod; v != "" {
+				e.smtpdSASLConnects.WithLabelValues(e.saslUserLabels(r, instance, r.service, v)...).Inc()
+			}
+			if v := r.smtpd.saslUsername; v != "" && e.saslTopUsers != nil {
+				e.saslTopUsers.AddMessage(instance, v, r.queueID)
+			}
+		} else if v := r.smtpd.reject; v != "" && r.smtpd.rejectWarning {
+			e.smtpdRejectWarnings.WithLabelValues(instance, r.service, r.smtpd.rejectStage, v, r.smtpd.rejectReason).Inc()
 		} else if v := r.smtpd.reject; v != "" {
-			e.smtpdRejects.WithLabelValues(instance, v).Inc()
+			e.smtpdRejects.WithLabelValues(e.countryLabels(r, instance, r.service, r.smtpd.rejectStage, v, r.smtpd.rejectReason)...).Inc()
+			e.smtpdRejectRestrictions.WithLabelValues(instance, r.service, r.smtpd.rejectRestriction, r.smtpd.rejectList).Inc()
+			if r.smtpd.greylisted {
+				e.smtpdGreylisted.WithLabelValues(instance, r.service).Inc()
+			}
+		} else if v := r.smtpd.proxyReject; v != "" {
+			e.smtpdProxyRejects.WithLabelValues(instance, r.service, v).Inc()
+		} else if v := r.smtpd.rateLimit; v != "" {
+			e.smtpdRateLimits.WithLabelValues(instance, r.service, v).Inc()
 		} else if r.smtpd.saslAuthFailed {
-			e.smtpdSASLAuthenticationFailures.WithLabelValues(instance).Inc()
+			e.smtpdSASLAuthenticationFailures.WithLabelValues(e.saslUserLabels(r, instance, r.service)...).Inc()
 		} else if v := r.smtpd.tls; v != nil {
 			log.Println("---------------------", v)
 
-			e.smtpdTLSConnects.WithLabelValues(append([]string{instance}, v...)...).Inc()
+			e.smtpdTLSConnects.WithLabelValues(append([]string{instance, r.service}, v...)...).Inc()
+		} else if v := r.smtpd.tlsHandshakeError; v != "" {
+			e.smtpdTLSHandshakeErrors.WithLabelValues(instance, r.service, v).Inc()
+		}
+	case "tlsproxy":
+		if r.tlsproxy.connect {
+			e.tlsproxyConnects.WithLabelValues(instance).Inc()
+		} else if v := r.tlsproxy.tls; v != nil {
+			e.tlsproxyTLSConnects.WithLabelValues(append([]string{instance}, v...)...).Inc()
 		}
 	}
 }
@@ -154,6 +1218,9 @@ func (e *PostfixExporter) addToUnsupportedLine(line, instance, subprocess string
 	if e.logUnsupportedLines {
 		log.Printf("Unsupported Line: %v", line)
 	}
+	if e.unsupportedSamples != nil {
+		e.unsupportedSamples.Add(subprocess, line)
+	}
 	e.unsupportedLogEntries.WithLabelValues(instance, subprocess).Inc()
 }
 
@@ -165,43 +1232,140 @@ func addToHistogramVec(h *prometheus.HistogramVec, value, fieldName string, labe
 	h.WithLabelValues(labels...).Observe(float)
 }
 
+// defaultShowqTimeout is the default timeout of reading from a showq
+// socket.
+const defaultShowqTimeout = 10 * time.Second
+
+// defaultShowqConcurrency is the default number of mail queues scraped
+// at the same time.
+const defaultShowqConcurrency = 4
+
+// defaultTimeBuckets are the default histogram buckets of delays in
+// seconds.
+var defaultTimeBuckets = []float64{1e-3, 1e-2, 1e-1, 1.0, 10, 1 * 60, 1 * 60 * 60, 24 * 60 * 60, 2 * 24 * 60 * 60}
+
 // NewPostfixExporter creates a new Postfix exporter instance.
-func NewPostfixExporter(instances []string, logSrc LogSource, logUnsupportedLines bool) (*PostfixExporter, error) { //nolint:funlen
-	timeBuckets := []float64{1e-3, 1e-2, 1e-1, 1.0, 10, 1 * 60, 1 * 60 * 60, 24 * 60 * 60, 2 * 24 * 60 * 60}
+func NewPostfixExporter(instances []string, logSrc logsource.LogSource, logUnsupportedLines bool) (*PostfixExporter, error) { //nolint:funlen
 	const ns = "postfix"
 
-	return &PostfixExporter{
+	e := &PostfixExporter{
 		logUnsupportedLines: logUnsupportedLines,
 		instances:           instances,
 		logSrc:              logSrc,
+		lineParsers:         newLineParserPipelines(),
+		timeBuckets:         defaultTimeBuckets,
 
+		opendkimDomains:     newLabelLimiter(defaultMaxDomains),
+		versions:            make(map[string]string),
+		showqTimeout:        defaultShowqTimeout,
+		showqConcurrency:    defaultShowqConcurrency,
+		senderDomains:       newLabelLimiter(defaultMaxDomains),
+		recipientDomains:    newLabelLimiter(defaultMaxDomains),
+		smtpTLSDestinations: newLabelLimiter(defaultMaxDomains),
+		saslUsers:           newLabelLimiter(defaultMaxSASLUsers),
+
+		amavisVerdicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "amavis_verdicts_total",
+			Help:      "Total number of messages checked by amavis, by action and content category.",
+		}, []string{"action", "category"}),
+		amavisScanDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "amavis_scan_duration_seconds",
+			Help:      "Time amavis took to check a message in seconds.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}),
+		bounceNonDeliveryNotifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "bounce_non_delivery_notifications_total",
+			Help:      "Total number of non-delivery notifications sent to senders.",
+		}, []string{"name"}),
+		bounceDelayNotifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "bounce_delay_notifications_total",
+			Help:      "Total number of delayed mail notifications sent to senders.",
+		}, []string{"name"}),
 		cleanupProcesses: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "cleanup_messages_processed_total",
 			Help:      "Total number of messages processed by cleanup.",
 		}, []string{"name"}),
+		cleanupDuplicateMessageIDs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "cleanup_duplicate_message_ids_total",
+			Help:      "Total number of messages processed by cleanup with a Message-ID seen before within the duplicate window.",
+		}, []string{"name"}),
 		cleanupRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "cleanup_messages_rejected_total",
 			Help:      "Total number of messages rejected by cleanup.",
 		}, []string{"name"}),
+		accessActions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "access_actions_total",
+			Help:      "Total number of HOLD, DISCARD, FILTER and REDIRECT actions of access maps and header or body checks in smtpd and cleanup.",
+		}, []string{"name", "service", "action"}),
+		milterErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "milter_errors_total",
+			Help:      "Total number of failed communications of smtpd and cleanup with milters, by milter and error.",
+		}, []string{"name", "service", "milter", "error"}),
 		cleanupNotAccepted: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "cleanup_messages_not_accepted_total",
 			Help:      "Total number of messages not accepted by cleanup.",
 		}, []string{"name"}),
-		lmtpDelays: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		deliveryDSNs: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
-			Name:      "lmtp_delivery_delay_seconds",
-			Help:      "LMTP message processing time in seconds.",
-			Buckets:   timeBuckets,
-		}, []string{"name", "stage"}),
-		pipeDelays: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:      "delivery_dsn_total",
+			Help:      "Total number of delivery attempts, by service and delivery status code (DSN).",
+		}, []string{"name", "service", "dsn"}),
+		deliveryRecipients: newDeliveryRecipientsVec(false, false),
+		deliveryRemoteStatus: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "delivery_remote_status_total",
+			Help:      "Total number of delivery attempts rejected by the remote server, by service and the class, subject and detail of the enhanced status code of its reply.",
+		}, []string{"name", "service", "class", "subject", "detail"}),
+		deliveryBounces: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "delivery_bounces_total",
+			Help:      "Total number of bounced recipients, by service and category.",
+		}, []string{"name", "service", "category"}),
+		mimeEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "mime_events_total",
+			Help:      "Total number of SMTPUTF8 and 8-bit MIME errors, e.g. failed conversions.",
+		}, []string{"name", "service", "event"}),
+		dovecotLMTPDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "dovecot_lmtp_deliveries_total",
+			Help:      "Total number of messages delivered by Dovecot LMTP, by result.",
+		}, []string{"result"}),
+		dovecotAuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "dovecot_auth_failures_total",
+			Help:      "Total number of Dovecot authentication failures, by service.",
+		}, []string{"service"}),
+		opendkimResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "opendkim_results_total",
+			Help:      "Total number of OpenDKIM signing and verification results.",
+		}, []string{"result", "domain"}),
+		postgreyResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "postgrey_results_total",
+			Help:      "Total number of postgrey greylisting results (delay or pass).",
+		}, []string{"result"}),
+		postscreenConnects: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
-			Name:      "pipe_delivery_delay_seconds",
-			Help:      "Pipe message processing time in seconds.",
-			Buckets:   timeBuckets,
-		}, []string{"name", "relay", "stage"}),
+			Name:      "postscreen_connects_total",
+			Help:      "Total number of connections to postscreen.",
+		}, []string{"name"}),
+		postscreenVerdicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "postscreen_verdicts_total",
+			Help:      "Total number of postscreen verdicts.",
+		}, []string{"name", "verdict"}),
 		qmgrInsertsNrcpt: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 			Namespace: ns,
 			Name:      "qmgr_messages_inserted_receipients",
@@ -219,108 +1383,514 @@ func NewPostfixExporter(instances []string, logSrc LogSource, logUnsupportedLine
 			Name:      "qmgr_messages_removed_total",
 			Help:      "Total number of messages removed from mail queues.",
 		}, []string{"name"}),
-		smtpDelays: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		qmgrExpired: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
-			Name:      "smtp_delivery_delay_seconds",
-			Help:      "SMTP message processing time in seconds.",
-			Buckets:   timeBuckets,
-		}, []string{"name", "stage"}),
+			Name:      "qmgr_messages_expired_total",
+			Help:      "Total number of messages expired from the queue and returned to sender.",
+		}, []string{"name", "status"}),
+		messageTrackingEvictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "message_tracking_evictions_total",
+			Help:      "Total number of messages no longer tracked for their time in queue after not being seen for the tracking TTL.",
+		}, []string{"name"}),
+		filterReinjections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "filter_reinjections_total",
+			Help:      "Total number of recipients delivered to a content filter which queued the message again, by filter service.",
+		}, []string{"name", "service"}),
+		rspamdActions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "rspamd_actions_total",
+			Help:      "Total number of messages checked by rspamd, by action.",
+		}, []string{"action"}),
+		rspamdScores: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "rspamd_score",
+			Help:      "Spam scores of messages checked by rspamd.",
+			Buckets:   []float64{-5, 0, 2.5, 5, 7.5, 10, 15, 20, 30},
+		}),
+		scacheLookups: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "scache_lookups",
+			Help:      "Number of connection cache lookups in the last scache statistics interval, by lookup type and result.",
+		}, []string{"name", "type", "result"}),
+		scacheLookupHitRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "scache_lookup_hit_ratio",
+			Help:      "Ratio of successful connection cache lookups in the last scache statistics interval.",
+		}, []string{"name", "type"}),
+		scacheMaxSimultaneous: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "scache_max_simultaneous",
+			Help:      "Maximum number of simultaneously cached domains, addresses or connections in the last scache statistics interval.",
+		}, []string{"name", "kind"}),
+		smtpDomainStatus: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtp_domain_status_total",
+			Help:      "Total number of SMTP deliveries to allowlisted recipient domains, by status.",
+		}, []string{"name", "domain", "status"}),
+		smtpDeferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtp_deferred_total",
+			Help:      "Total number of deferred SMTP deliveries, by reason.",
+		}, []string{"name", "reason"}),
+		smtpDNSErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtp_dns_errors_total",
+			Help:      "Total number of SMTP deliveries failed due to DNS errors, by error type.",
+		}, []string{"name", "error"}),
 		smtpTLSConnects: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "smtp_tls_connections_total",
 			Help:      "Total number of outgoing TLS connections.",
-		}, []string{"name", "trust", "protocol", "cipher", "secret_bits", "algorithm_bits"}),
+		}, []string{"name", "trust", "protocol", "cipher", "secret_bits", "algorithm_bits", "port"}),
+		smtpTLSVerifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtp_tls_verifications_total",
+			Help:      "Total number of outgoing TLS connections by destination and certificate verification result.",
+		}, []string{"name", "destination", "result"}),
+		smtpTLSPolicyFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtp_tls_policy_failures_total",
+			Help:      "Total number of SMTP deliveries deferred because the TLS security level could not be satisfied, by level.",
+		}, []string{"name", "policy"}),
+		smtpTLSHandshakeErrors: newSMTPTLSHandshakeErrorsVec(false),
 		smtpConnectionTimedOut: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "smtp_connection_timed_out_total",
 			Help:      "Total number of messages that have been timed out on SMTP.",
 		}, []string{"name"}),
-		smtpdConnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+		smtpConnectErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
-			Name:      "smtpd_connects_total",
-			Help:      "Total number of incoming connections.",
-		}, []string{"name"}),
+			Name:      "smtp_connect_errors_total",
+			Help:      "Total number of failed outgoing SMTP connections, by error.",
+		}, []string{"name", "error", "ip_family"}),
+		smtpLostConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtp_connections_lost_total",
+			Help:      "Total number of outgoing connections lost.",
+		}, []string{"name", "stage"}),
 		smtpdDisconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "smtpd_disconnects_total",
 			Help:      "Total number of incoming disconnections.",
-		}, []string{"name"}),
+		}, []string{"name", "service"}),
 		smtpdFCrDNSErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "smtpd_forward_confirmed_reverse_dns_errors_total",
 			Help:      "Total number of connections for which forward-confirmed DNS cannot be resolved.",
-		}, []string{"name"}),
+		}, []string{"name", "service"}),
 		smtpdLostConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "smtpd_connections_lost_total",
 			Help:      "Total number of connections lost.",
-		}, []string{"name", "after_stage"}),
-		smtpdProcesses: prometheus.NewCounterVec(prometheus.CounterOpts{
+		}, []string{"name", "service", "after_stage"}),
+		smtpdTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
-			Name:      "smtpd_messages_processed_total",
-			Help:      "Total number of messages processed.",
-		}, []string{"name"}),
-		smtpdRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:      "smtpd_connections_timed_out_total",
+			Help:      "Total number of connections timed out.",
+		}, []string{"name", "service", "after_stage"}),
+		smtpdProxied: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
-			Name:      "smtpd_messages_rejected_total",
-			Help:      "Total number of NOQUEUE rejects.",
-		}, []string{"name", "code"}),
-		smtpdSASLConnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:      "smtpd_messages_proxied_total",
+			Help:      "Total number of messages received with the client overridden by XCLIENT.",
+		}, []string{"name", "service"}),
+		smtpdRejectRestrictions: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
-			Name:      "smtpd_sasl_connections_total",
-			Help:      "Total number of SASL connections.",
-		}, []string{"name", "sasl_method"}),
-		smtpdSASLAuthenticationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:      "smtpd_reject_restrictions_total",
+			Help:      "Total number of NOQUEUE rejects, by the restriction and DNS blocklist that fired.",
+		}, []string{"name", "service", "restriction", "list"}),
+		smtpdRateLimits: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
-			Name:      "smtpd_sasl_authentication_failures_total",
-			Help:      "Total number of SASL authentication failures.",
-		}, []string{"name"}),
+			Name:      "smtpd_rate_limit_rejects_total",
+			Help:      "Total number of clients rejected due to exceeded anvil rate limits.",
+		}, []string{"name", "service", "limit"}),
+		smtpdGreylisted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtpd_messages_greylisted_total",
+			Help:      "Total number of NOQUEUE rejects due to greylisting.",
+		}, []string{"name", "service"}),
+		smtpdRejectWarnings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtpd_reject_warnings_total",
+			Help:      "Total number of rejects which were only logged because of warn_if_reject, by SMTP stage.",
+		}, []string{"name", "service", "stage", "code", "reason"}),
+		smtpdProxyRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtpd_proxy_rejects_total",
+			Help:      "Total number of messages rejected by the before-queue content filter (smtpd_proxy_filter), by SMTP code.",
+		}, []string{"name", "service", "code"}),
 		smtpdTLSConnects: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "smtpd_tls_connections_total",
 			Help:      "Total number of incoming TLS connections.",
+		}, []string{"name", "service", "trust", "protocol", "cipher", "secret_bits", "algorithm_bits"}),
+		smtpdTLSHandshakeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "smtpd_tls_handshake_errors_total",
+			Help:      "Total number of failed TLS handshakes of incoming connections, by error category.",
+		}, []string{"name", "service", "error"}),
+		tlsproxyConnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "tlsproxy_connects_total",
+			Help:      "Total number of connections to tlsproxy.",
+		}, []string{"name"}),
+		tlsproxyTLSConnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "tlsproxy_tls_connections_total",
+			Help:      "Total number of TLS connections established by tlsproxy.",
 		}, []string{"name", "trust", "protocol", "cipher", "secret_bits", "algorithm_bits"}),
+		fatalErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "fatal_errors_total",
+			Help:      "Total number of fatal error lines.",
+		}, []string{"name", "service"}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "panics_total",
+			Help:      "Total number of panic lines.",
+		}, []string{"name", "service"}),
+		warnings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "warnings_total",
+			Help:      "Total number of warning lines, by category.",
+		}, []string{"name", "service", "category"}),
+		masterProcessLimits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "master_process_limit_reached_total",
+			Help:      "Total number of times a master.cf service reached its process limit, delaying new clients.",
+		}, []string{"name", "service"}),
+		masterProcessExits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "master_process_exits_total",
+			Help:      "Total number of Postfix processes which terminated with a non-zero exit status or by a signal.",
+		}, []string{"name", "program", "kind", "status"}),
+		lifecycleEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "lifecycle_events_total",
+			Help:      "Total number of starts, reloads and stops of the master daemon.",
+		}, []string{"name", "event"}),
+		starts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "starts_total",
+			Help:      "Total number of starts of the master daemon.",
+		}, []string{"name"}),
+		lastReload: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "last_reload_timestamp_seconds",
+			Help:      "Time of the last reload of the master daemon, in seconds since the Unix epoch.",
+		}, []string{"name"}),
+		versionInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "version_info",
+			Help:      "Postfix version logged by the master daemon at its last start or reload, always 1.",
+		}, []string{"name", "version"}),
 		unsupportedLogEntries: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Name:      "unsupported_log_entries_total",
 			Help:      "Log entries that could not be processed.",
 		}, []string{"name", "service"}),
-		smtpStatus: prometheus.NewCounterVec(prometheus.CounterOpts{
+		smtpStatus: newSMTPStatusVec(false),
+		lastLogEventTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: ns,
-			Name:      "smtp_status_total",
-			Help:      "Total number of messages by status.",
-		}, []string{"name", "status"}),
-	}, nil
+			Name:      "last_log_event_timestamp_seconds",
+			Help:      "Time of the most recent log event with a high-precision timestamp, as UNIX timestamp.",
+		}, []string{"name"}),
+
+		logSourceLines: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "postfix_exporter",
+			Name:      "log_source_lines_total",
+			Help:      "Total number of lines read from the log source.",
+		}, []string{"path"}),
+		logSourceReadErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "postfix_exporter",
+			Name:      "log_source_read_errors_total",
+			Help:      "Total number of errors reading from the log source.",
+		}, []string{"path"}),
+		logSourceLastReadTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "postfix_exporter",
+			Name:      "log_source_last_read_timestamp_seconds",
+			Help:      "Time the last line was read from the log source, as UNIX timestamp.",
+		}, []string{"path"}),
+		showqScrapeDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Subsystem: "showq",
+			Name:      "scrape_duration_seconds",
+			Help:      "Duration of the last listing of the mail queue, in seconds.",
+		}, []string{"name"}),
+		showqScrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: "showq",
+			Name:      "scrape_errors_total",
+			Help:      "Total number of failed listings of the mail queue.",
+		}, []string{"name"}),
+		showqMessagesScanned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: "showq",
+			Name:      "messages_scanned_total",
+			Help:      "Total number of messages read from listings of the mail queue.",
+		}, []string{"name"}),
+		showqParseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: "showq",
+			Name:      "parse_errors_total",
+			Help:      "Total number of malformed messages skipped in listings of the mail queue.",
+		}, []string{"name"}),
+	}
+	e.showqOptions.ParseErrors = e.showqParseErrors
+	e.showqOptions.Scanned = e.showqMessagesScanned
+	e.newDelayHistograms()
+	e.smtpdProcesses, e.smtpdSASLConnects, e.smtpdSASLAuthenticationFailures = newSMTPDSASLVecs(false)
+	e.smtpdConnects, e.smtpdRejects = newSMTPDClientVecs(false)
+
+	return e, nil
+}
+
+// newSMTPStatusVec creates the postfix_smtp_status_total metric,
+// optionally with a relay label.
+func newSMTPStatusVec(relayLabel bool) *prometheus.CounterVec {
+	labels := []string{"name", "status", "ip_family", "port"}
+	if relayLabel {
+		labels = append(labels, "relay")
+	}
+
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "postfix",
+		Name:      "smtp_status_total",
+		Help:      "Total number of messages by status.",
+	}, labels)
+}
+
+// newDelayHistograms (re-)creates the histograms of delays, with the
+// buckets and native histogram bucket factor of the exporter.
+func (e *PostfixExporter) newDelayHistograms() {
+	e.lmtpDelays = newDelayHistogramVec("lmtp_delivery_delay_seconds", "LMTP message processing time in seconds.", []string{"name", "stage"}, e.timeBuckets, e.histogramFactor)
+	e.pipeDelays = newDelayHistogramVec("pipe_delivery_delay_seconds", "Pipe message processing time in seconds.", []string{"name", "relay", "stage"}, e.timeBuckets, e.histogramFactor)
+	e.messageTimeInQueue = newDelayHistogramVec("message_time_in_queue_seconds", "Time from the arrival of messages to the final delivery status of their recipients in seconds, by status.", []string{"name", "status"}, e.timeBuckets, e.histogramFactor)
+	e.messageTimeToActivation = newDelayHistogramVec("message_time_to_activation_seconds", "Time from the arrival of messages to their activation by qmgr in seconds.", []string{"name"}, e.timeBuckets, e.histogramFactor)
+	e.filterProcessingTime = newDelayHistogramVec("filter_processing_seconds", "Time of deliveries to content filters which queued the message again, including the processing by the filter, in seconds.", []string{"name", "service"}, e.timeBuckets, e.histogramFactor)
+	e.smtpDelays = newSMTPDelaysVec(len(e.smtpDelayRelays) > 0, e.timeBuckets, e.histogramFactor)
+	e.smtpDelayTotal = newDelayHistogramVec("smtp_delivery_delay_total_seconds", "Total SMTP message time in system (delay=) in seconds.", []string{"name"}, e.timeBuckets, e.histogramFactor)
+	e.smtpDomainDelays = newDelayHistogramVec("smtp_domain_delivery_delay_seconds", "Total SMTP delivery delay to allowlisted recipient domains in seconds.", []string{"name", "domain"}, e.timeBuckets, e.histogramFactor)
+	e.showqOptions.NativeHistogramBucketFactor = e.histogramFactor
+}
+
+// newDelayHistogramVec creates a histogram of delays in seconds.
+func newDelayHistogramVec(name, help string, labels []string, buckets []float64, factor float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "postfix",
+		Name:      name,
+		Help:      help,
+		Buckets:   buckets,
+
+		NativeHistogramBucketFactor: factor,
+	}, labels)
+}
+
+// newSMTPDelaysVec creates postfix_smtp_delivery_delay_seconds,
+// optionally labeled by relay.
+func newSMTPDelaysVec(relayLabel bool, buckets []float64, factor float64) *prometheus.HistogramVec {
+	labels := []string{"name", "stage"}
+	if relayLabel {
+		labels = append(labels, "relay")
+	}
+
+	return newDelayHistogramVec("smtp_delivery_delay_seconds", "SMTP message processing time in seconds.", labels, buckets, factor)
+}
+
+// newSMTPTLSHandshakeErrorsVec creates
+// postfix_smtp_tls_handshake_errors_total, optionally labeled by relay.
+func newSMTPTLSHandshakeErrorsVec(relayLabel bool) *prometheus.CounterVec {
+	labels := []string{"name", "error"}
+	if relayLabel {
+		labels = append(labels, "relay")
+	}
+
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "postfix",
+		Name:      "smtp_tls_handshake_errors_total",
+		Help:      "Total number of failed TLS handshakes of outgoing connections, by error category.",
+	}, labels)
+}
+
+// newDeliveryRecipientsVec creates postfix_delivery_recipients_total,
+// optionally labeled by sender and recipient domain.
+func newDeliveryRecipientsVec(senderLabel, recipientLabel bool) *prometheus.CounterVec {
+	labels := []string{"name", "service", "status"}
+	if senderLabel {
+		labels = append(labels, "sender_domain")
+	}
+	if recipientLabel {
+		labels = append(labels, "recipient_domain")
+	}
+
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "postfix",
+		Name:      "delivery_recipients_total",
+		Help:      "Total number of recipients of delivery attempts, by service and status.",
+	}, labels)
+}
+
+// newSMTPDClientVecs creates the smtpd metrics which can optionally be
+// labeled by the country of the client.
+func newSMTPDClientVecs(countryLabel bool) (connects, rejects *prometheus.CounterVec) {
+	var extra []string
+	if countryLabel {
+		extra = []string{"country"}
+	}
+
+	connects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "postfix",
+		Name:      "smtpd_connects_total",
+		Help:      "Total number of incoming connections.",
+	}, append([]string{"name", "service"}, extra...))
+	rejects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "postfix",
+		Name:      "smtpd_messages_rejected_total",
+		Help:      "Total number of rejects, by SMTP stage.",
+	}, append([]string{"name", "service", "stage", "code", "reason"}, extra...))
+
+	return connects, rejects
+}
+
+// newSMTPDSASLVecs creates the smtpd metrics which can optionally be
+// labeled by SASL user name.
+func newSMTPDSASLVecs(userLabel bool) (processes, saslConnects, saslFailures *prometheus.CounterVec) {
+	labels := []string{"name", "service"}
+	if userLabel {
+		labels = append(labels, "sasl_username")
+	}
+
+	processes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "postfix",
+		Name:      "smtpd_messages_processed_total",
+		Help:      "Total number of messages processed.",
+	}, labels)
+	saslConnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "postfix",
+		Name:      "smtpd_sasl_connections_total",
+		Help:      "Total number of messages processed from SASL authenticated clients.",
+	}, append([]string{"name", "service", "sasl_method"}, labels[2:]...))
+	saslFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "postfix",
+		Name:      "smtpd_sasl_authentication_failures_total",
+		Help:      "Total number of SASL authentication failures.",
+	}, labels)
+
+	return processes, saslConnects, saslFailures
 }
 
 // Describe the Prometheus metrics that are going to be exported.
 func (e *PostfixExporter) Describe(ch chan<- *prometheus.Desc) {
+	e.reloadMu.RLock()
+	defer e.reloadMu.RUnlock()
+
 	ch <- postfixUpDesc
+	ch <- postfixMasterUpDesc
+	e.showqScrapeDuration.Describe(ch)
+	e.showqScrapeErrors.Describe(ch)
+	e.showqMessagesScanned.Describe(ch)
+	e.showqParseErrors.Describe(ch)
 
 	if e.logSrc == nil {
 		return
 	}
+	e.amavisVerdicts.Describe(ch)
+	e.amavisScanDuration.Describe(ch)
+	e.bounceNonDeliveryNotifications.Describe(ch)
+	e.bounceDelayNotifications.Describe(ch)
 	e.cleanupProcesses.Describe(ch)
+	e.cleanupDuplicateMessageIDs.Describe(ch)
 	e.cleanupRejects.Describe(ch)
+	e.accessActions.Describe(ch)
+	e.milterErrors.Describe(ch)
 	e.cleanupNotAccepted.Describe(ch)
+	e.deliveryDSNs.Describe(ch)
+	e.deliveryRecipients.Describe(ch)
+	e.deliveryRemoteStatus.Describe(ch)
+	e.deliveryBounces.Describe(ch)
+	e.mimeEvents.Describe(ch)
+	e.dovecotLMTPDeliveries.Describe(ch)
+	e.dovecotAuthFailures.Describe(ch)
 	e.lmtpDelays.Describe(ch)
+	e.opendkimResults.Describe(ch)
 	e.pipeDelays.Describe(ch)
+	e.postgreyResults.Describe(ch)
+	e.postscreenConnects.Describe(ch)
+	e.postscreenVerdicts.Describe(ch)
 	e.qmgrInsertsNrcpt.Describe(ch)
 	e.qmgrInsertsSize.Describe(ch)
 	e.qmgrRemoves.Describe(ch)
+	e.qmgrExpired.Describe(ch)
+	e.messageTimeInQueue.Describe(ch)
+	e.messageTimeToActivation.Describe(ch)
+	e.messageTrackingEvictions.Describe(ch)
+	e.filterReinjections.Describe(ch)
+	e.filterProcessingTime.Describe(ch)
+	e.rspamdActions.Describe(ch)
+	e.rspamdScores.Describe(ch)
+	e.scacheLookups.Describe(ch)
+	e.scacheLookupHitRatio.Describe(ch)
+	e.scacheMaxSimultaneous.Describe(ch)
 	e.smtpDelays.Describe(ch)
+	e.smtpDelayTotal.Describe(ch)
+	e.smtpDomainStatus.Describe(ch)
+	e.smtpDomainDelays.Describe(ch)
+	e.smtpDeferred.Describe(ch)
+	e.smtpDNSErrors.Describe(ch)
 	e.smtpTLSConnects.Describe(ch)
+	e.smtpTLSVerifications.Describe(ch)
+	e.smtpTLSPolicyFailures.Describe(ch)
+	e.smtpTLSHandshakeErrors.Describe(ch)
 	e.smtpdConnects.Describe(ch)
 	e.smtpdDisconnects.Describe(ch)
 	e.smtpdFCrDNSErrors.Describe(ch)
 	e.smtpdLostConnections.Describe(ch)
+	e.smtpdTimeouts.Describe(ch)
 	e.smtpdProcesses.Describe(ch)
+	e.smtpdSASLConnects.Describe(ch)
 	e.smtpdRejects.Describe(ch)
+	e.smtpdProxied.Describe(ch)
+	e.smtpdRejectRestrictions.Describe(ch)
+	e.smtpdRateLimits.Describe(ch)
+	e.smtpdGreylisted.Describe(ch)
+	e.smtpdRejectWarnings.Describe(ch)
+	e.smtpdProxyRejects.Describe(ch)
 	e.smtpdSASLAuthenticationFailures.Describe(ch)
 	e.smtpdTLSConnects.Describe(ch)
+	e.smtpdTLSHandshakeErrors.Describe(ch)
+	e.tlsproxyConnects.Describe(ch)
+	e.tlsproxyTLSConnects.Describe(ch)
 	e.smtpStatus.Describe(ch)
+	e.lastLogEventTime.Describe(ch)
+	e.fatalErrors.Describe(ch)
+	e.panics.Describe(ch)
+	e.warnings.Describe(ch)
+	e.masterProcessLimits.Describe(ch)
+	e.masterProcessExits.Describe(ch)
+	e.lifecycleEvents.Describe(ch)
+	e.starts.Describe(ch)
+	e.lastReload.Describe(ch)
+	e.versionInfo.Describe(ch)
 	e.unsupportedLogEntries.Describe(ch)
 	e.smtpConnectionTimedOut.Describe(ch)
+	e.smtpConnectErrors.Describe(ch)
+	e.smtpLostConnections.Describe(ch)
+	e.logSourceLines.Describe(ch)
+	e.logSourceReadErrors.Describe(ch)
+	e.logSourceLastReadTime.Describe(ch)
+	if e.saslTopUsers != nil {
+		e.saslTopUsers.Describe(ch)
+	}
+	if e.topClients != nil {
+		e.topClients.Describe(ch)
+	}
+	if e.customMetrics != nil {
+		e.customMetrics.Describe(ch)
+	}
+	for _, p := range lineparser.All() {
+		p.Describe(ch)
+	}
 }
 
 func (e *PostfixExporter) StartMetricCollection(ctx context.Context, instance string) {
@@ -328,65 +1898,173 @@ func (e *PostfixExporter) StartMetricCollection(ctx context.Context, instance st
 		return
 	}
 
-	gaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "postfix",
-		Subsystem: "",
-		Name:      "up",
-		Help:      "Whether scraping Postfix's metrics was successful.",
-	}, []string{"name", "path"})
-	gauge := gaugeVec.WithLabelValues(instance, e.logSrc.Path())
-	defer gauge.Set(0)
+	path := e.logSrc.Path()
+	e.setLogUp(instance, true)
+
+	lines := e.logSourceLines.WithLabelValues(path)
+	readErrors := e.logSourceReadErrors.WithLabelValues(path)
+	lastRead := e.logSourceLastReadTime.WithLabelValues(path)
 
 	for {
 		line, err := e.logSrc.Read(ctx)
 		if err != nil {
-			if err != io.EOF {
+			if err != io.EOF && ctx.Err() == nil {
+				readErrors.Inc()
+				e.setLogUp(instance, false)
 				log.Printf("Couldn't read journal: %v", err)
 			}
 
 			return
 		}
+		lines.Inc()
+		lastRead.Set(float64(timeNow().UnixNano()) / 1e9)
 		e.CollectFromLogLine(instance, line)
-		gauge.Set(1)
 	}
 }
 
+// CollectOnce reads the log source until its end (for all instances),
+// and returns afterwards.
+func (e *PostfixExporter) CollectOnce(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, instance := range e.instances {
+		wg.Add(1)
+		go func(instance string) {
+			defer wg.Done()
+			e.StartMetricCollection(ctx, instance)
+		}(instance)
+	}
+	wg.Wait()
+}
+
 // Collect metrics from Postfix's showq socket and its log file.
 func (e *PostfixExporter) Collect(ch chan<- prometheus.Metric) {
-	if !e.skipShowq {
+	e.reloadMu.RLock()
+	defer e.reloadMu.RUnlock()
+
+	if !e.disableShowq && e.showqCache != nil {
 		for _, instance := range e.instances {
-			err := CollectShowqFromSocket(instance, ch)
-			if err == nil {
-				ch <- prometheus.MustNewConstMetric(postfixUpDesc, prometheus.GaugeValue, 1.0, instance)
+			e.showqCache.Collect(instance, timeNow(), ch)
+		}
+	} else if !e.disableShowq {
+		for i, r := range e.scrapeShowqs(context.Background()) {
+			for _, m := range r.metrics {
+				ch <- m
+			}
+			if r.err == nil {
+				ch <- prometheus.MustNewConstMetric(postfixUpDesc, prometheus.GaugeValue, 1.0, e.instances[i])
 			} else {
-				log.Printf("Failed to scrape showq socket: %s", err)
-				ch <- prometheus.MustNewConstMetric(postfixUpDesc, prometheus.GaugeValue, 0.0, instance)
+				log.Printf("Failed to scrape showq: %s", r.err)
+				ch <- prometheus.MustNewConstMetric(postfixUpDesc, prometheus.GaugeValue, 0.0, e.instances[i])
 			}
 		}
 	}
+	if e.disableShowq {
+		e.collectLogUp(ch)
+	} else {
+		e.collectMasterUp(ch)
+		e.showqScrapeDuration.Collect(ch)
+		e.showqScrapeErrors.Collect(ch)
+		e.showqMessagesScanned.Collect(ch)
+		e.showqParseErrors.Collect(ch)
+	}
 
 	if e.logSrc == nil {
 		return
 	}
+	e.amavisVerdicts.Collect(ch)
+	e.amavisScanDuration.Collect(ch)
+	e.bounceNonDeliveryNotifications.Collect(ch)
+	e.bounceDelayNotifications.Collect(ch)
 	e.cleanupProcesses.Collect(ch)
+	e.cleanupDuplicateMessageIDs.Collect(ch)
 	e.cleanupRejects.Collect(ch)
+	e.accessActions.Collect(ch)
+	e.milterErrors.Collect(ch)
 	e.cleanupNotAccepted.Collect(ch)
+	e.deliveryDSNs.Collect(ch)
+	e.deliveryRecipients.Collect(ch)
+	e.deliveryRemoteStatus.Collect(ch)
+	e.deliveryBounces.Collect(ch)
+	e.mimeEvents.Collect(ch)
+	e.dovecotLMTPDeliveries.Collect(ch)
+	e.dovecotAuthFailures.Collect(ch)
 	e.lmtpDelays.Collect(ch)
+	e.opendkimResults.Collect(ch)
 	e.pipeDelays.Collect(ch)
+	e.postgreyResults.Collect(ch)
+	e.postscreenConnects.Collect(ch)
+	e.postscreenVerdicts.Collect(ch)
 	e.qmgrInsertsNrcpt.Collect(ch)
 	e.qmgrInsertsSize.Collect(ch)
 	e.qmgrRemoves.Collect(ch)
+	e.qmgrExpired.Collect(ch)
+	e.messageTimeInQueue.Collect(ch)
+	e.messageTimeToActivation.Collect(ch)
+	e.messageTrackingEvictions.Collect(ch)
+	e.filterReinjections.Collect(ch)
+	e.filterProcessingTime.Collect(ch)
+	e.rspamdActions.Collect(ch)
+	e.rspamdScores.Collect(ch)
+	e.scacheLookups.Collect(ch)
+	e.scacheLookupHitRatio.Collect(ch)
+	e.scacheMaxSimultaneous.Collect(ch)
 	e.smtpDelays.Collect(ch)
+	e.smtpDelayTotal.Collect(ch)
+	e.smtpDomainStatus.Collect(ch)
+	e.smtpDomainDelays.Collect(ch)
+	e.smtpDeferred.Collect(ch)
+	e.smtpDNSErrors.Collect(ch)
 	e.smtpTLSConnects.Collect(ch)
+	e.smtpTLSVerifications.Collect(ch)
+	e.smtpTLSPolicyFailures.Collect(ch)
+	e.smtpTLSHandshakeErrors.Collect(ch)
 	e.smtpdConnects.Collect(ch)
 	e.smtpdDisconnects.Collect(ch)
 	e.smtpdFCrDNSErrors.Collect(ch)
 	e.smtpdLostConnections.Collect(ch)
+	e.smtpdTimeouts.Collect(ch)
 	e.smtpdProcesses.Collect(ch)
+	e.smtpdSASLConnects.Collect(ch)
 	e.smtpdRejects.Collect(ch)
+	e.smtpdProxied.Collect(ch)
+	e.smtpdRejectRestrictions.Collect(ch)
+	e.smtpdRateLimits.Collect(ch)
+	e.smtpdGreylisted.Collect(ch)
+	e.smtpdRejectWarnings.Collect(ch)
+	e.smtpdProxyRejects.Collect(ch)
 	e.smtpdSASLAuthenticationFailures.Collect(ch)
 	e.smtpdTLSConnects.Collect(ch)
+	e.smtpdTLSHandshakeErrors.Collect(ch)
+	e.tlsproxyConnects.Collect(ch)
+	e.tlsproxyTLSConnects.Collect(ch)
 	e.smtpStatus.Collect(ch)
+	e.lastLogEventTime.Collect(ch)
+	e.fatalErrors.Collect(ch)
+	e.panics.Collect(ch)
+	e.warnings.Collect(ch)
+	e.masterProcessLimits.Collect(ch)
+	e.masterProcessExits.Collect(ch)
+	e.lifecycleEvents.Collect(ch)
+	e.starts.Collect(ch)
+	e.lastReload.Collect(ch)
+	e.versionInfo.Collect(ch)
 	e.unsupportedLogEntries.Collect(ch)
 	e.smtpConnectionTimedOut.Collect(ch)
+	e.smtpConnectErrors.Collect(ch)
+	e.smtpLostConnections.Collect(ch)
+	e.logSourceLines.Collect(ch)
+	e.logSourceReadErrors.Collect(ch)
+	e.logSourceLastReadTime.Collect(ch)
+	if e.saslTopUsers != nil {
+		e.saslTopUsers.Collect(ch)
+	}
+	if e.topClients != nil {
+		e.topClients.Collect(ch)
+	}
+	if e.customMetrics != nil {
+		e.customMetrics.Collect(ch)
+	}
+	for _, p := range lineparser.All() {
+		p.Collect(ch)
+	}
 }
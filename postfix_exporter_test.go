@@ -4,11 +4,18 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/digineo/postfix_exporter/lineparser"
+	"github.com/digineo/postfix_exporter/logsource"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -20,7 +27,7 @@ type testdataSource struct {
 	scanner *bufio.Scanner
 }
 
-var _ LogSource = (*testdataSource)(nil)
+var _ logsource.LogSource = (*testdataSource)(nil)
 
 func (s *testdataSource) Path() string { return s.f.Name() }
 
@@ -58,7 +65,7 @@ func TestPostfixExporter(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, ex)
 
-	ex.skipShowq = true
+	ex.SetShowqDisabled(true)
 
 	reg := prometheus.NewPedanticRegistry()
 	reg.MustRegister(ex)
@@ -84,3 +91,265 @@ func TestPostfixExporter(t *testing.T) {
 
 	assert.Equal(t, string(expected), buf.String())
 }
+
+func TestPostfixExporter_Dovecot(t *testing.T) {
+	t.Parallel()
+
+	const line = "Oct  2 10:50:00 mail dovecot: lmtp(b@example.org)<4567><AbCdEfGh>: msgid=<x@example.com>: saved mail to INBOX"
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+
+	ex.CollectFromLogLine("postfix", line)
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.unsupportedLogEntries.WithLabelValues("postfix", "")), "Dovecot lines should be unsupported by default.")
+
+	ex.collectDovecot = true
+	ex.CollectFromLogLine("postfix", line)
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.dovecotLMTPDeliveries.WithLabelValues("saved")))
+}
+
+func TestPostfixExporter_SMTPRelayLabel(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetSMTPRelayLabel(true)
+
+	ex.CollectFromLogLine("postfix", "Feb 24 16:18:40 letterman postfix/smtp[59649]: 5270320179: to=<hebj@telia.com>, relay=mail.telia.com[81.236.60.210]:25, delay=2017, delays=0.1/2017/0.03/0.05, dsn=2.0.0, status=sent (250 2.0.0 6FVIjIMwUJwU66FVIjAEB0 mail accepted for delivery)")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpStatus.WithLabelValues("postfix", "sent", "ipv4", "25", "mail.telia.com")))
+}
+
+func TestPostfixExporter_SMTPDomains(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetSMTPDomains([]string{"Telia.com"})
+
+	ex.CollectFromLogLine("postfix", "Feb 24 16:18:40 letterman postfix/smtp[59649]: 5270320179: to=<hebj@mail.telia.com>, relay=mail.telia.com[81.236.60.210]:25, delay=2017, delays=0.1/2017/0.03/0.05, dsn=2.0.0, status=sent (250 2.0.0 6FVIjIMwUJwU66FVIjAEB0 mail accepted for delivery)")
+	ex.CollectFromLogLine("postfix", "Feb 24 16:18:40 letterman postfix/smtp[59649]: 5270320179: to=<someone@example.com>, relay=mx.example.com[192.0.2.1]:25, delay=1.5, delays=0.1/0.4/0.5/0.5, dsn=2.0.0, status=sent (250 2.0.0 OK)")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpDomainStatus.WithLabelValues("postfix", "telia.com", "sent")))
+	assert.Equal(t, 1, testutil.CollectAndCount(ex.smtpDomainStatus), "Other domains should not be tracked.")
+}
+
+func TestPostfixExporter_SASLUsernameLabel(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetSASLUsernameLabel(true, 1)
+
+	ex.CollectFromLogLine("postfix", "Oct 30 13:19:26 mail postfix/submission/smtpd[27530]: EB4B2C19E2: client=unknown[192.0.2.1], sasl_method=PLAIN, sasl_username=alice@example.com")
+	ex.CollectFromLogLine("postfix", "Oct 30 13:19:27 mail postfix/submission/smtpd[27530]: EB4B2C19E3: client=unknown[192.0.2.2], sasl_method=PLAIN, sasl_username=bob@example.com")
+	ex.CollectFromLogLine("postfix", "Oct 30 13:19:28 mail postfix/submission/smtpd[27530]: warning: unknown[192.0.2.3]: SASL LOGIN authentication failed: authentication failure, sasl_username=alice@example.com")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpdProcesses.WithLabelValues("postfix", "submission", "alice@example.com")), "Messages of SASL clients should be counted as processed too.")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpdProcesses.WithLabelValues("postfix", "submission", "other")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpdSASLConnects.WithLabelValues("postfix", "submission", "PLAIN", "alice@example.com")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpdSASLConnects.WithLabelValues("postfix", "submission", "PLAIN", "other")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpdSASLAuthenticationFailures.WithLabelValues("postfix", "submission", "alice@example.com")))
+}
+
+func TestPostfixExporter_DeliveryDomainLabels(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetMessageTracking(time.Hour)
+	ex.SetDeliveryDomainLabels(true, true, false)
+	ex.SetMaxDomains(1)
+
+	ex.CollectFromLogLine("postfix", "Oct  3 10:00:00 mail postfix/cleanup[100]: 3F1A52C0E1: message-id=<1@example.com>")
+	ex.CollectFromLogLine("postfix", "Oct  3 10:00:01 mail postfix/qmgr[101]: 3F1A52C0E1: from=<a@Example.com>, size=1234, nrcpt=2 (queue active)")
+	ex.CollectFromLogLine("postfix", "Oct  3 10:00:03 mail postfix/smtp[102]: 3F1A52C0E1: to=<b@example.org>, relay=mx.example.org[192.0.2.1]:25, delay=3, delays=1/0/1/1, dsn=2.0.0, status=sent (250 2.0.0 OK)")
+	ex.CollectFromLogLine("postfix", "Oct  3 10:00:03 mail postfix/smtp[102]: 3F1A52C0E1: to=<c@example.net>, relay=mx.example.net[192.0.2.2]:25, delay=3, delays=1/0/1/1, dsn=2.0.0, status=sent (250 2.0.0 OK)")
+	ex.CollectFromLogLine("postfix", "Oct  3 10:00:03 mail postfix/local[103]: 4A2B63D1F2: to=<d@example.org>, relay=local, delay=0.1, delays=0/0/0/0.1, dsn=2.0.0, status=sent (delivered to mailbox)")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.deliveryRecipients.WithLabelValues("postfix", "smtp", "sent", "example.com", "example.org")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.deliveryRecipients.WithLabelValues("postfix", "smtp", "sent", "example.com", "other")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.deliveryRecipients.WithLabelValues("postfix", "local", "sent", "", "example.org")), "Untracked messages should have an empty sender domain.")
+
+	ex.SetDeliveryDomainLabels(false, true, true)
+	assert.Equal(t, "bfabc37432958b06", ex.anonymizeDomain("example.org"))
+}
+
+func TestPostfixExporter_SMTPDelayRelays(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetSMTPDelayRelays([]string{"google.com"})
+
+	ex.CollectFromLogLine("postfix", "Oct  3 10:00:03 mail postfix/smtp[102]: 3F1A52C0E1: to=<b@gmail.com>, relay=gmail-smtp-in.l.google.com[192.0.2.1]:25, delay=3, delays=1/0/1/1, dsn=2.0.0, status=sent (250 2.0.0 OK)")
+	ex.CollectFromLogLine("postfix", "Oct  3 10:00:03 mail postfix/smtp[102]: 3F1A52C0E1: to=<c@example.net>, relay=mx.example.net[192.0.2.2]:25, delay=3, delays=1/0/1/1, dsn=2.0.0, status=sent (250 2.0.0 OK)")
+	assert.Equal(t, 1, testutil.CollectAndCount(ex.smtpDelays.WithLabelValues("postfix", "transmission", "google.com").(prometheus.Histogram)))
+	assert.Equal(t, 1, testutil.CollectAndCount(ex.smtpDelays.WithLabelValues("postfix", "transmission", "other").(prometheus.Histogram)))
+	assert.Equal(t, 8, testutil.CollectAndCount(ex.smtpDelays))
+}
+
+func TestPostfixExporter_Lifecycle(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+
+	ex.CollectFromLogLine("postfix", "2024-03-01T10:00:00Z mail postfix/master[1001]: daemon started -- version 3.7.11, configuration /etc/postfix")
+	ex.CollectFromLogLine("postfix", "2024-03-01T11:00:00Z mail postfix/master[1001]: reload -- version 3.8.1, configuration /etc/postfix")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.starts.WithLabelValues("postfix")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.lifecycleEvents.WithLabelValues("postfix", "reload")))
+	assert.Equal(t, 1709290800.0, testutil.ToFloat64(ex.lastReload.WithLabelValues("postfix")))
+	assert.Equal(t, 1, testutil.CollectAndCount(ex.versionInfo), "Previous versions should be removed.")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.versionInfo.WithLabelValues("postfix", "3.8.1")))
+}
+
+// testLineParser records the lines it is passed starting with prefix.
+// It exports no metrics, so that it does not interfere with other
+// tests.
+type testLineParser struct {
+	prefix string
+	lines  []lineparser.Line
+}
+
+func (*testLineParser) Describe(chan<- *prometheus.Desc) {}
+func (*testLineParser) Collect(chan<- prometheus.Metric) {}
+
+func (p *testLineParser) ParseLine(l lineparser.Line) bool {
+	if !strings.HasPrefix(l.Message, p.prefix) {
+		return false
+	}
+	p.lines = append(p.lines, l)
+
+	return true
+}
+
+func TestPostfixExporter_LineParser(t *testing.T) {
+	t.Parallel()
+
+	p := &testLineParser{prefix: "ABC123DEF: "}
+	lineparser.Register("opendmarc", p)
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail opendmarc[321]: ABC123DEF: example.com pass")
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail opendmarc[321]: something else")
+	require.Len(t, p.lines, 1)
+	assert.Equal(t, "opendmarc", p.lines[0].Subprocess)
+	assert.Equal(t, "ABC123DEF: example.com pass", p.lines[0].Message)
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.unsupportedLogEntries.WithLabelValues("postfix", "")))
+}
+
+func TestPostfixExporter_LineParserSupported(t *testing.T) {
+	t.Parallel()
+
+	p := &testLineParser{prefix: "7F2A41C0B3: "}
+	lineparser.Register("smtpd", p)
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail postfix/smtpd[321]: 7F2A41C0B3: client=unknown[192.0.2.1]")
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail postfix/smtpd[321]: connect from unknown[192.0.2.1]")
+	require.Len(t, p.lines, 1, "Lines supported by the exporter should be passed to registered parsers.")
+	assert.Equal(t, "7F2A41C0B3", p.lines[0].QueueID)
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.smtpdProcesses.WithLabelValues("postfix", "smtpd")), "The exporter should still count the line.")
+	assert.Equal(t, 0, testutil.CollectAndCount(ex.unsupportedLogEntries))
+}
+
+func TestPostfixExporter_LineParserOverride(t *testing.T) {
+	t.Parallel()
+
+	assert.Contains(t, lineparser.ForSubprocess("smtpd"), lineparser.LineParser(builtinPostfixParser), "The exporter's own parsers should be registered.")
+
+	p := &testLineParser{prefix: "statistics: "}
+	lineparser.Override("anvil", p)
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail postfix/anvil[321]: statistics: max connection rate 1/60s for (smtp:192.0.2.1) at Oct  5 11:59:00")
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail postfix/anvil[321]: warning: dict_nis_init: NIS domain name not set - NIS lookups disabled")
+	require.Len(t, p.lines, 1)
+	assert.Equal(t, 0, testutil.CollectAndCount(ex.warnings), "The exporter's own parser should be replaced.")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ex.unsupportedLogEntries.WithLabelValues("postfix", "anvil")))
+}
+
+func TestPostfixExporter_Warnings(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail postfix/qmgr[101]: warning: mail for [192.0.2.25]:10025 is using up 20001 of 20001 active queue entries")
+	ex.CollectFromLogLine("postfix", "Oct  5 12:00:00 mail postfix/postscreen[102]: warning: getpeername: Transport endpoint is not connected -- dropping this connection")
+	assert.Equal(t, 2, testutil.CollectAndCount(ex.warnings))
+	assert.Equal(t, 0, testutil.CollectAndCount(ex.qmgrInsertsSize), "A warning is no inserted message.")
+	assert.Equal(t, 0, testutil.CollectAndCount(ex.qmgrInsertsNrcpt), "A warning is no inserted message.")
+	assert.Equal(t, 0, testutil.CollectAndCount(ex.postscreenVerdicts), "A warning is no verdict.")
+	assert.Equal(t, 0, testutil.CollectAndCount(ex.unsupportedLogEntries))
+}
+
+func TestPostfixExporter_NativeHistograms(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetSMTPDelayRelays([]string{"example.org"})
+	ex.SetNativeHistogramBucketFactor(1.1)
+	assert.Equal(t, 1.1, ex.showqOptions.NativeHistogramBucketFactor)
+
+	ex.CollectFromLogLine("postfix", "Feb 11 16:49:24 letterman postfix/smtp[8204]: AAB4D259B1: to=<b@example.org>, relay=mx.example.org[192.0.2.2]:25, delay=1.5, delays=0.1/0.2/0.3/0.9, dsn=2.0.0, status=sent (250 2.0.0 Ok)")
+	histograms, native := nativeHistograms(t, ex.smtpDelays.Collect)
+	assert.Equal(t, 4, histograms)
+	assert.Equal(t, histograms, native)
+}
+
+func TestPostfixExporter_DelayBuckets(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetDelayBuckets(nil)
+	assert.Equal(t, defaultTimeBuckets, ex.timeBuckets, "Empty buckets should keep the default.")
+	ex.SetDelayBuckets([]float64{0.1, 1, 10})
+
+	ex.CollectFromLogLine("postfix", "Feb 11 16:49:24 letterman postfix/smtp[8204]: AAB4D259B1: to=<b@example.org>, relay=mx.example.org[192.0.2.2]:25, delay=1.5, delays=0.1/0.2/0.3/0.9, dsn=2.0.0, status=sent (250 2.0.0 Ok)")
+	var pb dto.Metric
+	require.NoError(t, ex.smtpDelayTotal.WithLabelValues("postfix").(prometheus.Metric).Write(&pb))
+	assert.Len(t, pb.GetHistogram().Bucket, 3)
+}
+
+func TestMain(m *testing.M) {
+	// We compare Unix timestamps to date strings, so make it deterministic.
+	os.Setenv("TZ", "UTC")
+	timeNow = func() time.Time { return time.Date(2009, 2, 13, 23, 31, 30, 0, time.UTC) }
+	defer func() {
+		timeNow = time.Now
+	}()
+
+	os.Exit(m.Run())
+}
+
+// failingSource is a log source which fails to read.
+type failingSource struct{}
+
+func (failingSource) Path() string { return "failing" }
+
+func (failingSource) Read(context.Context) (string, error) {
+	return "", errors.New("permission denied")
+}
+
+func TestPostfixExporter_DisableShowq(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix", "postfix-out"}, failingSource{}, false)
+	require.NoError(t, err)
+	ex.SetShowqDisabled(true)
+
+	ex.StartMetricCollection(context.Background(), "postfix")
+
+	expected := `
+# HELP postfix_up Whether scraping Postfix's metrics was successful.
+# TYPE postfix_up gauge
+postfix_up{name="postfix"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(ex, strings.NewReader(expected), "postfix_up"))
+}
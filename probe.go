@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// A showqProbe collects the mail queue metrics of a showq service on a
+// remote machine, forwarded over TCP.
+type showqProbe struct {
+	ctx      context.Context
+	e        *PostfixExporter
+	target   string // host:port
+	instance string
+}
+
+// Describe sends nothing, the metrics depend on the target.
+func (p *showqProbe) Describe(chan<- *prometheus.Desc) {}
+
+// Collect reads the mail queue of the target.
+func (p *showqProbe) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.e.showqTimeout)
+	defer cancel()
+
+	// Only the exporter's own instances are counted as scanned, and
+	// their parse errors.
+	opts := p.e.showqOptions
+	opts.Scanned, opts.ParseErrors = nil, nil
+
+	up := 1.0
+	if err := CollectShowqFromAddress(ctx, "tcp", p.target, p.instance, opts, ch); err != nil {
+		log.Printf("Failed to probe showq at %s: %s", p.target, err)
+		up = 0
+	}
+	ch <- prometheus.MustNewConstMetric(postfixUpDesc, prometheus.GaugeValue, up, p.instance)
+}
+
+// ProbeHandler serves the mail queue metrics of the showq service given
+// by the "target" (host:port) query parameter, labeled by the "instance"
+// parameter (default "postfix"). This allows one exporter to report the
+// mail queues of several mail servers.
+func (e *PostfixExporter) ProbeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		target := params.Get("target")
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			http.Error(w, "invalid or missing target parameter, expected host:port", http.StatusBadRequest)
+
+			return
+		}
+		instance := params.Get("instance")
+		if instance == "" {
+			instance = defaultInstance
+		}
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(&showqProbe{ctx: r.Context(), e: e, target: target, instance: instance})
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostfixExporter_ProbeHandler(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(conn, "queue_name\x00deferred\x00queue_id\x00A07A81514\x00time\x00%d\x00size\x001000\x00\x00", time.Now().Unix())
+			conn.Close()
+		}
+	}()
+
+	e, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	srv := httptest.NewServer(e.ProbeHandler())
+	t.Cleanup(srv.Close)
+
+	get := func(query string) (int, string) {
+		resp, err := http.Get(srv.URL + "?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("target=" + l.Addr().String() + "&instance=mail1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `postfix_showq_queue_messages{name="mail1",queue="deferred"} 1`)
+	assert.Contains(t, body, `postfix_up{name="mail1"} 1`)
+	assert.NotContains(t, body, "postfix_smtpd", "Log metrics should not be probed.")
+
+	code, body = get("target=127.0.0.1:1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `postfix_up{name="postfix"} 0`)
+
+	code, _ = get("instance=mail1")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestPostfixExporter_ProbeParseErrors(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(conn, "queue_name\x00deferred\x00time\x00%d\x00size\x00garbage\x00\x00", time.Now().Unix())
+			conn.Close()
+		}
+	}()
+
+	e, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	srv := httptest.NewServer(e.ProbeHandler())
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "?target=" + l.Addr().String() + "&instance=mail1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, testutil.CollectAndCount(e.showqParseErrors), "Parse errors of probes should not be counted.")
+}
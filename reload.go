@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/digineo/postfix_exporter/logsource"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A reloader applies the --config.file to a running exporter again, on
+// SIGHUP and on POST /-/reload: the instances (with their labels) and
+// custom metrics of the config are replaced, the syslog names of the
+// instances are looked up again if enabled, and the log source is
+// reopened, retrying in the background if that fails. The flags and
+// buckets of the config require a restart.
+type reloader struct {
+	mu   sync.Mutex
+	ctx  context.Context // of the exporter
+	e    *PostfixExporter
+	path string  // of the config file
+	cfg  *config // as loaded at startup, nil without config file
+	reg  prometheus.Registerer
+
+	labels *instanceLabelsGatherer // of the instances in the config, if any
+
+	// The settings of the command line, or the config at startup.
+	instances  []string
+	showqPaths []string // values of --postfix.showq-path
+
+	resolveShowqPaths func(ctx context.Context, instances, values []string) (map[string]string, error)
+	lookupSyslogNames func(ctx context.Context, instances []string) (map[string]string, error) // nil if disabled
+	openLogSource     func(ctx context.Context) (logsource.LogSourceCloser, prometheus.Collector, error)
+
+	logSrc       logsource.LogSourceCloser // nil if reopening it failed
+	srcCollector prometheus.Collector      // of logSrc, if it provides metrics about itself
+	cancel       context.CancelFunc        // of the log collection
+	wg           sync.WaitGroup
+}
+
+// start starts collecting the log lines of all instances.
+func (r *reloader) start() {
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(r.ctx)
+	r.collect(ctx)
+}
+
+// collect collects the log lines of all instances, until `ctx` is done.
+func (r *reloader) collect(ctx context.Context) {
+	for _, instance := range r.e.instances {
+		r.wg.Add(1)
+		go func(instance string) {
+			defer r.wg.Done()
+			r.e.StartMetricCollection(ctx, instance)
+		}(instance)
+	}
+}
+
+// retryOpen reopens the log source in the background, with backoff,
+// and starts collecting the log lines of all instances once it succeeds.
+// It is stopped like the log collection.
+func (r *reloader) retryOpen() {
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(r.ctx)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		b := backoff{delay: minBackoff} // the first attempt just failed
+		for {
+			if err := b.Wait(ctx); err != nil {
+				return
+			}
+			src, collector, err := r.openLogSource(ctx)
+			if err != nil {
+				log.Printf("Failed to reopen log source: %s", err)
+
+				continue
+			}
+			if ctx.Err() != nil {
+				_ = src.Close()
+
+				return
+			}
+
+			log.Printf("Reopened log source")
+			r.setLogSource(src, collector)
+			r.e.setLogSource(src)
+			r.collect(ctx)
+
+			return
+		}
+	}()
+}
+
+// setLogSource sets the log source, and registers the metrics it
+// provides about itself, if any.
+func (r *reloader) setLogSource(src logsource.LogSourceCloser, collector prometheus.Collector) {
+	if collector != nil {
+		if err := r.reg.Register(collector); err != nil {
+			log.Printf("Failed to register the metrics of the log source: %s", err)
+		}
+	}
+	r.logSrc, r.srcCollector = src, collector
+}
+
+// stop stops collecting log lines and closes the log source.
+func (r *reloader) stop() {
+	r.cancel()
+	r.wg.Wait()
+	if r.logSrc == nil {
+		return
+	}
+	if r.srcCollector != nil {
+		r.reg.Unregister(r.srcCollector)
+	}
+	if err := r.logSrc.Close(); err != nil {
+		log.Printf("Error closing log source: %s", err)
+	}
+	r.logSrc, r.srcCollector = nil, nil
+}
+
+// Reload reads the config file again and reopens the log source. The
+// exporter is left unchanged if the config file is invalid.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	instances, showqPaths, cm := r.instances, r.showqPaths, r.e.customMetrics
+	var labels map[string]map[string]string
+	if r.cfg != nil {
+		cfg, err := loadConfig(r.path)
+		if err != nil {
+			return err
+		}
+		names, paths := cfg.instanceArgs()
+		if len(names) > 0 && !r.cfg.given["postfix.instance"] {
+			instances = names
+		}
+		if len(names) > 0 && !r.cfg.given["postfix.showq-path"] {
+			showqPaths = paths
+		}
+		if cm, err = newCustomMetrics(cfg.CustomMetrics); err != nil {
+			return fmt.Errorf("%s: %w", r.path, err)
+		}
+		if err := r.e.checkCustomMetrics(cm); err != nil {
+			return fmt.Errorf("%s: %w", r.path, err)
+		}
+		labels = cfg.instanceLabels()
+	}
+	paths, err := r.resolveShowqPaths(r.ctx, instances, showqPaths)
+	if err != nil {
+		return err
+	}
+	var syslogNames map[string]string
+	if r.lookupSyslogNames != nil {
+		if syslogNames, err = r.lookupSyslogNames(r.ctx, instances); err != nil {
+			return err
+		}
+	}
+
+	if r.labels != nil {
+		r.labels.SetLabels(labels)
+	}
+	r.stop()
+	src, collector, err := r.openLogSource(r.ctx)
+	if err != nil {
+		// Keep the closed log source until reopening it succeeds, so
+		// the metrics read so far are still exported.
+		r.e.Reload(instances, paths, syslogNames, cm, r.e.logSrc)
+		for _, instance := range instances {
+			r.e.setLogUp(instance, false)
+		}
+		r.retryOpen()
+
+		return fmt.Errorf("reopening log source: %w", err)
+	}
+	r.setLogSource(src, collector)
+	r.e.Reload(instances, paths, syslogNames, cm, src)
+	r.start()
+
+	return nil
+}
+
+// reload reloads and logs the result.
+func (r *reloader) reload(cause string) error {
+	log.Printf("Reloading (%s)", cause)
+	err := r.Reload()
+	if err != nil {
+		log.Printf("Failed to reload: %s", err)
+	}
+
+	return err
+}
+
+// WatchSignals reloads on SIGHUP, until `ctx` is done.
+func (r *reloader) WatchSignals(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			_ = r.reload("SIGHUP")
+		}
+	}
+}
+
+// Handler serves /-/reload, which requires POST requests with
+// `token` as bearer token.
+func (r *reloader) Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+			http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+
+			return
+		}
+		if err := r.reload("HTTP request"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digineo/postfix_exporter/logsource"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chanSource is a log source returning the lines sent on a channel.
+type chanSource chan string
+
+func (chanSource) Path() string { return "chan" }
+
+func (s chanSource) Read(ctx context.Context) (string, error) {
+	select {
+	case line := <-s:
+		return line, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (chanSource) Close() error { return nil }
+
+func TestReloader(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	writeConfig(`
+instances: [{name: postfix}]
+custom_metrics: [{name: foo_total, match: foo}]
+`)
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+
+	src := make(chanSource)
+	e, err := NewPostfixExporter([]string{"postfix"}, src, false)
+	require.NoError(t, err)
+	e.SetShowqDisabled(true)
+	cm, err := newCustomMetrics(cfg.CustomMetrics)
+	require.NoError(t, err)
+	e.SetCustomMetrics(cm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opened := 0
+	r := &reloader{
+		ctx:       ctx,
+		e:         e,
+		path:      path,
+		cfg:       cfg,
+		reg:       prometheus.NewRegistry(),
+		instances: []string{"postfix"},
+		resolveShowqPaths: func(_ context.Context, _, values []string) (map[string]string, error) {
+			return parseShowqPaths(values)
+		},
+		lookupSyslogNames: func(_ context.Context, instances []string) (map[string]string, error) {
+			names := make(map[string]string)
+			for _, instance := range instances {
+				names[instance] = strings.TrimPrefix(instance, "postfix-")
+			}
+
+			return names, nil
+		},
+		openLogSource: func(context.Context) (logsource.LogSourceCloser, prometheus.Collector, error) {
+			opened++
+
+			return src, nil, nil
+		},
+		logSrc: src,
+		labels: newInstanceLabelsGatherer(prometheus.NewRegistry(), cfg.instanceLabels()),
+	}
+	r.start()
+
+	customValue := func(name, instance string) float64 {
+		for _, rule := range e.customMetrics.rules {
+			if rule.Name == name {
+				return testutil.ToFloat64(rule.counter.WithLabelValues(instance))
+			}
+		}
+
+		return -1
+	}
+
+	src <- "Feb 13 23:31:30 ahost postfix/smtpd[123]: foo"
+	assert.Eventually(t, func() bool { return customValue("foo_total", "postfix") == 1 }, time.Second, 10*time.Millisecond)
+
+	writeConfig(`
+instances: [{name: postfix-out, showq_path: /var/spool/postfix-out/public/showq, labels: {role: outbound}}]
+custom_metrics: [{name: foo_total, match: foo}, {name: bar_total, match: bar}]
+`)
+	require.NoError(t, r.Reload())
+	assert.Equal(t, 1, opened, "The log source should be reopened.")
+	assert.Equal(t, []string{"postfix-out"}, e.instances)
+	assert.Equal(t, "/var/spool/postfix-out/public/showq", e.showqPath("postfix-out"))
+	assert.Equal(t, map[string]map[string]string{"postfix-out": {"role": "outbound"}}, r.labels.labels)
+
+	assert.Equal(t, "out", e.syslogName("postfix-out"), "The syslog names should be looked up again.")
+
+	src <- "Feb 13 23:31:31 ahost out/smtpd[123]: foo bar"
+	assert.Eventually(t, func() bool { return customValue("bar_total", "postfix-out") == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1.0, customValue("foo_total", "postfix"), "Unchanged custom metrics should keep their values.")
+	assert.Equal(t, 1.0, customValue("foo_total", "postfix-out"))
+
+	writeConfig("custom_metrics: [{name: foo_total, type: gauge}]")
+	assert.Error(t, r.Reload())
+	assert.Equal(t, []string{"postfix-out"}, e.instances, "An invalid config should leave the exporter unchanged.")
+	assert.Equal(t, 1, opened)
+
+	writeConfig("custom_metrics: [{name: postfix_smtpd_connects_total, match: connect}]")
+	assert.Error(t, r.Reload(), "Custom metrics colliding with the exporter's should be rejected.")
+	assert.Len(t, e.customMetrics.rules, 2)
+	assert.Equal(t, 1, opened)
+}
+
+func TestReloader_RetryOpen(t *testing.T) {
+	t.Parallel()
+
+	src := make(chanSource)
+	e, err := NewPostfixExporter([]string{"postfix"}, src, false)
+	require.NoError(t, err)
+	e.SetShowqDisabled(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		failed int
+	)
+	r := &reloader{
+		ctx:       ctx,
+		e:         e,
+		reg:       prometheus.NewRegistry(),
+		instances: []string{"postfix"},
+		resolveShowqPaths: func(_ context.Context, _, values []string) (map[string]string, error) {
+			return parseShowqPaths(values)
+		},
+		openLogSource: func(context.Context) (logsource.LogSourceCloser, prometheus.Collector, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failed < 2 {
+				failed++
+
+				return nil, nil, errors.New("unreachable")
+			}
+
+			return src, nil, nil
+		},
+		logSrc: src,
+	}
+	r.start()
+
+	assert.Error(t, r.Reload())
+	e.logUpMu.Lock()
+	assert.False(t, e.logUp["postfix"])
+	e.logUpMu.Unlock()
+
+	// The second attempt fails, too.
+	select {
+	case src <- "Feb 13 23:31:30 ahost postfix/smtpd[123]: connect from unknown[192.0.2.1]":
+	case <-time.After(5 * time.Second):
+		t.Fatal("The log source should be reopened in the background.")
+	}
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(e.smtpdConnects.WithLabelValues("postfix", "smtpd")) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, r.Reload())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, failed)
+}
+
+func TestReloader_Handler(t *testing.T) {
+	t.Parallel()
+
+	e, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	r := &reloader{
+		ctx:       context.Background(),
+		e:         e,
+		reg:       prometheus.NewRegistry(),
+		instances: []string{"postfix"},
+		resolveShowqPaths: func(_ context.Context, _, values []string) (map[string]string, error) {
+			return parseShowqPaths(values)
+		},
+		openLogSource: func(context.Context) (logsource.LogSourceCloser, prometheus.Collector, error) {
+			return chanSource(nil), nil, nil
+		},
+	}
+	r.start()
+	h := r.Handler("secret")
+
+	for _, tc := range []struct {
+		method, auth string
+		status       int
+	}{
+		{http.MethodGet, "Bearer secret", http.StatusMethodNotAllowed},
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPost, "secret", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, "/-/reload", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, tc.status, w.Code, "%s with %q", tc.method, tc.auth)
+	}
+}
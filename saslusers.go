@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	saslTopUserMessagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "smtpd", "sasl_top_user_messages"),
+		"Number of messages submitted by the SASL users with the most messages within the tracking window.",
+		[]string{"name", "sasl_username"}, nil)
+	saslTopUserRecipientsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "smtpd", "sasl_top_user_recipients"),
+		"Number of recipients of messages submitted by the SASL users with the most recipients within the tracking window.",
+		[]string{"name", "sasl_username"}, nil)
+)
+
+// A saslUserKey identifies a SASL user of a Postfix instance.
+type saslUserKey struct {
+	instance, user string
+}
+
+// A saslUserEvent is a message or the recipients of a message
+// submitted by a SASL user.
+type saslUserEvent struct {
+	t                    time.Time
+	messages, recipients float64
+}
+
+// A pendingSASLMessage is a message submitted by a SASL user, waiting
+// for its number of recipients to be logged by qmgr.
+type pendingSASLMessage struct {
+	key saslUserKey
+	t   time.Time
+}
+
+// A saslUserTracker counts the messages and recipients submitted per
+// SASL user within a sliding window, and exports the top users. A
+// sudden spike from one account is a typical sign of a compromise.
+type saslUserTracker struct {
+	window time.Duration
+	topN   int
+
+	mu      sync.Mutex
+	events  map[saslUserKey][]saslUserEvent
+	pending map[string]pendingSASLMessage // by queue ID
+}
+
+func newSASLUserTracker(topN int, window time.Duration) *saslUserTracker {
+	return &saslUserTracker{
+		window:  window,
+		topN:    topN,
+		events:  make(map[saslUserKey][]saslUserEvent),
+		pending: make(map[string]pendingSASLMessage),
+	}
+}
+
+// AddMessage records a message submitted by a SASL user. Its recipients
+// are recorded by AddRecipients, once qmgr picks up the message.
+func (t *saslUserTracker) AddMessage(instance, user, queueID string) {
+	now := timeNow()
+	key := saslUserKey{instance, user}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.events[key] = append(t.events[key], saslUserEvent{t: now, messages: 1})
+	if queueID != "" {
+		t.pending[queueID] = pendingSASLMessage{key: key, t: now}
+	}
+}
+
+// AddRecipients records the number of recipients of a message, if it
+// was submitted by a SASL user.
+func (t *saslUserTracker) AddRecipients(queueID string, nrcpt float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msg, ok := t.pending[queueID]
+	if !ok {
+		return
+	}
+	delete(t.pending, queueID)
+	t.events[msg.key] = append(t.events[msg.key], saslUserEvent{t: timeNow(), recipients: nrcpt})
+}
+
+// prune drops events and pending messages older than the window. The
+// caller must hold t.mu.
+func (t *saslUserTracker) prune() {
+	cutoff := timeNow().Add(-t.window)
+
+	for key, events := range t.events {
+		i := sort.Search(len(events), func(i int) bool { return events[i].t.After(cutoff) })
+		if i == len(events) {
+			delete(t.events, key)
+		} else if i > 0 {
+			t.events[key] = append(events[:0:0], events[i:]...)
+		}
+	}
+	for id, msg := range t.pending {
+		if !msg.t.After(cutoff) {
+			delete(t.pending, id)
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (t *saslUserTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- saslTopUserMessagesDesc
+	ch <- saslTopUserRecipientsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (t *saslUserTracker) Collect(ch chan<- prometheus.Metric) {
+	type total struct {
+		key                  saslUserKey
+		messages, recipients float64
+	}
+
+	t.mu.Lock()
+	t.prune()
+	totals := make([]total, 0, len(t.events))
+	for key, events := range t.events {
+		tot := total{key: key}
+		for _, ev := range events {
+			tot.messages += ev.messages
+			tot.recipients += ev.recipients
+		}
+		totals = append(totals, tot)
+	}
+	t.mu.Unlock()
+
+	top := func(desc *prometheus.Desc, value func(total) float64) {
+		sort.Slice(totals, func(i, j int) bool {
+			if vi, vj := value(totals[i]), value(totals[j]); vi != vj {
+				return vi > vj
+			}
+
+			return totals[i].key.user < totals[j].key.user
+		})
+		for i, tot := range totals {
+			if i == t.topN || value(tot) == 0 {
+				break
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value(tot), tot.key.instance, tot.key.user)
+		}
+	}
+	top(saslTopUserMessagesDesc, func(tot total) float64 { return tot.messages })
+	top(saslTopUserRecipientsDesc, func(tot total) float64 { return tot.recipients })
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostfixExporter_SASLTopUsers(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetSASLTopUsers(1, time.Hour)
+
+	for _, line := range []string{
+		"Oct 30 13:19:26 mail postfix/submission/smtpd[27530]: EB4B2C19E2: client=unknown[192.0.2.1], sasl_method=PLAIN, sasl_username=alice@example.com",
+		"Oct 30 13:19:26 mail postfix/qmgr[8204]: EB4B2C19E2: from=<alice@example.com>, size=1234, nrcpt=50 (queue active)",
+		"Oct 30 13:19:27 mail postfix/submission/smtpd[27530]: EB4B2C19E3: client=unknown[192.0.2.2], sasl_method=PLAIN, sasl_username=bob@example.com",
+		"Oct 30 13:19:27 mail postfix/qmgr[8204]: EB4B2C19E3: from=<bob@example.com>, size=1234, nrcpt=1 (queue active)",
+		"Oct 30 13:19:28 mail postfix/submission/smtpd[27530]: EB4B2C19E4: client=unknown[192.0.2.2], sasl_method=PLAIN, sasl_username=bob@example.com",
+	} {
+		ex.CollectFromLogLine("postfix", line)
+	}
+
+	// Only the top user is exported, per metric.
+	expected := `
+# HELP postfix_smtpd_sasl_top_user_messages Number of messages submitted by the SASL users with the most messages within the tracking window.
+# TYPE postfix_smtpd_sasl_top_user_messages gauge
+postfix_smtpd_sasl_top_user_messages{name="postfix",sasl_username="bob@example.com"} 2
+# HELP postfix_smtpd_sasl_top_user_recipients Number of recipients of messages submitted by the SASL users with the most recipients within the tracking window.
+# TYPE postfix_smtpd_sasl_top_user_recipients gauge
+postfix_smtpd_sasl_top_user_recipients{name="postfix",sasl_username="alice@example.com"} 50
+`
+	assert.NoError(t, testutil.CollectAndCompare(ex.saslTopUsers, strings.NewReader(expected)))
+}
+
+func TestSASLUserTracker_Window(t *testing.T) {
+	t.Parallel()
+
+	tr := newSASLUserTracker(10, -time.Second) // everything is outside the window
+	tr.AddMessage("postfix", "alice@example.com", "EB4B2C19E2")
+	assert.Equal(t, 0, testutil.CollectAndCount(tr))
+	assert.Empty(t, tr.pending)
+}
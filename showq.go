@@ -3,19 +3,530 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net"
+	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+var (
+	showqQueueMessagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "queue_messages"),
+		"Number of messages in Postfix's message queue.",
+		[]string{"name", "queue"}, nil)
+	showqQueueSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "queue_size_bytes_total"),
+		"Total size of the messages in Postfix's message queue, in bytes.",
+		[]string{"name", "queue"}, nil)
+	showqOldestMessageAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "oldest_message_age_seconds"),
+		"Age of the oldest message in Postfix's message queue, in seconds, or 0 if the queue is empty.",
+		[]string{"name", "queue"}, nil)
+	showqQueueRecipientsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "queue_recipients"),
+		"Number of pending recipients of the messages in Postfix's message queue.",
+		[]string{"name", "queue"}, nil)
+	showqForcedExpireDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "forced_expire_messages"),
+		"Number of messages in Postfix's message queue forced to expire, which are returned to the sender at the next delivery attempt.",
+		[]string{"name", "queue"}, nil)
+	showqMailerDaemonDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "mailer_daemon_messages"),
+		"Number of messages from MAILER-DAEMON (bounces) in Postfix's message queue, which are not included in the other queue metrics.",
+		[]string{"name", "queue"}, nil)
+	showqDeferredRecipientsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "deferred_recipients"),
+		"Number of recipients in Postfix's message queue with a reason for their deferral, by reason.",
+		[]string{"name", "reason"}, nil)
+	showqSenderMessagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "sender_messages"),
+		"Number of messages in Postfix's message queue, for the envelope senders with the most.",
+		[]string{"name", "sender"}, nil)
+	showqFilesystemFallbackDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "filesystem_fallback"),
+		"Whether the queue files were counted because reading from showq failed.",
+		[]string{"name"}, nil)
+	showqTruncatedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "truncated"),
+		"Whether messages in Postfix's message queue were ignored because of --showq.max-messages.",
+		[]string{"name"}, nil)
+	showqQShapeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "qshape_messages"),
+		"Number of messages in Postfix's message queue by sender domain, or of recipients by recipient domain, and age band, like qshape.",
+		[]string{"name", "queue", "domain", "age"}, nil)
+	showqDeferredMessagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "deferred_messages"),
+		"Number of messages in Postfix's message queue with recipients deferred, by reason.",
+		[]string{"name", "reason"}, nil)
+	showqDeferredDomainRecipientsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("postfix", "showq", "deferred_domain_recipients"),
+		"Number of recipients in Postfix's deferred queue, for the recipient domains with the most.",
+		[]string{"name", "domain"}, nil)
+)
+
+// A queuedMessage is a message reported by showq.
+type queuedMessage struct {
+	queue      string
+	size       float64 // bytes
+	age        float64 // seconds
+	sender     string
+	recipients []queuedRecipient
+
+	forcedExpire bool // whether it was forced to expire with postsuper -e (Postfix 3.5 and later)
+}
+
+// hasDomain returns whether the sender or a recipient of the message has
+// a domain in `domains`, or a subdomain.
+func (m *queuedMessage) hasDomain(domains []string) bool {
+	if matchDomain(addressDomain(m.sender), domains) != "" {
+		return true
+	}
+	for _, rcpt := range m.recipients {
+		if matchDomain(addressDomain(rcpt.address), domains) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A queuedRecipient is a pending recipient of a queuedMessage.
+type queuedRecipient struct {
+	address string
+	reason  string // of its deferral, if any
+}
+
+// The queues reported by the textual and binary showq formats.
+var (
+	textualShowqQueues = []string{"active", "hold", "other"}
+	binaryShowqQueues  = []string{"active", "deferred", "hold", "incoming", "maildrop"}
+)
+
+// ShowqOptions control which metrics are derived from the mail queue.
+// The zero value exports the per-queue totals only.
+type ShowqOptions struct {
+	// DeferredDomains is the number of recipient domains with the most
+	// recipients in the deferred queue exported, if positive.
+	DeferredDomains int
+	// HashDomains replaces domain label values by a hash.
+	HashDomains bool
+	// QShape is "recipient" or "sender" to export the qshape-like
+	// matrix of messages by age and domain, if not empty.
+	QShape string
+	// QShapeDomains is the number of domains with the most messages per
+	// queue in the qshape matrix.
+	QShapeDomains int
+	// TopSenders is the number of envelope senders with the most
+	// messages in the queue exported, if positive.
+	TopSenders int
+	// Domains limits the queue metrics to messages with a sender or
+	// recipient domain in it (including subdomains), if not empty.
+	Domains []string
+	// ExcludeDomains leaves messages with a sender or recipient domain
+	// in it (including subdomains) out of the queue metrics.
+	ExcludeDomains []string
+	// MailerDaemon is "exclude" to leave messages from MAILER-DAEMON
+	// (bounces) out of the queue metrics, or "separate" to count them in
+	// postfix_showq_mailer_daemon_messages instead. They are included if
+	// empty.
+	MailerDaemon string
+	// ParseErrors counts the messages which could not be parsed, by
+	// instance, if not nil.
+	ParseErrors *prometheus.CounterVec
+	// Scanned counts the messages read, by instance, if not nil. Unlike
+	// the queue metrics, it includes the messages left out by Domains,
+	// ExcludeDomains and MailerDaemon.
+	Scanned *prometheus.CounterVec
+	// NativeHistogramBucketFactor enables native histograms of the
+	// message sizes and ages with this bucket factor, in addition to the
+	// classic buckets. Disabled if 0.
+	NativeHistogramBucketFactor float64
+	// MaxMessages is the number of messages read at most, if positive.
+	// Further messages are ignored, and the listing reported as truncated.
+	MaxMessages int
+}
+
+// maxShowqDomains bounds the number of distinct domains (or senders)
+// counted per table while reading the mail queue, to keep memory bounded
+// for huge queues. Further domains are counted as "other".
+const maxShowqDomains = 10000
+
+// qshapeBands are the upper bounds of the age bands of the qshape
+// matrix in minutes, like qshape's defaults. Older messages are counted
+// in an additional band.
+var qshapeBands = []float64{5, 10, 20, 40, 80, 160, 320, 640, 1280}
+
+// qshapeQueues are the queues included in the qshape matrix. "other" is
+// the deferred queue in the textual format.
+var qshapeQueues = map[string]bool{"active": true, "deferred": true, "other": true}
+
+// showqStats aggregates the messages reported by showq into metrics.
+type showqStats struct {
+	instance string
+	opts     ShowqOptions
+
+	// Histograms tracking the messages by size and age.
+	sizeHistogram prometheus.ObserverVec
+	ageHistogram  prometheus.ObserverVec
+
+	queues           map[string]*queueStats
+	deferredReasons  map[string]float64 // number of recipients, by reason category
+	deferredMessages map[string]float64 // number of messages with recipients deferred, by reason category
+	deferredDomains  map[string]float64 // number of recipients, by domain
+	senders          map[string]float64 // number of messages, by sender
+	mailerDaemon     map[string]float64 // number of messages from MAILER-DAEMON, by queue, see ShowqOptions.MailerDaemon
+
+	qshape map[string]map[string][]float64 // counts by queue, domain and age band
+
+	messages    int  // number of messages added
+	truncated   bool // whether messages were ignored because of MaxMessages
+	parseErrors int  // number of messages which could not be parsed
+}
+
+// queueStats are the totals of a queue.
+type queueStats struct {
+	messages, bytes float64
+	recipients      float64 // may be unknown (0) for the textual format
+	forcedExpire    float64 // number of messages forced to expire
+	oldest          float64 // age in seconds
+}
+
+// newShowqStats creates the stats of an instance, with all `queues`
+// initialized to zero.
+func newShowqStats(instance string, queues []string, opts ShowqOptions) *showqStats {
+	s := &showqStats{
+		instance: instance,
+		opts:     opts,
+		sizeHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "postfix",
+			Name:      "showq_message_size_bytes",
+			Help:      "Size of messages in Postfix's message queue, in bytes",
+			Buckets:   []float64{1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9},
+
+			NativeHistogramBucketFactor: opts.NativeHistogramBucketFactor,
+		}, []string{"name", "queue"}),
+		ageHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "postfix",
+			Name:      "showq_message_age_seconds",
+			Help:      "Age of messages in Postfix's message queue, in seconds",
+			Buckets:   []float64{1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8},
+
+			NativeHistogramBucketFactor: opts.NativeHistogramBucketFactor,
+		}, []string{"name", "queue"}),
+		queues:           make(map[string]*queueStats, len(queues)),
+		deferredReasons:  make(map[string]float64),
+		deferredMessages: make(map[string]float64),
+		deferredDomains:  make(map[string]float64),
+		senders:          make(map[string]float64),
+		mailerDaemon:     make(map[string]float64),
+		qshape:           make(map[string]map[string][]float64),
+	}
+	for _, q := range queues {
+		s.sizeHistogram.WithLabelValues(instance, q)
+		s.ageHistogram.WithLabelValues(instance, q)
+		s.queues[q] = &queueStats{}
+	}
+
+	return s
+}
+
+// add records a message.
+func (s *showqStats) add(msg *queuedMessage) {
+	if s.opts.MaxMessages > 0 && s.messages >= s.opts.MaxMessages {
+		s.truncated = true
+
+		return
+	}
+	s.messages++
+	if s.opts.Scanned != nil {
+		s.opts.Scanned.WithLabelValues(s.instance).Inc()
+	}
+
+	if len(s.opts.Domains) > 0 && !msg.hasDomain(s.opts.Domains) {
+		return
+	}
+	if len(s.opts.ExcludeDomains) > 0 && msg.hasDomain(s.opts.ExcludeDomains) {
+		return
+	}
+	if s.opts.MailerDaemon != "" && isMailerDaemon(msg.sender) {
+		if s.opts.MailerDaemon == "separate" {
+			s.mailerDaemon[msg.queue]++
+			if s.queues[msg.queue] == nil {
+				s.queues[msg.queue] = &queueStats{}
+			}
+		}
+
+		return
+	}
+
+	q := s.queues[msg.queue]
+	if q == nil {
+		q = &queueStats{}
+		s.queues[msg.queue] = q
+	}
+	q.messages++
+	q.bytes += msg.size
+	q.recipients += float64(len(msg.recipients))
+	if msg.forcedExpire {
+		q.forcedExpire++
+	}
+	if msg.age > q.oldest {
+		q.oldest = msg.age
+	}
+	var reasons map[string]bool // of the message
+	for _, rcpt := range msg.recipients {
+		if rcpt.reason != "" {
+			reason := classifyDeferredReason(strings.TrimPrefix(rcpt.reason, "delivery temporarily suspended: "))
+			s.deferredReasons[reason]++
+			if reasons == nil {
+				reasons = make(map[string]bool)
+			}
+			if !reasons[reason] {
+				reasons[reason] = true
+				s.deferredMessages[reason]++
+			}
+		}
+		if msg.queue == "deferred" && s.opts.DeferredDomains > 0 {
+			domain := addressDomain(rcpt.address)
+			if _, ok := s.deferredDomains[domain]; !ok && len(s.deferredDomains) >= maxShowqDomains {
+				domain = otherLabelValue
+			}
+			s.deferredDomains[domain]++
+		}
+	}
+
+	if s.opts.QShape != "" && qshapeQueues[msg.queue] {
+		s.addQShape(msg)
+	}
+	if s.opts.TopSenders > 0 {
+		sender := strings.ToLower(msg.sender)
+		if isMailerDaemon(sender) {
+			sender = "MAILER-DAEMON" // the null sender, as in the textual format
+		}
+		if _, ok := s.senders[sender]; !ok && len(s.senders) >= maxShowqDomains {
+			sender = otherLabelValue
+		}
+		s.senders[sender]++
+	}
+
+	s.sizeHistogram.WithLabelValues(s.instance, msg.queue).Observe(msg.size)
+	s.ageHistogram.WithLabelValues(s.instance, msg.queue).Observe(msg.age)
+}
+
+// parseError records a message which could not be parsed, and is
+// skipped. Only the first error is logged, to not flood the log with
+// garbage queues.
+func (s *showqStats) parseError(err error) {
+	if s.parseErrors == 0 {
+		log.Printf("Skipping malformed messages in the mail queue of %s: %v", s.instance, err)
+	}
+	s.parseErrors++
+	if s.opts.ParseErrors != nil {
+		s.opts.ParseErrors.WithLabelValues(s.instance).Inc()
+	}
+}
+
+// addQShape counts a message in the qshape matrix, once by its sender
+// domain, or once for each recipient by its domain.
+func (s *showqStats) addQShape(msg *queuedMessage) {
+	band := sort.SearchFloat64s(qshapeBands, msg.age/60)
+	domains := s.qshape[msg.queue]
+	if domains == nil {
+		domains = make(map[string][]float64)
+		s.qshape[msg.queue] = domains
+	}
+	count := func(domain string) {
+		row := domains[domain]
+		if row == nil && len(domains) >= maxShowqDomains {
+			domain, row = otherLabelValue, domains[otherLabelValue]
+		}
+		if row == nil {
+			row = make([]float64, len(qshapeBands)+1)
+			domains[domain] = row
+		}
+		row[band]++
+	}
+
+	if s.opts.QShape == "sender" {
+		domain := addressDomain(msg.sender)
+		if domain == "" {
+			domain = msg.sender // e.g. MAILER-DAEMON
+		}
+		count(domain)
+
+		return
+	}
+	for _, rcpt := range msg.recipients {
+		count(addressDomain(rcpt.address))
+	}
+}
+
+// collectQShape sends the qshape matrix, with the domains with the most
+// messages per queue, and the others summed up as "other".
+func (s *showqStats) collectQShape(ch chan<- prometheus.Metric) {
+	n := s.opts.QShapeDomains
+	for queue, domains := range s.qshape {
+		totals := make(map[string]float64, len(domains))
+		for domain, row := range domains {
+			for _, c := range row {
+				totals[domain] += c
+			}
+		}
+
+		top := topCounts(totals, n)
+		rows := make(map[string][]float64, len(top))
+		var other []float64
+		for _, kc := range top {
+			if kc.key != otherLabelValue {
+				rows[kc.key] = domains[kc.key]
+			}
+		}
+		if len(top) > 0 && top[len(top)-1].key == otherLabelValue {
+			other = make([]float64, len(qshapeBands)+1)
+			for domain, row := range domains {
+				if _, ok := rows[domain]; ok {
+					continue
+				}
+				for i, c := range row {
+					other[i] += c
+				}
+			}
+		}
+
+		send := func(domain string, row []float64) {
+			for i, c := range row {
+				age := "older"
+				if i < len(qshapeBands) {
+					age = strconv.FormatFloat(qshapeBands[i], 'f', -1, 64) + "m"
+				}
+				ch <- prometheus.MustNewConstMetric(showqQShapeDesc, prometheus.GaugeValue, c, s.instance, queue, domain, age)
+			}
+		}
+		for domain, row := range rows {
+			if s.opts.HashDomains {
+				domain = hashDomain(domain)
+			}
+			send(domain, row)
+		}
+		if other != nil {
+			send(otherLabelValue, other)
+		}
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (s *showqStats) Collect(ch chan<- prometheus.Metric) {
+	s.sizeHistogram.Collect(ch)
+	s.ageHistogram.Collect(ch)
+	for name, q := range s.queues {
+		ch <- prometheus.MustNewConstMetric(showqQueueMessagesDesc, prometheus.GaugeValue, q.messages, s.instance, name)
+		ch <- prometheus.MustNewConstMetric(showqQueueSizeDesc, prometheus.GaugeValue, q.bytes, s.instance, name)
+		ch <- prometheus.MustNewConstMetric(showqOldestMessageAgeDesc, prometheus.GaugeValue, q.oldest, s.instance, name)
+		ch <- prometheus.MustNewConstMetric(showqQueueRecipientsDesc, prometheus.GaugeValue, q.recipients, s.instance, name)
+		ch <- prometheus.MustNewConstMetric(showqForcedExpireDesc, prometheus.GaugeValue, q.forcedExpire, s.instance, name)
+		if s.opts.MailerDaemon == "separate" {
+			ch <- prometheus.MustNewConstMetric(showqMailerDaemonDesc, prometheus.GaugeValue, s.mailerDaemon[name], s.instance, name)
+		}
+	}
+	for reason, n := range s.deferredReasons {
+		ch <- prometheus.MustNewConstMetric(showqDeferredRecipientsDesc, prometheus.GaugeValue, n, s.instance, reason)
+	}
+	for reason, n := range s.deferredMessages {
+		ch <- prometheus.MustNewConstMetric(showqDeferredMessagesDesc, prometheus.GaugeValue, n, s.instance, reason)
+	}
+	s.collectQShape(ch)
+	for _, kc := range topCounts(s.senders, s.opts.TopSenders) {
+		sender := kc.key
+		if s.opts.HashDomains && sender != otherLabelValue {
+			sender = hashDomain(sender)
+		}
+		ch <- prometheus.MustNewConstMetric(showqSenderMessagesDesc, prometheus.GaugeValue, kc.count, s.instance, sender)
+	}
+	truncated := 0.0
+	if s.truncated {
+		truncated = 1
+	}
+	ch <- prometheus.MustNewConstMetric(showqTruncatedDesc, prometheus.GaugeValue, truncated, s.instance)
+	for _, d := range topCounts(s.deferredDomains, s.opts.DeferredDomains) {
+		domain := d.key
+		if s.opts.HashDomains && domain != otherLabelValue {
+			domain = hashDomain(domain)
+		}
+		ch <- prometheus.MustNewConstMetric(showqDeferredDomainRecipientsDesc, prometheus.GaugeValue, d.count, s.instance, domain)
+	}
+}
+
+// isMailerDaemon returns whether `sender` is the null sender of bounces,
+// shown as MAILER-DAEMON in the textual format.
+func isMailerDaemon(sender string) bool {
+	return sender == "" || strings.EqualFold(sender, "MAILER-DAEMON")
+}
+
+// addressDomain returns the lower-cased domain of an email address, or
+// the empty string if it has none.
+func addressDomain(address string) string {
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		return ""
+	}
+
+	return strings.ToLower(address[i+1:])
+}
+
+// A keyCount is an entry of a table of counts.
+type keyCount struct {
+	key   string
+	count float64
+}
+
+// topCounts returns the `n` entries of `counts` with the highest counts,
+// ordered by count and key, and the sum of all others (including an
+// entry "other") as "other".
+func topCounts(counts map[string]float64, n int) []keyCount {
+	if n <= 0 {
+		return nil
+	}
+
+	all := make([]keyCount, 0, len(counts))
+	for k, c := range counts {
+		if k != otherLabelValue {
+			all = append(all, keyCount{k, c})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].count != all[j].count {
+			return all[i].count > all[j].count
+		}
+
+		return all[i].key < all[j].key
+	})
+	c, ok := counts[otherLabelValue]
+	if len(all) <= n && !ok {
+		return all
+	}
+	if len(all) > n {
+		for _, kc := range all[n:] {
+			c += kc.count
+		}
+		all = all[:n:n]
+	}
+
+	return append(all, keyCount{otherLabelValue, c})
+}
+
 // CollectShowqFromReader parses the output of Postfix's 'showq' command
 // and turns it into metrics.
 //
@@ -23,52 +534,37 @@ import (
 // used. Postfix 2.x uses a textual format, identical to the output of
 // the 'mailq' command. Postfix 3.x uses a binary format, where entries
 // are terminated using null bytes. Auto-detect the format by scanning
-// for null bytes in the first 128 bytes of output.
-func CollectShowqFromReader(file io.Reader, instance string, ch chan<- prometheus.Metric) error {
+// for null bytes in the first 128 bytes of output. The JSON output of
+// 'postqueue -j', with one object per message, is detected by its
+// leading brace.
+func CollectShowqFromReader(file io.Reader, instance string, opts ShowqOptions, ch chan<- prometheus.Metric) error {
 	reader := bufio.NewReader(file)
 	buf, err := reader.Peek(128)
 	if err != nil && err != io.EOF {
 		log.Printf("Could not read postfix output, %v", err)
 	}
 	if bytes.IndexByte(buf, 0) >= 0 {
-		return CollectBinaryShowqFromReader(reader, instance, ch)
+		return CollectBinaryShowqFromReader(reader, instance, opts, ch)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte("{")) {
+		return CollectPostqueueJSONFromReader(reader, instance, opts, ch)
 	}
 
-	return CollectTextualShowqFromReader(reader, instance, ch)
+	return CollectTextualShowqFromReader(reader, instance, opts, ch)
 }
 
 // CollectTextualShowqFromReader parses Postfix's textual showq output.
-func CollectTextualShowqFromReader(file io.Reader, instance string, ch chan<- prometheus.Metric) error {
-	// Histograms tracking the messages by size and age.
-	sizeHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "postfix",
-		Name:      "showq_message_size_bytes",
-		Help:      "Size of messages in Postfix's message queue, in bytes",
-		Buckets:   []float64{1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9},
-	}, []string{"name", "queue"})
-	ageHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "postfix",
-		Name:      "showq_message_age_seconds",
-		Help:      "Age of messages in Postfix's message queue, in seconds",
-		Buckets:   []float64{1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8},
-	}, []string{"name", "queue"})
-
-	err := CollectTextualShowqFromScanner(sizeHistogram, ageHistogram, file, instance)
-
-	sizeHistogram.Collect(ch)
-	ageHistogram.Collect(ch)
+func CollectTextualShowqFromReader(file io.Reader, instance string, opts ShowqOptions, ch chan<- prometheus.Metric) error {
+	stats := newShowqStats(instance, textualShowqQueues, opts)
+	err := CollectTextualShowqFromScanner(stats, file)
+	stats.Collect(ch)
 
 	return err
 }
 
-func CollectTextualShowqFromScanner(sizeHistogram prometheus.ObserverVec, ageHistogram prometheus.ObserverVec, file io.Reader, instance string) error {
+func CollectTextualShowqFromScanner(stats *showqStats, file io.Reader) error {
 	scanner := bufio.NewScanner(file)
 	scanner.Split(bufio.ScanLines)
-	// Initialize all queue buckets to zero.
-	for _, q := range []string{"active", "hold", "other"} {
-		sizeHistogram.WithLabelValues(instance, q)
-		ageHistogram.WithLabelValues(instance, q)
-	}
 
 	location, err := time.LoadLocation("Local")
 	if err != nil {
@@ -77,14 +573,60 @@ func CollectTextualShowqFromScanner(sizeHistogram prometheus.ObserverVec, ageHis
 
 	// Regular expression for matching postqueue's output. Example:
 	// "A07A81514      5156 Tue Feb 14 13:13:54  MAILER-DAEMON"
-	messageLine := regexp.MustCompile(`^[0-9A-F]+([\*!]?) +(\d+) (\w{3} \w{3} +\d+ +\d+:\d{2}:\d{2}) +`)
+	messageLine := regexp.MustCompile(`^[0-9A-F]+([\*!#]?) +(\d+) (\w{3} \w{3} +\d+ +\d+:\d{2}:\d{2}) +(.*)`)
+
+	// The message is followed by its recipients, each preceded by the
+	// reason of its deferral in parentheses, if any. Entries are
+	// separated by empty lines. Reasons may span several lines and
+	// contain parentheses themselves.
+	var (
+		msg    *queuedMessage
+		reason string
+		depth  int // of unclosed parentheses in the reason
+	)
+	flush := func() {
+		if msg != nil {
+			stats.add(msg)
+		}
+		msg, reason, depth = nil, "", 0
+	}
+	addReason := func(line string) {
+		depth += strings.Count(line, "(") - strings.Count(line, ")")
+		if depth <= 0 {
+			depth = 0
+			line = strings.TrimSuffix(line, ")")
+		}
+		if reason == "" {
+			reason = strings.TrimPrefix(line, "(")
+		} else {
+			reason += " " + line
+		}
+	}
 
 	for scanner.Scan() {
 		text := scanner.Text()
 		matches := messageLine.FindStringSubmatch(text)
 		if matches == nil {
+			line := strings.TrimSpace(text)
+			switch {
+			case msg == nil:
+			case line == "":
+				flush()
+			case depth > 0:
+				addReason(line)
+			case strings.HasPrefix(line, "("):
+				reason = ""
+				addReason(line)
+			default:
+				msg.recipients = append(msg.recipients, queuedRecipient{address: line, reason: reason})
+			}
+
 			continue
 		}
+		flush()
+		if stats.truncated {
+			return nil
+		}
 		queueMatch := matches[1]
 		sizeMatch := matches[2]
 		dateMatch := matches[3]
@@ -100,7 +642,9 @@ func CollectTextualShowqFromScanner(sizeHistogram prometheus.ObserverVec, ageHis
 		// Parse the message size.
 		size, err := strconv.ParseFloat(sizeMatch, 64)
 		if err != nil {
-			return err
+			stats.parseError(err)
+
+			continue
 		}
 
 		// Parse the message date. Unfortunately, the
@@ -109,7 +653,9 @@ func CollectTextualShowqFromScanner(sizeHistogram prometheus.ObserverVec, ageHis
 		// message date doesn't exceed time.Now().
 		date, err := time.ParseInLocation("Mon Jan 2 15:04:05", dateMatch, location)
 		if err != nil {
-			return err
+			stats.parseError(err)
+
+			continue
 		}
 		now := time.Now()
 		date = date.AddDate(now.Year(), 0, 0)
@@ -117,9 +663,9 @@ func CollectTextualShowqFromScanner(sizeHistogram prometheus.ObserverVec, ageHis
 			date = date.AddDate(-1, 0, 0)
 		}
 
-		sizeHistogram.WithLabelValues(instance, queue).Observe(size)
-		ageHistogram.WithLabelValues(instance, queue).Observe(now.Sub(date).Seconds())
+		msg = &queuedMessage{queue: queue, size: size, age: now.Sub(date).Seconds(), sender: matches[4], forcedExpire: queueMatch == "#"}
 	}
+	flush()
 
 	return scanner.Err()
 }
@@ -140,81 +686,323 @@ func ScanNullTerminatedEntries(data []byte, atEOF bool) (advance int, token []by
 }
 
 // CollectBinaryShowqFromReader parses Postfix's binary showq format.
-func CollectBinaryShowqFromReader(file io.Reader, instance string, ch chan<- prometheus.Metric) error { //nolint:funlen
+func CollectBinaryShowqFromReader(file io.Reader, instance string, opts ShowqOptions, ch chan<- prometheus.Metric) error {
+	stats := newShowqStats(instance, binaryShowqQueues, opts)
+	err := collectBinaryShowq(stats, file)
+	stats.Collect(ch)
+
+	return err
+}
+
+// collectBinaryShowq adds the messages of Postfix's binary showq format
+// to `stats`.
+func collectBinaryShowq(stats *showqStats, file io.Reader) error {
 	scanner := bufio.NewScanner(file)
 	scanner.Split(ScanNullTerminatedEntries)
 
-	// Histograms tracking the messages by size and age.
-	sizeHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "postfix",
-		Name:      "showq_message_size_bytes",
-		Help:      "Size of messages in Postfix's message queue, in bytes",
-		Buckets:   []float64{1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9},
-	}, []string{"name", "queue"})
-	ageHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "postfix",
-		Name:      "showq_message_age_seconds",
-		Help:      "Age of messages in Postfix's message queue, in seconds",
-		Buckets:   []float64{1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8},
-	}, []string{"name", "queue"})
-
-	// Initialize all queue buckets to zero.
-	for _, q := range []string{"active", "deferred", "hold", "incoming", "maildrop"} {
-		sizeHistogram.WithLabelValues(instance, q)
-		ageHistogram.WithLabelValues(instance, q)
+	now := float64(time.Now().UnixNano()) / 1e9
+
+	// The fields of the current message.
+	msg := &queuedMessage{queue: "unknown"}
+	var (
+		fields    int  // number of fields of the message
+		size, age bool // whether the fields required were seen
+		invalid   bool // whether a field could not be parsed
+	)
+	flush := func() {
+		switch {
+		case fields == 0:
+		case invalid:
+		case !size || !age:
+			stats.parseError(fmt.Errorf("incomplete showq record with %d fields", fields))
+		default:
+			stats.add(msg)
+		}
+		msg = &queuedMessage{queue: "unknown"}
+		fields, size, age, invalid = 0, false, false, false
 	}
 
-	now := float64(time.Now().UnixNano()) / 1e9
-	queue := "unknown"
 	for scanner.Scan() {
 		// Parse a key/value entry.
 		key := scanner.Text()
 		if len(key) == 0 {
 			// Empty key means a record separator.
-			queue = "unknown"
+			flush()
+			if stats.truncated {
+				return nil
+			}
 
 			continue
 		}
 		if !scanner.Scan() {
-			return fmt.Errorf("key %q does not have a value", key)
+			stats.parseError(fmt.Errorf("key %q does not have a value", key))
+
+			break
 		}
 		value := scanner.Text()
+		fields++
 
-		if key == "queue_name" {
+		switch key {
+		case "queue_name":
 			// The name of the message queue.
-			queue = value
-		} else if key == "size" {
+			msg.queue = value
+		case "size":
 			// Message size in bytes.
-			size, err := strconv.ParseFloat(value, 64)
+			v, err := strconv.ParseFloat(value, 64)
 			if err != nil {
-				return err
+				stats.parseError(err)
+				invalid = true
 			}
-			sizeHistogram.WithLabelValues(instance, queue).Observe(size)
-		} else if key == "time" {
+			msg.size, size = v, true
+		case "time":
 			// Message time as a UNIX timestamp.
 			utime, err := strconv.ParseFloat(value, 64)
 			if err != nil {
-				return err
+				stats.parseError(err)
+				invalid = true
+			}
+			msg.age, age = now-utime, true
+		case "forced_expire":
+			// Whether the message was forced to expire.
+			msg.forcedExpire = value != "0"
+		case "sender":
+			msg.sender = value
+		case "recipient":
+			msg.recipients = append(msg.recipients, queuedRecipient{address: value})
+		case "reason":
+			// The reason of the deferral of the preceding recipient.
+			if n := len(msg.recipients); n > 0 {
+				msg.recipients[n-1].reason = value
 			}
-			ageHistogram.WithLabelValues(instance, queue).Observe(now - utime)
 		}
 	}
+	flush()
+
+	return scanner.Err()
+}
+
+// A postqueueMessage is a message in the output of 'postqueue -j'.
+type postqueueMessage struct {
+	QueueName    string  `json:"queue_name"`
+	ArrivalTime  float64 `json:"arrival_time"`
+	MessageSize  float64 `json:"message_size"`
+	Sender       string  `json:"sender"`
+	ForcedExpire bool    `json:"forced_expire"`
+	Recipients   []struct {
+		Address     string `json:"address"`
+		DelayReason string `json:"delay_reason"`
+	} `json:"recipients"`
+}
+
+// CollectPostqueueJSONFromReader parses the output of 'postqueue -j'.
+func CollectPostqueueJSONFromReader(file io.Reader, instance string, opts ShowqOptions, ch chan<- prometheus.Metric) error {
+	stats := newShowqStats(instance, binaryShowqQueues, opts)
+	err := collectPostqueueJSON(stats, file)
+	stats.Collect(ch)
+
+	return err
+}
+
+// maxPostqueueLine is the maximum length of a message in the output of
+// 'postqueue -j', i.e. of a line.
+const maxPostqueueLine = 4 << 20
+
+// collectPostqueueJSON adds the messages of the output of 'postqueue -j'
+// to `stats`.
+func collectPostqueueJSON(stats *showqStats, file io.Reader) error {
+	now := float64(time.Now().UnixNano()) / 1e9
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxPostqueueLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var m postqueueMessage
+		if err := json.Unmarshal(line, &m); err != nil {
+			stats.parseError(err)
 
-	sizeHistogram.Collect(ch)
-	ageHistogram.Collect(ch)
+			continue
+		}
+
+		msg := &queuedMessage{
+			queue:        m.QueueName,
+			size:         m.MessageSize,
+			age:          now - m.ArrivalTime,
+			sender:       m.Sender,
+			forcedExpire: m.ForcedExpire,
+		}
+		for _, r := range m.Recipients {
+			msg.recipients = append(msg.recipients, queuedRecipient{address: r.Address, reason: r.DelayReason})
+		}
+		stats.add(msg)
+		if stats.truncated {
+			return nil
+		}
+	}
 
 	return scanner.Err()
 }
 
-// CollectShowqFromSocket collects Postfix queue statistics from a socket.
-func CollectShowqFromSocket(instance string, ch chan<- prometheus.Metric) error {
-	// TODO: the proper way would be to ask postmulti:
-	//	postmulti -i $instance -x postconf -hx queue_directory
-	fd, err := net.Dial("unix", filepath.Join("/var/spool", instance, "public/showq"))
+// postqueueCommand returns the command line listing the mail queue of
+// an instance with postqueue, in "json" (postqueue -j, Postfix 3.1 and
+// later) or "text" (postqueue -p) format. Instances other than the
+// default one are listed with postmulti. With `sudo`, the command is run
+// with non-interactive sudo, for exporters without access to the showq
+// socket.
+func postqueueCommand(instance, format string, sudo bool) []string {
+	cmd := []string{"postqueue", "-p"}
+	if format == "json" {
+		cmd[1] = "-j"
+	}
+	if instance != defaultInstance {
+		cmd = append([]string{"postmulti", "-i", instance, "-x"}, cmd...)
+	}
+	if sudo {
+		cmd = append([]string{"sudo", "-n"}, cmd...)
+	}
+
+	return cmd
+}
+
+// CollectShowqFromCommand collects Postfix queue statistics from the
+// output of postqueue, see postqueueCommand.
+func CollectShowqFromCommand(ctx context.Context, instance, format string, sudo bool, opts ShowqOptions, ch chan<- prometheus.Metric) error {
+	cmd := postqueueCommand(instance, format, sudo)
+	out, err := streamCommand(ctx, cmd[0], cmd[1:]...)
+	if err != nil {
+		return err
+	}
+
+	err = CollectShowqFromReader(out, instance, opts, ch)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// A ctxReader stops reading once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+// queueDirectories are the subdirectories of the queue_directory
+// holding the queue files of the respective queues.
+var queueDirectories = []string{"active", "deferred", "hold", "incoming", "maildrop"}
+
+// CollectQueueDirectory counts the queue files in the queue directory
+// `dir`, as a coarse replacement of showq if that fails. Only
+// postfix_showq_queue_messages is exported.
+func CollectQueueDirectory(ctx context.Context, dir, instance string, ch chan<- prometheus.Metric) error {
+	counts := make([]float64, len(queueDirectories))
+	for i, queue := range queueDirectories {
+		err := filepath.WalkDir(filepath.Join(dir, queue), func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				counts[i]++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, queue := range queueDirectories {
+		ch <- prometheus.MustNewConstMetric(showqQueueMessagesDesc, prometheus.GaugeValue, counts[i], instance, queue)
+	}
+
+	return nil
+}
+
+// defaultShowqPath returns the path of the showq socket of an instance
+// with the default queue_directory, if it could not be looked up.
+func defaultShowqPath(instance string) string {
+	return filepath.Join("/var/spool", instance, "public/showq")
+}
+
+// parseShowqPaths parses the values of --postfix.showq-path, which are
+// either "instance=path", or a path for all other instances, stored
+// with an empty key.
+func parseShowqPaths(values []string) (map[string]string, error) {
+	paths := make(map[string]string, len(values))
+	for _, v := range values {
+		instance, path := "", v
+		if i := strings.IndexByte(v, '='); i >= 0 {
+			instance, path = v[:i], v[i+1:]
+		}
+		if path == "" {
+			return nil, fmt.Errorf("empty showq path in %q", v)
+		}
+		if _, ok := paths[instance]; ok {
+			return nil, fmt.Errorf("duplicate showq path for %q", instance)
+		}
+		paths[instance] = path
+	}
+
+	return paths, nil
+}
+
+// CollectShowqFromSocket collects Postfix queue statistics from the
+// showq socket at `path`. Connecting and reading is aborted once `ctx`
+// is done. If `path` is a regular file instead, e.g. with the output of
+// 'postqueue -j' written by a cron job, it is read.
+func CollectShowqFromSocket(ctx context.Context, path, instance string, opts ShowqOptions, ch chan<- prometheus.Metric) error {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return CollectShowqFromReader(ctxReader{ctx, f}, instance, opts, ch)
+	}
+
+	return CollectShowqFromAddress(ctx, "unix", path, instance, opts, ch)
+}
+
+// CollectShowqFromAddress collects Postfix queue statistics from a showq
+// service at `address`, e.g. a showq socket forwarded over TCP with
+// socat. Connecting and reading is aborted once `ctx` is done.
+func CollectShowqFromAddress(ctx context.Context, network, address, instance string, opts ShowqOptions, ch chan<- prometheus.Metric) error {
+	var d net.Dialer
+	fd, err := d.DialContext(ctx, network, address)
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
 
-	return CollectShowqFromReader(fd, instance, ch)
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := fd.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	// Unblock reading if ctx is canceled before its deadline.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = fd.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	return CollectShowqFromReader(fd, instance, opts, ch)
 }
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/digineo/postfix_exporter/mock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCollectShowqFromReader(t *testing.T) {
@@ -21,9 +31,585 @@ func TestCollectShowqFromReader(t *testing.T) {
 
 	sizeHistogram := mock.NewHistogramVecMock()
 	ageHistogram := mock.NewHistogramVecMock()
-	if err := CollectTextualShowqFromScanner(sizeHistogram, ageHistogram, file, "postfix"); err != nil {
+	stats := newShowqStats("postfix", textualShowqQueues, ShowqOptions{})
+	stats.sizeHistogram, stats.ageHistogram = sizeHistogram, ageHistogram
+	if err := CollectTextualShowqFromScanner(stats, file); err != nil {
 		t.Errorf("CollectShowqFromReader() error = %v", err)
 	}
 	assert.Equal(t, expectedTotalCount, sizeHistogram.GetSum(), "Expected a lot more data.")
 	assert.Less(t, expectedMaxAge, ageHistogram.GetSum(), "Age not greater than 0")
+	assert.Equal(t, map[string]float64{"connection_refused": 1, "connection_timed_out": 1, "remote_4xx": 6}, stats.deferredReasons)
+	assert.Equal(t, map[string]float64{"connection_refused": 1, "connection_timed_out": 1, "remote_4xx": 6}, stats.deferredMessages)
+}
+
+func TestParseShowqPaths(t *testing.T) {
+	t.Parallel()
+
+	paths, err := parseShowqPaths([]string{"postfix-out=/mnt/out/public/showq", "/mnt/spool/public/showq"})
+	require.NoError(t, err)
+
+	ex, err := NewPostfixExporter([]string{"postfix", "postfix-out"}, nil, false)
+	require.NoError(t, err)
+	ex.SetShowqPaths(paths)
+	assert.Equal(t, "/mnt/out/public/showq", ex.showqPath("postfix-out"))
+	assert.Equal(t, "/mnt/spool/public/showq", ex.showqPath("postfix"))
+
+	ex.SetShowqPaths(nil)
+	assert.Equal(t, "/var/spool/postfix/public/showq", ex.showqPath("postfix"))
+
+	_, err = parseShowqPaths([]string{"postfix=/a", "postfix=/b"})
+	assert.Error(t, err)
+	_, err = parseShowqPaths([]string{"postfix="})
+	assert.Error(t, err)
+}
+
+func TestCollectShowqFromSocket_Timeout(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "showq")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		// Accept, but never reply, like a stalled showq.
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	ch := make(chan prometheus.Metric, 100)
+	start := time.Now()
+	err = CollectShowqFromSocket(ctx, path, "postfix", ShowqOptions{}, ch)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "Reading should be aborted at the deadline.")
+}
+
+func TestCollectBinaryShowq(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Unix()
+	showq := fmt.Sprintf("queue_name\x00deferred\x00queue_id\x00A07A81514\x00time\x00%d\x00size\x001000\x00\x00", now-7200) +
+		fmt.Sprintf("queue_name\x00deferred\x00queue_id\x00B18B92625\x00time\x00%d\x00size\x00500\x00\x00", now-60) +
+		fmt.Sprintf("queue_name\x00hold\x00queue_id\x00C29CA3736\x00time\x00%d\x00size\x00200\x00\x00", now)
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{})
+	require.NoError(t, collectBinaryShowq(stats, strings.NewReader(showq)))
+	assert.Equal(t, 2.0, stats.queues["deferred"].messages)
+	assert.Equal(t, 1500.0, stats.queues["deferred"].bytes)
+	assert.InDelta(t, 7200, stats.queues["deferred"].oldest, 5)
+	assert.Equal(t, 1.0, stats.queues["hold"].messages)
+	assert.Equal(t, 200.0, stats.queues["hold"].bytes)
+	assert.Equal(t, queueStats{}, *stats.queues["active"], "Empty queues should be reported.")
+}
+
+func TestCollectPostqueueJSON(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Unix()
+	postqueue := fmt.Sprintf(`{"queue_name": "deferred", "queue_id": "A07A81514", "arrival_time": %d, "message_size": 1000, "forced_expire": false, "sender": "alice@example.com", "recipients": [{"address": "bob@example.net", "delay_reason": "connect to mx.example.net[192.0.2.1]:25: Connection timed out"}, {"address": "carol@example.org", "delay_reason": "host mx.example.org[192.0.2.2] said: 451 4.7.1 Try again later (in reply to RCPT TO command)"}]}`+"\n", now-7200) +
+		fmt.Sprintf(`{"queue_name": "active", "queue_id": "B18B92625", "arrival_time": %d, "message_size": 500, "forced_expire": false, "sender": "alice@example.com", "recipients": [{"address": "dave@example.com"}]}`+"\n", now)
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{})
+	require.NoError(t, collectPostqueueJSON(stats, strings.NewReader(postqueue)))
+	assert.Equal(t, 1.0, stats.queues["deferred"].messages)
+	assert.Equal(t, 1000.0, stats.queues["deferred"].bytes)
+	assert.Equal(t, 2.0, stats.queues["deferred"].recipients)
+	assert.InDelta(t, 7200, stats.queues["deferred"].oldest, 5)
+	assert.Equal(t, 1.0, stats.queues["active"].recipients)
+	assert.Equal(t, map[string]float64{"connection_timed_out": 1, "remote_4xx_policy": 1}, stats.deferredReasons)
+}
+
+func TestCollectShowqFromSocket_File(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "postqueue.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"queue_name": "hold", "queue_id": "C29CA3736", "arrival_time": 0, "message_size": 200, "recipients": []}`+"\n"), 0o644))
+
+	ch := make(chan prometheus.Metric, 100)
+	require.NoError(t, CollectShowqFromSocket(context.Background(), path, "postfix", ShowqOptions{}, ch))
+	close(ch)
+
+	var messages int
+	for m := range ch {
+		if strings.Contains(m.Desc().String(), `"postfix_showq_queue_messages"`) {
+			messages++
+		}
+	}
+	assert.Equal(t, len(binaryShowqQueues), messages)
+}
+
+func TestPostqueueCommand(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"postqueue", "-j"}, postqueueCommand("postfix", "json", false))
+	assert.Equal(t, []string{"postqueue", "-p"}, postqueueCommand("postfix", "text", false))
+	assert.Equal(t, []string{"sudo", "-n", "postmulti", "-i", "postfix-out", "-x", "postqueue", "-j"}, postqueueCommand("postfix-out", "json", true))
+}
+
+func TestCollectShowqFromCommand(t *testing.T) {
+	fakePostconf(t, map[string]string{
+		"postmulti -i postfix-out -x postqueue -j": `{"queue_name": "deferred", "queue_id": "A07A81514", "arrival_time": 0, "message_size": 1000, "recipients": [{"address": "bob@example.net", "delay_reason": "connect to mx.example.net[192.0.2.1]:25: Connection refused"}]}` + "\n",
+	})
+
+	ch := make(chan prometheus.Metric, 100)
+	require.NoError(t, CollectShowqFromCommand(context.Background(), "postfix-out", "json", false, ShowqOptions{}, ch))
+	close(ch)
+
+	var reasons []string
+	for m := range ch {
+		if strings.Contains(m.Desc().String(), `"postfix_showq_deferred_recipients"`) {
+			var pb dto.Metric
+			require.NoError(t, m.Write(&pb))
+			for _, l := range pb.GetLabel() {
+				if l.GetName() == "reason" {
+					reasons = append(reasons, l.GetValue())
+				}
+			}
+		}
+	}
+	assert.Equal(t, []string{"connection_refused"}, reasons)
+}
+
+func TestPostfixExporter_ShowqSelfMetrics(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "postqueue.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"queue_name": "hold", "queue_id": "C29CA3736", "arrival_time": 0, "message_size": 200, "recipients": []}`+"\n"+
+		`{"queue_name": "deferred", "queue_id": "D3ADB4847", "arrival_time": 0, "message_size": 300, "recipients": []}`+"\n"), 0o644))
+
+	e, err := NewPostfixExporter([]string{"postfix", "postfix-out"}, nil, false)
+	require.NoError(t, err)
+	e.SetShowqPaths(map[string]string{"postfix": path, "postfix-out": filepath.Join(t.TempDir(), "missing")})
+	e.SetShowqDomains([]string{"example.com"}, nil)
+
+	ch := make(chan prometheus.Metric, 1000)
+	e.Collect(ch)
+	close(ch)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(e.showqMessagesScanned.WithLabelValues("postfix")), "Messages left out by the domain filter should be counted.")
+	assert.Equal(t, 0.0, testutil.ToFloat64(e.showqScrapeErrors.WithLabelValues("postfix")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.showqScrapeErrors.WithLabelValues("postfix-out")))
+	assert.Equal(t, 2, testutil.CollectAndCount(e.showqScrapeDuration))
+}
+
+func TestShowqStats_DeferredDomains(t *testing.T) {
+	t.Parallel()
+
+	rcpts := func(addresses ...string) []queuedRecipient {
+		var r []queuedRecipient
+		for _, a := range addresses {
+			r = append(r, queuedRecipient{address: a})
+		}
+
+		return r
+	}
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{DeferredDomains: 2})
+	stats.add(&queuedMessage{queue: "deferred", recipients: rcpts("a@example.com", "b@Example.com", "c@example.net")})
+	stats.add(&queuedMessage{queue: "deferred", recipients: rcpts("d@example.net", "e@example.org")})
+	stats.add(&queuedMessage{queue: "deferred", recipients: rcpts("f@example.info")})
+	stats.add(&queuedMessage{queue: "active", recipients: rcpts("g@example.org", "h@example.org")})
+
+	assert.Equal(t, []keyCount{{"example.com", 2}, {"example.net", 2}, {"other", 2}}, topCounts(stats.deferredDomains, 2))
+	assert.Nil(t, topCounts(stats.deferredDomains, 0))
+}
+
+func TestCollectTextualShowq_Reasons(t *testing.T) {
+	t.Parallel()
+
+	const mailq = `-Queue ID- --Size-- ----Arrival Time---- -Sender/Recipient-------
+A07A81514     5156 Tue Feb 14 13:13:54  sender@example.com
+(host mx.example.net[192.0.2.1] said: 450 4.7.1 Client host rejected:
+    cannot find your hostname (in reply to RCPT TO command))
+                                         a@example.net
+                                         b@example.net
+(Host or domain name not found. Name service error for name=example.org type=MX: Host not found, try again)
+                                         c@example.org
+
+B18B92625*    1000 Tue Feb 14 13:14:00  sender@example.com
+                                         d@example.com
+
+-- 6 Kbytes in 2 Requests.
+`
+
+	stats := newShowqStats("postfix", textualShowqQueues, ShowqOptions{})
+	require.NoError(t, CollectTextualShowqFromScanner(stats, strings.NewReader(mailq)))
+	assert.Equal(t, 3.0, stats.queues["other"].recipients)
+	assert.Equal(t, 1.0, stats.queues["active"].recipients)
+	assert.Equal(t, map[string]float64{"remote_4xx_policy": 2, "dns": 1}, stats.deferredReasons)
+	assert.Equal(t, map[string]float64{"remote_4xx_policy": 1, "dns": 1}, stats.deferredMessages)
+}
+
+func TestShowqStats_QShape(t *testing.T) {
+	t.Parallel()
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{QShape: "recipient", QShapeDomains: 1})
+	stats.add(&queuedMessage{queue: "deferred", age: 60, recipients: []queuedRecipient{{address: "a@example.com"}, {address: "b@example.com"}}})
+	stats.add(&queuedMessage{queue: "deferred", age: 3600, recipients: []queuedRecipient{{address: "c@example.com"}}})
+	stats.add(&queuedMessage{queue: "deferred", age: 200000, recipients: []queuedRecipient{{address: "d@example.net"}}})
+	stats.add(&queuedMessage{queue: "hold", age: 60, recipients: []queuedRecipient{{address: "e@example.org"}}})
+
+	assert.Equal(t, map[string]map[string][]float64{"deferred": {
+		"example.com": {2, 0, 0, 0, 1, 0, 0, 0, 0, 0},
+		"example.net": {0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+	}}, stats.qshape)
+
+	ch := make(chan prometheus.Metric, 100)
+	stats.collectQShape(ch)
+	close(ch)
+
+	values := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		labels := make(map[string]string)
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		values[labels["domain"]+"/"+labels["age"]] += pb.GetGauge().GetValue()
+	}
+	assert.Len(t, values, 2*(len(qshapeBands)+1))
+	assert.Equal(t, 2.0, values["example.com/5m"])
+	assert.Equal(t, 1.0, values["example.com/80m"])
+	assert.Equal(t, 1.0, values["other/older"])
+}
+
+func TestCollectBinaryShowq_MaxMessages(t *testing.T) {
+	t.Parallel()
+
+	var showq strings.Builder
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&showq, "queue_name\x00deferred\x00queue_id\x00A07A8151%d\x00time\x000\x00size\x00100\x00\x00", i)
+	}
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{MaxMessages: 3})
+	require.NoError(t, collectBinaryShowq(stats, strings.NewReader(showq.String())))
+	assert.Equal(t, 3.0, stats.queues["deferred"].messages)
+	assert.True(t, stats.truncated)
+
+	stats = newShowqStats("postfix", binaryShowqQueues, ShowqOptions{MaxMessages: 5})
+	require.NoError(t, collectBinaryShowq(stats, strings.NewReader(showq.String())))
+	assert.Equal(t, 5.0, stats.queues["deferred"].messages)
+	assert.False(t, stats.truncated)
+}
+
+func TestTopCounts_Other(t *testing.T) {
+	t.Parallel()
+
+	counts := map[string]float64{"example.com": 5, "example.net": 3, "other": 2, "example.org": 1}
+	assert.Equal(t, []keyCount{{"example.com", 5}, {"other", 6}}, topCounts(counts, 1))
+	assert.Equal(t, []keyCount{{"example.com", 5}, {"example.net", 3}, {"example.org", 1}, {"other", 2}}, topCounts(counts, 3))
+}
+
+func TestShowqStats_TopSenders(t *testing.T) {
+	t.Parallel()
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{TopSenders: 2})
+	for _, sender := range []string{"cron@example.com", "Cron@example.com", "cron@example.com", "", "MAILER-DAEMON", "alice@example.com", "bob@example.com"} {
+		stats.add(&queuedMessage{queue: "deferred", sender: sender})
+	}
+
+	assert.Equal(t, []keyCount{{"cron@example.com", 3}, {"MAILER-DAEMON", 2}, {"other", 2}}, topCounts(stats.senders, 2))
+}
+
+func TestPostfixExporter_ScrapeShowqsConcurrently(t *testing.T) {
+	t.Parallel()
+
+	// Stalled showq sockets of four instances, and a working one.
+	dir := t.TempDir()
+	instances := []string{"postfix-a", "postfix-b", "postfix-c", "postfix-d", "postfix"}
+	paths := make(map[string]string)
+	for _, instance := range instances[:4] {
+		path := filepath.Join(dir, instance)
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Close() })
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+		paths[instance] = path
+	}
+	paths["postfix"] = filepath.Join(dir, "postqueue.json")
+	require.NoError(t, os.WriteFile(paths["postfix"], []byte(`{"queue_name": "hold", "queue_id": "C29CA3736", "arrival_time": 0, "message_size": 200, "recipients": []}`+"\n"), 0o644))
+
+	e, err := NewPostfixExporter(instances, nil, false)
+	require.NoError(t, err)
+	e.SetShowqPaths(paths)
+	e.SetShowqTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	results := e.scrapeShowqs(context.Background())
+	assert.True(t, time.Since(start) < 600*time.Millisecond, "Stalled instances should be scraped concurrently.")
+	require.Len(t, results, len(instances))
+	for i := range instances[:4] {
+		assert.Error(t, results[i].err)
+	}
+	assert.NoError(t, results[4].err)
+	assert.NotEmpty(t, results[4].metrics)
+}
+
+func TestCollectShowq_ParseErrors(t *testing.T) {
+	t.Parallel()
+
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "parse_errors_total"}, []string{"name"})
+	opts := ShowqOptions{ParseErrors: errs}
+
+	now := time.Now().Unix()
+	binary := fmt.Sprintf("queue_name\x00deferred\x00time\x00%d\x00size\x00garbage\x00\x00", now) +
+		"queue_name\x00deferred\x00queue_id\x00B18B92625\x00\x00" +
+		fmt.Sprintf("queue_name\x00deferred\x00time\x00%d\x00size\x00500\x00\x00", now) +
+		"queue_name\x00"
+	stats := newShowqStats("postfix", binaryShowqQueues, opts)
+	require.NoError(t, collectBinaryShowq(stats, strings.NewReader(binary)))
+	assert.Equal(t, 1.0, stats.queues["deferred"].messages)
+	assert.Equal(t, 3, stats.parseErrors)
+
+	postqueue := `{"queue_name": "deferred", "arrival_time": 0, "message_size": 100, "recipients": []}
+{"queue_name": "deferred", "arrival_ti
+{"queue_name": "hold", "arrival_time": 0, "message_size": "large"}
+{"queue_name": "deferred", "arrival_time": 0, "message_size": 100, "recipients": []}
+`
+	stats = newShowqStats("postfix-out", binaryShowqQueues, opts)
+	require.NoError(t, collectPostqueueJSON(stats, strings.NewReader(postqueue)))
+	assert.Equal(t, 2.0, stats.queues["deferred"].messages)
+	assert.Equal(t, 2, stats.parseErrors)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(errs.WithLabelValues("postfix")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(errs.WithLabelValues("postfix-out")))
+}
+
+func TestPostfixExporter_ShowqFilesystemFallback(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, d := range append(queueDirectories, "public") {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, d), 0o700))
+	}
+	for _, f := range []string{"deferred/A/A07A81514", "deferred/B/B18B92625", "hold/C29CA3736", "incoming/D3ADB4847"} {
+		path := filepath.Join(dir, f)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+	}
+
+	e, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	e.SetShowqPaths(map[string]string{"postfix": filepath.Join(dir, "public/showq")})
+	e.SetShowqFilesystemFallback(true)
+
+	metrics, err := e.scrapeShowq(context.Background(), "postfix")
+	assert.Error(t, err, "The failure of showq should be reported.")
+
+	values := make(map[string]float64)
+	for _, m := range metrics {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		name := m.Desc().String()
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "queue" {
+				name = l.GetValue()
+			}
+		}
+		values[name] = pb.GetGauge().GetValue()
+	}
+	assert.Equal(t, 2.0, values["deferred"])
+	assert.Equal(t, 1.0, values["hold"])
+	assert.Equal(t, 1.0, values["incoming"])
+	assert.Equal(t, 0.0, values["active"])
+	assert.Equal(t, 1.0, values[showqFilesystemFallbackDesc.String()])
+}
+
+func TestPostfixExporter_ShowqFilesystemFallback_Timeout(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, d := range append(queueDirectories, "public") {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, d), 0o700))
+	}
+	for _, f := range []string{"deferred/A07A81514", "deferred/B18B92625"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f), nil, 0o600))
+	}
+
+	// A showq socket that lists a message, then stalls.
+	path := filepath.Join(dir, "public/showq")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "queue_name\x00deferred\x00queue_id\x00A07A81514\x00time\x00%d\x00size\x001000\x00\x00queue_name\x00", time.Now().Unix())
+		}
+	}()
+
+	e, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	e.SetShowqPaths(map[string]string{"postfix": path})
+	e.SetShowqTimeout(200 * time.Millisecond)
+	e.SetShowqFilesystemFallback(true)
+
+	metrics, err := e.scrapeShowq(context.Background(), "postfix")
+	assert.Error(t, err, "The timeout of showq should be reported.")
+
+	series := make(map[string]int)
+	deferred := 0.0
+	for _, m := range metrics {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		series[m.Desc().String()+pb.String()]++
+		if m.Desc() == showqQueueMessagesDesc && pb.GetLabel()[1].GetValue() == "deferred" {
+			deferred = pb.GetGauge().GetValue()
+		}
+	}
+	for s, n := range series {
+		assert.Equal(t, 1, n, "%s should be sent once.", s)
+	}
+	assert.Equal(t, 2.0, deferred, "The queue files should be counted.")
+}
+
+func TestCollectShowq_ForcedExpire(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Unix()
+	binary := fmt.Sprintf("queue_name\x00deferred\x00queue_id\x00A07A81514\x00time\x00%d\x00size\x001000\x00forced_expire\x001\x00\x00", now) +
+		fmt.Sprintf("queue_name\x00deferred\x00queue_id\x00B18B92625\x00time\x00%d\x00size\x00500\x00forced_expire\x000\x00\x00", now)
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{})
+	require.NoError(t, collectBinaryShowq(stats, strings.NewReader(binary)))
+	assert.Equal(t, 1.0, stats.queues["deferred"].forcedExpire)
+
+	postqueue := `{"queue_name": "deferred", "queue_id": "A07A81514", "arrival_time": 0, "message_size": 100, "forced_expire": true, "recipients": []}
+{"queue_name": "active", "queue_id": "B18B92625", "arrival_time": 0, "message_size": 100, "forced_expire": false, "recipients": []}
+`
+	stats = newShowqStats("postfix", binaryShowqQueues, ShowqOptions{})
+	require.NoError(t, collectPostqueueJSON(stats, strings.NewReader(postqueue)))
+	assert.Equal(t, 1.0, stats.queues["deferred"].forcedExpire)
+	assert.Equal(t, 0.0, stats.queues["active"].forcedExpire)
+
+	const mailq = `A07A81514#    5156 Tue Feb 14 13:13:54  sender@example.com
+                                         a@example.net
+`
+	stats = newShowqStats("postfix", textualShowqQueues, ShowqOptions{})
+	require.NoError(t, CollectTextualShowqFromScanner(stats, strings.NewReader(mailq)))
+	assert.Equal(t, 1.0, stats.queues["other"].forcedExpire)
+}
+
+func TestShowqStats_MailerDaemon(t *testing.T) {
+	t.Parallel()
+
+	add := func(stats *showqStats) {
+		stats.add(&queuedMessage{queue: "deferred", sender: "", size: 100})
+		stats.add(&queuedMessage{queue: "deferred", sender: "MAILER-DAEMON", size: 100})
+		stats.add(&queuedMessage{queue: "deferred", sender: "alice@example.com", size: 100})
+	}
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{})
+	add(stats)
+	assert.Equal(t, 3.0, stats.queues["deferred"].messages)
+
+	stats = newShowqStats("postfix", binaryShowqQueues, ShowqOptions{MailerDaemon: "exclude"})
+	add(stats)
+	assert.Equal(t, 1.0, stats.queues["deferred"].messages)
+	assert.Equal(t, 100.0, stats.queues["deferred"].bytes)
+	assert.Empty(t, stats.mailerDaemon)
+
+	stats = newShowqStats("postfix", binaryShowqQueues, ShowqOptions{MailerDaemon: "separate"})
+	add(stats)
+	assert.Equal(t, 1.0, stats.queues["deferred"].messages)
+	assert.Equal(t, map[string]float64{"deferred": 2}, stats.mailerDaemon)
+}
+
+func TestShowqStats_Domains(t *testing.T) {
+	t.Parallel()
+
+	add := func(stats *showqStats) {
+		stats.add(&queuedMessage{queue: "deferred", sender: "alice@example.com"})
+		stats.add(&queuedMessage{queue: "deferred", sender: "bob@example.net", recipients: []queuedRecipient{{address: "carol@mail.example.com"}}})
+		stats.add(&queuedMessage{queue: "deferred", sender: "dave@example.org", recipients: []queuedRecipient{{address: "erin@example.net"}}})
+		stats.add(&queuedMessage{queue: "deferred", sender: "frank@notexample.com"})
+	}
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{Domains: []string{"example.com"}})
+	add(stats)
+	assert.Equal(t, 2.0, stats.queues["deferred"].messages)
+
+	stats = newShowqStats("postfix", binaryShowqQueues, ShowqOptions{ExcludeDomains: []string{"example.net"}})
+	add(stats)
+	assert.Equal(t, 2.0, stats.queues["deferred"].messages)
+
+	stats = newShowqStats("postfix", binaryShowqQueues, ShowqOptions{Domains: []string{"example.com"}, ExcludeDomains: []string{"example.net"}})
+	add(stats)
+	assert.Equal(t, 1.0, stats.queues["deferred"].messages)
+}
+
+func TestCollectTextualShowq_NestedReasons(t *testing.T) {
+	t.Parallel()
+
+	const mailq = `-Queue ID- --Size-- ----Arrival Time---- -Sender/Recipient-------
+A07A81514     5156 Tue Feb 14 13:13:54  sender@example.com
+(host mx.example.net[192.0.2.1] said: 451 4.3.0 Temporary failure (in reply to RCPT TO command)
+    and the connection was closed (lost))
+                                         a@example.net
+(connect to mx.example.org[192.0.2.2]:25: Connection timed out)
+                                         b@example.org
+
+-- 6 Kbytes in 1 Request.
+`
+
+	stats := newShowqStats("postfix", textualShowqQueues, ShowqOptions{})
+	require.NoError(t, CollectTextualShowqFromScanner(stats, strings.NewReader(mailq)))
+	assert.Equal(t, 2.0, stats.queues["other"].recipients)
+	assert.Equal(t, map[string]float64{"remote_4xx": 1, "connection_timed_out": 1}, stats.deferredReasons)
+}
+
+// nativeHistograms returns the number of histograms sent by `collect`,
+// and how many of them are native.
+func nativeHistograms(t *testing.T, collect func(chan<- prometheus.Metric)) (histograms, native int) {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 1000)
+	collect(ch)
+	close(ch)
+	for m := range ch {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		if h := pb.GetHistogram(); h != nil {
+			histograms++
+			assert.NotEmpty(t, h.Bucket, "The classic buckets should be kept.")
+			if h.Schema != nil {
+				native++
+			}
+		}
+	}
+
+	return histograms, native
+}
+
+func TestShowqStats_NativeHistograms(t *testing.T) {
+	t.Parallel()
+
+	stats := newShowqStats("postfix", binaryShowqQueues, ShowqOptions{})
+	stats.add(&queuedMessage{queue: "deferred", size: 1000, age: 60})
+	histograms, native := nativeHistograms(t, stats.Collect)
+	assert.Equal(t, 2*len(binaryShowqQueues), histograms)
+	assert.Equal(t, 0, native)
+
+	stats = newShowqStats("postfix", binaryShowqQueues, ShowqOptions{NativeHistogramBucketFactor: 1.1})
+	stats.add(&queuedMessage{queue: "deferred", size: 1000, age: 60})
+	histograms, native = nativeHistograms(t, stats.Collect)
+	assert.Equal(t, 2*len(binaryShowqQueues), histograms)
+	assert.Equal(t, histograms, native)
 }
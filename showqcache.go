@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var showqCacheAgeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName("postfix", "showq", "cache_age_seconds"),
+	"Seconds since the mail queue metrics were last refreshed successfully.",
+	[]string{"name"}, nil)
+
+// A showqCacheEntry holds the mail queue metrics of an instance.
+type showqCacheEntry struct {
+	metrics []prometheus.Metric // of the last successful refresh
+	updated time.Time           // of the last successful refresh
+	up      bool                // whether the last refresh was successful
+}
+
+// A showqCache keeps the mail queue metrics of each instance, refreshed
+// in the background, so scrapes don't wait for showq to list large
+// queues.
+type showqCache struct {
+	mu      sync.Mutex
+	entries map[string]*showqCacheEntry // by instance
+}
+
+func newShowqCache() *showqCache {
+	return &showqCache{entries: make(map[string]*showqCacheEntry)}
+}
+
+// Update records the result of refreshing the metrics of an instance at
+// `at`. On errors, the metrics of the last successful refresh are kept.
+func (c *showqCache) Update(instance string, metrics []prometheus.Metric, err error, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[instance]
+	if entry == nil {
+		entry = &showqCacheEntry{}
+		c.entries[instance] = entry
+	}
+	entry.up = err == nil
+	if err == nil {
+		entry.metrics, entry.updated = metrics, at
+	}
+}
+
+// Collect sends the cached metrics of an instance, with their age at
+// `now`. Nothing is sent before the first refresh.
+func (c *showqCache) Collect(instance string, now time.Time, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[instance]
+	if entry == nil {
+		return
+	}
+	for _, m := range entry.metrics {
+		ch <- m
+	}
+	up := 0.0
+	if entry.up {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(postfixUpDesc, prometheus.GaugeValue, up, instance)
+	if !entry.updated.IsZero() {
+		ch <- prometheus.MustNewConstMetric(showqCacheAgeDesc, prometheus.GaugeValue, now.Sub(entry.updated).Seconds(), instance)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gaugeValue returns the value of a gauge.
+func gaugeValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+
+	var pb dto.Metric
+	require.NoError(t, m.Write(&pb))
+
+	return pb.GetGauge().GetValue()
+}
+
+func TestShowqCache(t *testing.T) {
+	t.Parallel()
+
+	collect := func(c *showqCache, now time.Time) []prometheus.Metric {
+		ch := make(chan prometheus.Metric, 10)
+		c.Collect("postfix", now, ch)
+		close(ch)
+
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+
+		return metrics
+	}
+
+	c := newShowqCache()
+	start := time.Unix(1600000000, 0)
+	assert.Empty(t, collect(c, start), "Nothing should be collected before the first refresh.")
+
+	queued := prometheus.MustNewConstMetric(showqQueueMessagesDesc, prometheus.GaugeValue, 3, "postfix", "active")
+	c.Update("postfix", []prometheus.Metric{queued}, nil, start)
+	metrics := collect(c, start.Add(time.Minute))
+	require.Len(t, metrics, 3)
+	assert.Equal(t, queued, metrics[0])
+
+	c.Update("postfix", nil, errors.New("connection refused"), start.Add(time.Minute))
+	metrics = collect(c, start.Add(2*time.Minute))
+	require.Len(t, metrics, 3, "The metrics of the last successful refresh should be kept.")
+	assert.Equal(t, queued, metrics[0])
+	assert.Equal(t, 0.0, gaugeValue(t, metrics[1]), "postfix_up should reflect the failed refresh.")
+	assert.Equal(t, 120.0, gaugeValue(t, metrics[2]), "The cache age should count from the last successful refresh.")
+}
+
+func TestPostfixExporter_RefreshShowq(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "postqueue.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"queue_name": "hold", "queue_id": "C29CA3736", "arrival_time": 0, "message_size": 200, "recipients": []}`+"\n"), 0o644))
+
+	e, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	e.SetShowqPaths(map[string]string{"": path})
+	e.SetShowqInterval(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.RefreshShowq(ctx)
+		close(done)
+	}()
+
+	var up float64
+	require.Eventually(t, func() bool {
+		ch := make(chan prometheus.Metric, 100)
+		e.Collect(ch)
+		close(ch)
+		for m := range ch {
+			if m.Desc() == postfixUpDesc {
+				up = gaugeValue(t, m)
+
+				return true
+			}
+		}
+
+		return false
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, 1.0, up)
+}
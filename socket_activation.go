@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/coreos/go-systemd/v22/activation"
+)
+
+// Names of the sockets passed by systemd, as configured with
+// FileDescriptorName= in the socket units.
+const (
+	systemdSocketWeb    = "web"
+	systemdSocketSyslog = "syslog"
+)
+
+var (
+	systemdSocketsOnce sync.Once
+	systemdSockets     map[string]*os.File
+)
+
+// systemdSocket returns the socket with the given name, passed by
+// systemd via socket activation (see sd_listen_fds(3)).
+func systemdSocket(name string) (*os.File, error) {
+	systemdSocketsOnce.Do(func() {
+		systemdSockets = make(map[string]*os.File)
+		for _, f := range activation.Files(true) {
+			systemdSockets[f.Name()] = f
+		}
+	})
+
+	f, ok := systemdSockets[name]
+	if !ok {
+		return nil, fmt.Errorf("systemd did not pass a socket named %q", name)
+	}
+
+	return f, nil
+}
+
+// systemdListener returns the stream socket with the given name, passed
+// by systemd.
+func systemdListener(name string) (net.Listener, error) {
+	f, err := systemdSocket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.FileListener(f)
+}
+
+// systemdPacketConn returns the datagram socket with the given name,
+// passed by systemd.
+func systemdPacketConn(name string) (net.PacketConn, error) {
+	f, err := systemdSocket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.FilePacketConn(f)
+}
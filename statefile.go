@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stateFileName derives a file name for persisting state of the
+// resource identified by `key` (e.g. a log file path) in `dir`.
+func stateFileName(dir, prefix, key string) string {
+	key = strings.Trim(key, string(filepath.Separator))
+	key = strings.ReplaceAll(key, string(filepath.Separator), "_")
+
+	return filepath.Join(dir, prefix+"-"+key+".json")
+}
+
+// readStateFile decodes the JSON state stored at `path` into `v`. It
+// returns false (and no error) if the file does not exist.
+func readStateFile(path string, v interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(data, v)
+}
+
+// writeStateFile atomically replaces the state file at `path` with
+// the JSON encoding of `v`.
+func writeStateFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
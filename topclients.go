@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var topClientConnectionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName("postfix", "smtpd", "top_client_connections"),
+	"Exponentially decaying number of connections of the clients which connect the most.",
+	[]string{"name", "client", "hostname"}, nil)
+
+// A topClientKey identifies a client of a Postfix instance.
+type topClientKey struct {
+	instance, ip string
+}
+
+// A topClient is the decaying connection count of a client.
+type topClient struct {
+	hostname string
+	score    float64
+	updated  time.Time
+}
+
+// A topClientTracker keeps a table of the clients with the most
+// connections, with counts decaying exponentially. At most `maxEntries`
+// clients are tracked, the one with the lowest count is evicted first.
+type topClientTracker struct {
+	topN       int
+	maxEntries int
+	halfLife   time.Duration
+
+	mu      sync.Mutex
+	clients map[topClientKey]*topClient
+}
+
+func newTopClientTracker(topN int, halfLife time.Duration) *topClientTracker {
+	maxEntries := 10 * topN
+	if maxEntries < 100 {
+		maxEntries = 100
+	}
+
+	return &topClientTracker{
+		topN:       topN,
+		maxEntries: maxEntries,
+		halfLife:   halfLife,
+		clients:    make(map[topClientKey]*topClient),
+	}
+}
+
+// decayed returns the score of `c` at `now`.
+func (t *topClientTracker) decayed(c *topClient, now time.Time) float64 {
+	return c.score * math.Exp2(-float64(now.Sub(c.updated))/float64(t.halfLife))
+}
+
+// AddConnection counts a connection of a client.
+func (t *topClientTracker) AddConnection(instance, ip, hostname string) {
+	now := timeNow()
+	key := topClientKey{instance, ip}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clients[key]
+	if !ok {
+		if len(t.clients) >= t.maxEntries {
+			t.evict(now)
+		}
+		c = &topClient{updated: now}
+		t.clients[key] = c
+	}
+	c.score = t.decayed(c, now) + 1
+	c.updated = now
+	c.hostname = hostname
+}
+
+// evict removes the client with the lowest score. The caller must hold
+// t.mu.
+func (t *topClientTracker) evict(now time.Time) {
+	var (
+		lowest      topClientKey
+		lowestScore = math.Inf(1)
+	)
+	for key, c := range t.clients {
+		if score := t.decayed(c, now); score < lowestScore {
+			lowest, lowestScore = key, score
+		}
+	}
+	delete(t.clients, lowest)
+}
+
+// Describe implements prometheus.Collector.
+func (t *topClientTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- topClientConnectionsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (t *topClientTracker) Collect(ch chan<- prometheus.Metric) {
+	type entry struct {
+		key      topClientKey
+		hostname string
+		score    float64
+	}
+
+	now := timeNow()
+
+	t.mu.Lock()
+	entries := make([]entry, 0, len(t.clients))
+	for key, c := range t.clients {
+		entries = append(entries, entry{key, c.hostname, t.decayed(c, now)})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].score != entries[j].score {
+			return entries[i].score > entries[j].score
+		}
+
+		return entries[i].key.ip < entries[j].key.ip
+	})
+	if len(entries) > t.topN {
+		entries = entries[:t.topN]
+	}
+	for _, e := range entries {
+		ch <- prometheus.MustNewConstMetric(topClientConnectionsDesc, prometheus.GaugeValue, e.score, e.key.instance, e.key.ip, e.hostname)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostfixExporter_TopClients(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetTopClients(1, time.Hour)
+
+	ex.CollectFromLogLine("postfix", "Oct  3 10:10:00 mail postfix/smtpd[9912]: connect from mail.example.com[192.0.2.1]")
+	ex.CollectFromLogLine("postfix", "Oct  3 10:10:00 mail postfix/smtpd[9912]: connect from unknown[192.0.2.2]")
+	ex.CollectFromLogLine("postfix", "Oct  3 10:10:01 mail postfix/smtpd[9913]: connect from unknown[192.0.2.2]")
+
+	// timeNow is fixed in tests, so nothing has decayed yet.
+	expected := `
+# HELP postfix_smtpd_top_client_connections Exponentially decaying number of connections of the clients which connect the most.
+# TYPE postfix_smtpd_top_client_connections gauge
+postfix_smtpd_top_client_connections{client="192.0.2.2",hostname="unknown",name="postfix"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(ex.topClients, strings.NewReader(expected)))
+}
+
+func TestTopClientTracker_Evict(t *testing.T) {
+	t.Parallel()
+
+	tr := newTopClientTracker(1, time.Hour)
+	tr.AddConnection("postfix", "192.0.2.1", "a.example.com")
+	tr.AddConnection("postfix", "192.0.2.1", "a.example.com")
+	for i := 0; i < tr.maxEntries; i++ {
+		tr.AddConnection("postfix", "198.51.100."+string(rune('a'+i%26))+string(rune('a'+i/26)), "unknown")
+	}
+
+	assert.Len(t, tr.clients, tr.maxEntries, "The table should be capped.")
+	assert.Contains(t, tr.clients, topClientKey{"postfix", "192.0.2.1"}, "The client with the most connections should be kept.")
+}
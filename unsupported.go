@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// unsupportedSamples keeps the most recent unsupported log lines of each
+// subprocess, to show users which lines the exporter does not parse
+// without logging all of them.
+type unsupportedSamples struct {
+	size int
+
+	mu    sync.Mutex
+	lines map[string]*lineRing // by subprocess
+}
+
+// A lineRing is a ring buffer of log lines.
+type lineRing struct {
+	lines []string
+	next  int // index to write the next line to
+}
+
+func newUnsupportedSamples(size int) *unsupportedSamples {
+	return &unsupportedSamples{
+		size:  size,
+		lines: make(map[string]*lineRing),
+	}
+}
+
+// Add records an unsupported line, replacing the oldest line of the
+// subprocess if its buffer is full.
+func (s *unsupportedSamples) Add(subprocess, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.lines[subprocess]
+	if r == nil {
+		r = &lineRing{lines: make([]string, 0, s.size)}
+		s.lines[subprocess] = r
+	}
+	if len(r.lines) < s.size {
+		r.lines = append(r.lines, line)
+	} else {
+		r.lines[r.next] = line
+	}
+	r.next = (r.next + 1) % s.size
+}
+
+// Lines returns the recorded lines of each subprocess, oldest first.
+func (s *unsupportedSamples) Lines() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := make(map[string][]string, len(s.lines))
+	for subprocess, r := range s.lines {
+		if len(r.lines) < s.size {
+			lines[subprocess] = append([]string(nil), r.lines...)
+		} else {
+			lines[subprocess] = append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+		}
+	}
+
+	return lines
+}
+
+// ServeHTTP writes the recorded lines as plain text, grouped by
+// subprocess.
+func (s *unsupportedSamples) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	lines := s.Lines()
+	subprocesses := make([]string, 0, len(lines))
+	for subprocess := range lines {
+		subprocesses = append(subprocesses, subprocess)
+	}
+	sort.Strings(subprocesses)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, subprocess := range subprocesses {
+		name := subprocess
+		if name == "" {
+			name = "(unknown)"
+		}
+		fmt.Fprintf(w, "# %s\n", name)
+		for _, line := range lines[subprocess] {
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnsupportedSamples(t *testing.T) {
+	t.Parallel()
+
+	s := newUnsupportedSamples(2)
+	s.Add("smtpd", "a")
+	s.Add("smtpd", "b")
+	s.Add("smtpd", "c")
+	s.Add("", "d")
+	assert.Equal(t, map[string][]string{"smtpd": {"b", "c"}, "": {"d"}}, s.Lines())
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/debug/unsupported", nil))
+	assert.Equal(t, "# (unknown)\nd\n\n# smtpd\nb\nc\n\n", w.Body.String())
+}
+
+func TestPostfixExporter_UnsupportedSamples(t *testing.T) {
+	t.Parallel()
+
+	ex, err := NewPostfixExporter([]string{"postfix"}, nil, false)
+	require.NoError(t, err)
+	ex.SetUnsupportedSamples(10)
+
+	const line = "Oct  4 09:31:00 mail postfix/smtpd[4814]: something new"
+	ex.CollectFromLogLine("postfix", line)
+	assert.Equal(t, map[string][]string{"smtpd": {line}}, ex.unsupportedSamples.Lines())
+}